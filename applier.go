@@ -0,0 +1,700 @@
+package pglogrepl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// ApplyTarget is the database an Applier replays changes into.
+type ApplyTarget interface {
+	Begin(ctx context.Context) error
+	// Exec executes sql with the parameters in text format. A nil parameter is NULL.
+	Exec(ctx context.Context, sql string, args [][]byte) error
+	// QueryRow executes sql like Exec and returns the text format values of the first row, nil if there are no rows.
+	// A nil value is NULL.
+	QueryRow(ctx context.Context, sql string, args [][]byte) ([][]byte, error)
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+type pgConnApplyTarget struct {
+	conn *pgconn.PgConn
+}
+
+// NewPgConnApplyTarget returns a target that executes the statements on conn. conn must be a regular, not a
+// replication, connection.
+func NewPgConnApplyTarget(conn *pgconn.PgConn) ApplyTarget {
+	return &pgConnApplyTarget{conn: conn}
+}
+
+func (t *pgConnApplyTarget) Begin(ctx context.Context) error {
+	_, err := t.conn.Exec(ctx, "BEGIN").ReadAll()
+	return err
+}
+
+func (t *pgConnApplyTarget) Exec(ctx context.Context, sql string, args [][]byte) error {
+	return t.conn.ExecParams(ctx, sql, args, nil, nil, nil).Read().Err
+}
+
+func (t *pgConnApplyTarget) QueryRow(ctx context.Context, sql string, args [][]byte) ([][]byte, error) {
+	result := t.conn.ExecParams(ctx, sql, args, nil, nil, nil).Read()
+	if result.Err != nil || len(result.Rows) == 0 {
+		return nil, result.Err
+	}
+	return result.Rows[0], nil
+}
+
+func (t *pgConnApplyTarget) Commit(ctx context.Context) error {
+	_, err := t.conn.Exec(ctx, "COMMIT").ReadAll()
+	return err
+}
+
+func (t *pgConnApplyTarget) Rollback(ctx context.Context) error {
+	_, err := t.conn.Exec(ctx, "ROLLBACK").ReadAll()
+	return err
+}
+
+type sqlApplyTarget struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewSQLApplyTarget returns a target that executes the statements in transactions of db. The driver must use the
+// PostgreSQL placeholder syntax ($1, $2, ...).
+func NewSQLApplyTarget(db *sql.DB) ApplyTarget {
+	return &sqlApplyTarget{db: db}
+}
+
+func (t *sqlApplyTarget) Begin(ctx context.Context) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	t.tx = tx
+	return nil
+}
+
+func (t *sqlApplyTarget) Exec(ctx context.Context, sql string, args [][]byte) error {
+	if t.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg != nil {
+			values[i] = string(arg)
+		}
+	}
+	_, err := t.tx.ExecContext(ctx, sql, values...)
+	return err
+}
+
+func (t *sqlApplyTarget) QueryRow(ctx context.Context, query string, args [][]byte) ([][]byte, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg != nil {
+			values[i] = string(arg)
+		}
+	}
+
+	var rows *sql.Rows
+	var err error
+	if t.tx != nil {
+		rows, err = t.tx.QueryContext(ctx, query, values...)
+	} else {
+		rows, err = t.db.QueryContext(ctx, query, values...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	row := make([][]byte, len(raw))
+	for i, v := range raw {
+		if v != nil {
+			row[i] = append([]byte{}, v...)
+		}
+	}
+	return row, nil
+}
+
+func (t *sqlApplyTarget) Commit(context.Context) error {
+	if t.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+	err := t.tx.Commit()
+	t.tx = nil
+	return err
+}
+
+func (t *sqlApplyTarget) Rollback(context.Context) error {
+	if t.tx == nil {
+		return nil
+	}
+	err := t.tx.Rollback()
+	t.tx = nil
+	return err
+}
+
+// ApplyTable maps a source table to a target table.
+type ApplyTable struct {
+	// Schema and Table name the target table. Empty values keep the source names.
+	Schema string
+	Table  string
+	// Columns maps source column names to target column names. Columns missing from a non-nil map are not
+	// applied; key columns must be mapped. A nil map applies every column under its source name.
+	Columns map[string]string
+}
+
+// ApplierOptions configures an Applier.
+type ApplierOptions struct {
+	// Tables maps source tables by full name (schema.table) to target tables.
+	Tables map[string]ApplyTable
+	// OnlyMapped skips the changes of tables missing from Tables. Otherwise they are applied to the table with the
+	// same name.
+	OnlyMapped bool
+
+	// ProgressTable enables exactly-once apply. The end LSN of every applied transaction is stored in this target
+	// table, given as table or schema.table, in the same target transaction as its changes. The table is created by
+	// LoadProgress if it does not exist.
+	ProgressTable string
+	// ProgressName identifies the stream in the progress table, e.g. the slot name. Defaults to "default".
+	ProgressName string
+
+	// Origin is the replication origin applied transactions are tagged with, see SetupOrigin. Its progress is
+	// advanced to the end LSN of every applied transaction.
+	Origin string
+	// SkipOrigins drops the transactions that the source itself replayed from one of these origins, as announced by
+	// Origin messages. This prevents loops in bidirectional setups with servers that do not support the pgoutput
+	// option origin 'none'.
+	SkipOrigins []string
+}
+
+// Applier replays decoded changes into a target database with parameterized statements. It is stateful: every
+// change of the stream, including Begin and Commit, must be passed in order.
+//
+// Each source transaction is applied as one target transaction. Updates and deletes find the target row by the
+// replica identity columns; unchanged TOASTed values are left untouched. When the source columns carry no key flags,
+// e.g. with test_decoding before it printed an old key, the replica identity index or primary key of the target table
+// is used instead. After an error the target transaction is
+// rolled back and changes are rejected until the next Begin, so streaming should restart from the last applied
+// transaction.
+//
+// With a ProgressTable the last applied position survives crashes of either side: LoadProgress reads it back, and
+// transactions ending at or before it are skipped when the source sends them again. When Begin carries the final LSN
+// of the transaction, as with pgoutput, they are skipped from the Begin on. Otherwise whether a transaction was
+// applied before is only known at its Commit: its changes are applied and rolled back, and their errors are ignored.
+type Applier struct {
+	target  ApplyTarget
+	options ApplierOptions
+	inTx    bool
+	failed  bool
+	err     error // error that aborted the transaction, reported at Commit
+	skipTx  bool  // the transaction comes from one of SkipOrigins or was applied before
+	applied LSN
+	skipped int
+	keys    map[string]map[string]bool // quoted key columns of target tables by quoted name
+}
+
+// NewApplier ...
+func NewApplier(target ApplyTarget, options ApplierOptions) *Applier {
+	return &Applier{target: target, options: options}
+}
+
+// Apply applies a change. Changes received outside a transaction are applied in a transaction of their own. Relation
+// and other messages are ignored.
+func (a *Applier) Apply(ctx context.Context, wd *WalData) error {
+	switch v := wd.Value.(type) {
+	case *BeginWalData:
+		if a.inTx {
+			if err := a.target.Rollback(ctx); err != nil {
+				return err
+			}
+		}
+		a.inTx, a.failed, a.err, a.skipTx = false, false, nil, false
+		if a.options.ProgressTable != "" && v.Lsn != 0 && v.Lsn < a.applied {
+			// The transaction was applied before, its changes are dropped and Commit counts it as skipped.
+			a.skipTx = true
+			return nil
+		}
+		if err := a.target.Begin(ctx); err != nil {
+			return err
+		}
+		a.inTx = true
+		return nil
+	case *OriginWalData:
+		for _, name := range a.options.SkipOrigins {
+			if v.Name == name {
+				a.skipTx = true
+			}
+		}
+		return nil
+	case *CommitWalData:
+		a.skipTx = false
+		if a.options.ProgressTable != "" && v.LsnTransaction <= a.applied {
+			// The transaction was applied before, its changes are dropped.
+			inTx := a.inTx
+			a.inTx, a.failed, a.err = false, false, nil
+			a.skipped++
+			if inTx {
+				return a.target.Rollback(ctx)
+			}
+			return nil
+		}
+		if a.failed {
+			err := a.err
+			a.failed, a.err = false, nil
+			if err == nil {
+				err = errors.New("transaction was aborted by an earlier error")
+			}
+			return err
+		}
+		if !a.inTx {
+			return nil
+		}
+		if err := a.saveProgress(ctx, v); err != nil {
+			a.inTx = false
+			if rerr := a.target.Rollback(ctx); rerr != nil {
+				return errors.Errorf("%v (rollback failed: %v)", err, rerr)
+			}
+			return err
+		}
+		a.inTx = false
+		if err := a.target.Commit(ctx); err != nil {
+			return err
+		}
+		if a.options.ProgressTable != "" {
+			a.applied = v.LsnTransaction
+		}
+		return nil
+	}
+
+	if a.skipTx {
+		return nil
+	}
+	if a.failed {
+		if a.err != nil {
+			return nil
+		}
+		return errors.New("transaction was aborted by an earlier error")
+	}
+	stmts, err := a.statements(ctx, wd)
+	if err != nil || len(stmts) == 0 {
+		return a.fail(ctx, err)
+	}
+
+	single := !a.inTx
+	if single {
+		if err := a.target.Begin(ctx); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		if err := a.target.Exec(ctx, stmt.sql, stmt.args); err != nil {
+			if single {
+				err = errors.Errorf("failed to apply %q: %w", stmt.sql, err)
+				if rerr := a.target.Rollback(ctx); rerr != nil {
+					return errors.Errorf("%v (rollback failed: %v)", err, rerr)
+				}
+				return err
+			}
+			return a.fail(ctx, errors.Errorf("failed to apply %q: %w", stmt.sql, err))
+		}
+	}
+	if single {
+		return a.target.Commit(ctx)
+	}
+	return nil
+}
+
+// LoadProgress creates the progress table if needed and returns the position of the last applied transaction, 0 if
+// nothing was applied yet. Replication should start from this position, and it should be reported to the source as
+// written and flushed position instead of the received position.
+func (a *Applier) LoadProgress(ctx context.Context) (LSN, error) {
+	if a.options.ProgressTable == "" {
+		return 0, errors.New("no progress table configured")
+	}
+
+	table := quoteQualifiedName(a.options.ProgressTable)
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name text PRIMARY KEY, lsn pg_lsn NOT NULL)", table)
+	if err := a.target.Exec(ctx, sql, nil); err != nil {
+		return 0, errors.Errorf("failed to create progress table: %w", err)
+	}
+
+	row, err := a.target.QueryRow(ctx, fmt.Sprintf("SELECT lsn FROM %s WHERE name = $1", table), [][]byte{[]byte(a.progressName())})
+	if err != nil {
+		return 0, errors.Errorf("failed to read progress: %w", err)
+	}
+	if len(row) == 0 || row[0] == nil {
+		return 0, nil
+	}
+	lsn, err := ParseLSN(string(row[0]))
+	if err != nil {
+		return 0, err
+	}
+	a.applied = lsn
+	return lsn, nil
+}
+
+// AppliedLSN returns the end LSN of the last transaction committed on the target with a ProgressTable.
+func (a *Applier) AppliedLSN() LSN {
+	return a.applied
+}
+
+// Skipped returns the number of transactions dropped because they were applied before.
+func (a *Applier) Skipped() int {
+	return a.skipped
+}
+
+func (a *Applier) progressName() string {
+	if a.options.ProgressName == "" {
+		return "default"
+	}
+	return a.options.ProgressName
+}
+
+// SetupOrigin creates the Origin if it does not exist, marks the target session as replaying from it and returns
+// its progress. It must be called before applying changes, in the session that applies them: a database/sql target
+// must be limited to a single connection.
+func (a *Applier) SetupOrigin(ctx context.Context) (LSN, error) {
+	if a.options.Origin == "" {
+		return 0, errors.New("no origin configured")
+	}
+	name := [][]byte{[]byte(a.options.Origin)}
+
+	sql := "SELECT pg_replication_origin_create($1) WHERE NOT EXISTS (SELECT 1 FROM pg_replication_origin WHERE roname = $1)"
+	if err := a.target.Exec(ctx, sql, name); err != nil {
+		return 0, errors.Errorf("failed to create replication origin: %w", err)
+	}
+	if err := a.target.Exec(ctx, "SELECT pg_replication_origin_session_setup($1)", name); err != nil {
+		return 0, errors.Errorf("failed to set up replication origin: %w", err)
+	}
+
+	row, err := a.target.QueryRow(ctx, "SELECT pg_replication_origin_progress($1, true)", name)
+	if err != nil {
+		return 0, errors.Errorf("failed to read replication origin progress: %w", err)
+	}
+	if len(row) == 0 || row[0] == nil {
+		return 0, nil
+	}
+	return ParseLSN(string(row[0]))
+}
+
+// saveProgress records the position of the committing transaction in the progress table and the origin.
+func (a *Applier) saveProgress(ctx context.Context, commit *CommitWalData) error {
+	lsn := []byte(commit.LsnTransaction.String())
+	if a.options.ProgressTable != "" {
+		sql := fmt.Sprintf("INSERT INTO %s (name, lsn) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET lsn = EXCLUDED.lsn",
+			quoteQualifiedName(a.options.ProgressTable))
+		if err := a.target.Exec(ctx, sql, [][]byte{[]byte(a.progressName()), lsn}); err != nil {
+			return errors.Errorf("failed to save progress: %w", err)
+		}
+	}
+	if a.options.Origin != "" {
+		commitTime := time.Now()
+		if commit.Timestamp != 0 {
+			commitTime = commit.CommitTime()
+		}
+		sql := "SELECT pg_replication_origin_xact_setup($1, $2)"
+		if err := a.target.Exec(ctx, sql, [][]byte{lsn, []byte(commitTime.Format(time.RFC3339Nano))}); err != nil {
+			return errors.Errorf("failed to set up replication origin of the transaction: %w", err)
+		}
+	}
+	return nil
+}
+
+// fail rolls back the current transaction if err is not nil.
+func (a *Applier) fail(ctx context.Context, err error) error {
+	if err == nil || !a.inTx {
+		return err
+	}
+	a.inTx, a.failed = false, true
+	if rerr := a.target.Rollback(ctx); rerr != nil {
+		err = errors.Errorf("%v (rollback failed: %v)", err, rerr)
+	}
+	if a.options.ProgressTable != "" {
+		a.err = err
+		return nil
+	}
+	return err
+}
+
+// applyStatement is a statement with its text format parameters.
+type applyStatement struct {
+	sql  string
+	args [][]byte
+}
+
+// statements returns the statements applying a change, none if the change is not applied.
+func (a *Applier) statements(ctx context.Context, wd *WalData) ([]applyStatement, error) {
+	switch v := wd.Value.(type) {
+	case *InsertWalData:
+		table, ok := a.table(&v.Relation)
+		if !ok {
+			return nil, nil
+		}
+		stmt := table.insert(&v.Tuples)
+		return []applyStatement{stmt}, nil
+	case *UpdateWalData:
+		table, ok := a.table(&v.Relation)
+		if !ok {
+			return nil, nil
+		}
+		key := &v.Tuples
+		if v.OldTuples != nil {
+			key = v.OldTuples
+		}
+		targetKeys, err := a.targetKeys(ctx, key, table)
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := table.update(&v.Tuples, key, targetKeys)
+		if err != nil || stmt == nil {
+			return nil, err
+		}
+		return []applyStatement{*stmt}, nil
+	case *DeleteWalData:
+		table, ok := a.table(&v.Relation)
+		if !ok {
+			return nil, nil
+		}
+		targetKeys, err := a.targetKeys(ctx, &v.Tuples, table)
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := table.delete(&v.Tuples, targetKeys)
+		if err != nil {
+			return nil, err
+		}
+		return []applyStatement{*stmt}, nil
+	case *TruncateWalData:
+		var names []string
+		for i := range v.Relations {
+			if table, ok := a.table(&v.Relations[i]); ok {
+				names = append(names, table.name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, nil
+		}
+		sql := "TRUNCATE TABLE " + strings.Join(names, ", ")
+		if v.IsRestartIdentity {
+			sql += " RESTART IDENTITY"
+		}
+		if v.IsCascade {
+			sql += " CASCADE"
+		}
+		return []applyStatement{{sql: sql}}, nil
+	}
+	return nil, nil
+}
+
+// targetKeys returns the key columns of the target table if the columns of key have no key flags, nil otherwise.
+// They are read from the replica identity index, or the primary key, of the target table once.
+func (a *Applier) targetKeys(ctx context.Context, key *TupleData, table *applyTableMapping) (map[string]bool, error) {
+	for _, t := range key.Tuples {
+		if t.RelCol.Flag {
+			return nil, nil
+		}
+	}
+	if keys, ok := a.keys[table.name]; ok {
+		return keys, nil
+	}
+
+	sql := `SELECT json_agg(a.attname ORDER BY a.attnum) FROM pg_catalog.pg_index i
+	JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indexrelid = (SELECT indexrelid FROM pg_catalog.pg_index WHERE indrelid = $1::regclass AND (indisreplident OR indisprimary)
+	ORDER BY indisreplident DESC LIMIT 1)`
+	row, err := a.target.QueryRow(ctx, sql, [][]byte{[]byte(table.name)})
+	if err != nil {
+		return nil, errors.Errorf("failed to read the key of %s: %w", table.name, err)
+	}
+	keys := map[string]bool{}
+	if len(row) == 1 && row[0] != nil {
+		var names []string
+		if err := json.Unmarshal(row[0], &names); err != nil {
+			return nil, errors.Errorf("bad key of %s: %w", table.name, err)
+		}
+		for _, name := range names {
+			keys[quoteIdentifier(name)] = true
+		}
+	}
+	if a.keys == nil {
+		a.keys = make(map[string]map[string]bool)
+	}
+	a.keys[table.name] = keys
+	return keys, nil
+}
+
+// applyTableMapping is the resolved mapping of a source relation.
+type applyTableMapping struct {
+	source  string
+	name    string // quoted target name
+	columns map[string]string
+}
+
+func (a *Applier) table(rel *RelationWalData) (*applyTableMapping, bool) {
+	mapping, ok := a.options.Tables[rel.FullName()]
+	if !ok && a.options.OnlyMapped {
+		return nil, false
+	}
+
+	schema, table := rel.Namespace, rel.RelationName
+	if mapping.Schema != "" {
+		schema = mapping.Schema
+	}
+	if mapping.Table != "" {
+		table = mapping.Table
+	}
+	return &applyTableMapping{
+		source:  rel.FullName(),
+		name:    quoteIdentifier(schema) + "." + quoteIdentifier(table),
+		columns: mapping.Columns,
+	}, true
+}
+
+// column returns the quoted target name of a source column.
+func (m *applyTableMapping) column(name string) (string, bool) {
+	if m.columns == nil {
+		return quoteIdentifier(name), true
+	}
+	target, ok := m.columns[name]
+	if !ok {
+		return "", false
+	}
+	return quoteIdentifier(target), true
+}
+
+func (m *applyTableMapping) insert(td *TupleData) applyStatement {
+	var columns, placeholders []string
+	var args [][]byte
+	for i := range td.Tuples {
+		t := &td.Tuples[i]
+		column, ok := m.column(t.RelCol.Name)
+		if !ok || t.IsTOAST {
+			continue
+		}
+		args = append(args, tupleArg(t))
+		columns = append(columns, column)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	if len(columns) == 0 {
+		return applyStatement{sql: fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", m.name)}
+	}
+	return applyStatement{
+		sql:  fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.name, strings.Join(columns, ", "), strings.Join(placeholders, ", ")),
+		args: args,
+	}
+}
+
+// update returns nil if none of the mapped columns changed.
+func (m *applyTableMapping) update(td, key *TupleData, targetKeys map[string]bool) (*applyStatement, error) {
+	var set []string
+	var args [][]byte
+	for i := range td.Tuples {
+		t := &td.Tuples[i]
+		column, ok := m.column(t.RelCol.Name)
+		if !ok || t.IsTOAST {
+			continue
+		}
+		args = append(args, tupleArg(t))
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if len(set) == 0 {
+		return nil, nil
+	}
+
+	where, args, err := m.where(key, args, targetKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &applyStatement{
+		sql:  fmt.Sprintf("UPDATE %s SET %s WHERE %s", m.name, strings.Join(set, ", "), where),
+		args: args,
+	}, nil
+}
+
+func (m *applyTableMapping) delete(key *TupleData, targetKeys map[string]bool) (*applyStatement, error) {
+	where, args, err := m.where(key, nil, targetKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &applyStatement{sql: fmt.Sprintf("DELETE FROM %s WHERE %s", m.name, where), args: args}, nil
+}
+
+// where returns the condition matching the replica identity columns of key, or the target columns targetKeys if it
+// is not nil, appending the parameters to args.
+func (m *applyTableMapping) where(key *TupleData, args [][]byte, targetKeys map[string]bool) (string, [][]byte, error) {
+	var conditions []string
+	for i := range key.Tuples {
+		t := &key.Tuples[i]
+		column, ok := m.column(t.RelCol.Name)
+		if targetKeys != nil {
+			if !ok || !targetKeys[column] {
+				continue
+			}
+		} else if !t.RelCol.Flag {
+			continue
+		}
+		if !ok {
+			return "", nil, errors.Errorf("key column %s of %s is not mapped", t.RelCol.Name, m.source)
+		}
+		switch {
+		case t.IsTOAST:
+			return "", nil, errors.Errorf("key column %s of %s has no value", t.RelCol.Name, m.source)
+		case t.IsNull:
+			conditions = append(conditions, column+" IS NULL")
+		default:
+			args = append(args, tupleArg(t))
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil, errors.Errorf("%s has no replica identity columns", m.source)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func tupleArg(t *Tuple) []byte {
+	if t.IsNull {
+		return nil
+	}
+	if t.Value == nil {
+		return []byte{}
+	}
+	return t.Value
+}
+
+// quoteQualifiedName quotes a table name that may be qualified with a schema.
+func quoteQualifiedName(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return quoteIdentifier(name[:i]) + "." + quoteIdentifier(name[i+1:])
+	}
+	return quoteIdentifier(name)
+}
+
+// quoteIdentifier quotes an SQL identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
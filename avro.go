@@ -0,0 +1,515 @@
+package pglogrepl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// AvroType is the type of an Avro field: a primitive type, optionally annotated with a logical type, or an array.
+type AvroType struct {
+	// Type is null, boolean, int, long, float, double, bytes, string or array.
+	Type string
+	// LogicalType is date, time-micros, timestamp-micros, local-timestamp-micros, uuid or decimal.
+	LogicalType string
+	Precision   int
+	Scale       int
+	// Items is the element type of an array. Elements are always nullable.
+	Items *AvroType
+}
+
+// AvroField is a field of an Avro record.
+type AvroField struct {
+	Name     string
+	Type     AvroType
+	Nullable bool
+	// Column is the name of the column the field was generated from. Field names are sanitized to be valid Avro
+	// names; Column is the original name.
+	Column string
+}
+
+// AvroSchema is an Avro record schema generated from a relation.
+type AvroSchema struct {
+	Name      string
+	Namespace string
+	Fields    []AvroField
+}
+
+// NewAvroSchema generates the record schema of rel. Key columns are required fields, other columns are nullable
+// unions with null as default. Types without an Avro equivalent are represented by their text format as strings.
+func NewAvroSchema(rel *RelationWalData) *AvroSchema {
+	s := &AvroSchema{Name: avroName(rel.RelationName), Namespace: avroName(rel.Namespace)}
+	for _, c := range rel.Columns {
+		t := avroColumnType(c.Type, c.Modifier)
+		if c.IsArray {
+			elem := t
+			t = AvroType{Type: "array", Items: &elem}
+		}
+		s.Fields = append(s.Fields, AvroField{Name: avroName(c.Name), Type: t, Nullable: !c.Flag, Column: c.Name})
+	}
+	return s
+}
+
+// FullName returns the namespace-qualified name of the record.
+func (s *AvroSchema) FullName() string {
+	if s.Namespace == "" {
+		return s.Name
+	}
+	return s.Namespace + "." + s.Name
+}
+
+// String returns the JSON representation of the schema.
+func (s *AvroSchema) String() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// avroName replaces the characters that are not valid in Avro names by underscores.
+func avroName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func avroColumnType(ty PgType, modifier int32) AvroType {
+	switch ty.Typname {
+	case "bool":
+		return AvroType{Type: "boolean"}
+	case "int2", "int4":
+		return AvroType{Type: "int"}
+	case "int8", "oid":
+		return AvroType{Type: "long"}
+	case "float4":
+		return AvroType{Type: "float"}
+	case "float8":
+		return AvroType{Type: "double"}
+	case "bytea":
+		return AvroType{Type: "bytes"}
+	case "date":
+		return AvroType{Type: "int", LogicalType: "date"}
+	case "time":
+		return AvroType{Type: "long", LogicalType: "time-micros"}
+	case "timestamp":
+		return AvroType{Type: "long", LogicalType: "local-timestamp-micros"}
+	case "timestamptz":
+		return AvroType{Type: "long", LogicalType: "timestamp-micros"}
+	case "uuid":
+		return AvroType{Type: "string", LogicalType: "uuid"}
+	case "numeric":
+		// The typmod of numeric(p,s) is ((p << 16) | s) + 4; unconstrained numerics have no fixed scale.
+		if modifier >= 4 {
+			m := modifier - 4
+			return AvroType{Type: "bytes", LogicalType: "decimal", Precision: int(m >> 16 & 0xffff), Scale: int(m & 0xffff)}
+		}
+	}
+	return AvroType{Type: "string"}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t AvroType) MarshalJSON() ([]byte, error) {
+	switch {
+	case t.Type == "array":
+		if t.Items == nil {
+			return nil, errors.New("avro array without items")
+		}
+		return json.Marshal(map[string]interface{}{"type": "array", "items": []interface{}{"null", *t.Items}})
+	case t.LogicalType == "decimal":
+		return json.Marshal(map[string]interface{}{"type": t.Type, "logicalType": t.LogicalType, "precision": t.Precision, "scale": t.Scale})
+	case t.LogicalType != "":
+		return json.Marshal(map[string]interface{}{"type": t.Type, "logicalType": t.LogicalType})
+	}
+	return json.Marshal(t.Type)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the types written by MarshalJSON.
+func (t *AvroType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = AvroType{Type: name}
+		return nil
+	}
+
+	var complex struct {
+		Type        string            `json:"type"`
+		LogicalType string            `json:"logicalType"`
+		Precision   int               `json:"precision"`
+		Scale       int               `json:"scale"`
+		Items       []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &complex); err != nil {
+		return errors.Errorf("unsupported avro type %s: %w", data, err)
+	}
+	*t = AvroType{Type: complex.Type, LogicalType: complex.LogicalType, Precision: complex.Precision, Scale: complex.Scale}
+	if complex.Type == "array" {
+		if len(complex.Items) != 2 {
+			return errors.Errorf("unsupported avro array items %s", data)
+		}
+		t.Items = &AvroType{}
+		return t.Items.UnmarshalJSON(complex.Items[1])
+	}
+	return nil
+}
+
+type avroFieldJSON struct {
+	Name    string          `json:"name"`
+	Type    json.RawMessage `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+	Column  string          `json:"pg.column,omitempty"`
+}
+
+type avroSchemaJSON struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace,omitempty"`
+	Fields    []avroFieldJSON `json:"fields"`
+}
+
+// MarshalJSON implements json.Marshaler. Nullable fields are written as ["null", type] unions with a null default.
+func (s *AvroSchema) MarshalJSON() ([]byte, error) {
+	out := avroSchemaJSON{Type: "record", Name: s.Name, Namespace: s.Namespace, Fields: []avroFieldJSON{}}
+	for _, f := range s.Fields {
+		t, err := json.Marshal(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		field := avroFieldJSON{Name: f.Name, Type: t}
+		if f.Column != f.Name {
+			field.Column = f.Column
+		}
+		if f.Nullable {
+			field.Type = append(append([]byte(`["null",`), t...), ']')
+			field.Default = json.RawMessage("null")
+		}
+		out.Fields = append(out.Fields, field)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the schemas written by MarshalJSON.
+func (s *AvroSchema) UnmarshalJSON(data []byte) error {
+	var in struct {
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Fields    []struct {
+			Name   string          `json:"name"`
+			Type   json.RawMessage `json:"type"`
+			Column string          `json:"pg.column"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Type != "record" {
+		return errors.Errorf("unsupported avro schema type %q", in.Type)
+	}
+
+	*s = AvroSchema{Name: in.Name, Namespace: in.Namespace}
+	for _, f := range in.Fields {
+		field := AvroField{Name: f.Name, Column: f.Column}
+		if field.Column == "" {
+			field.Column = f.Name
+		}
+
+		var union []json.RawMessage
+		if err := json.Unmarshal(f.Type, &union); err == nil {
+			if len(union) != 2 || string(union[0]) != `"null"` {
+				return errors.Errorf("unsupported avro union %s in field %s", f.Type, f.Name)
+			}
+			field.Nullable = true
+			f.Type = union[1]
+		}
+		if err := field.Type.UnmarshalJSON(f.Type); err != nil {
+			return err
+		}
+		s.Fields = append(s.Fields, field)
+	}
+	return nil
+}
+
+// Encode encodes a row as Avro binary data. Columns are matched by name, so td may hold only some of the columns,
+// e.g. the old key of a Delete; missing columns are written as null. Unchanged TOASTed values cannot be represented
+// and return an error; tables whose updates must be encoded in full need REPLICA IDENTITY FULL.
+func (s *AvroSchema) Encode(td *TupleData) ([]byte, error) {
+	tuples := make(map[string]*Tuple, len(td.Tuples))
+	for i := range td.Tuples {
+		tuples[td.Tuples[i].RelCol.Name] = &td.Tuples[i]
+	}
+
+	var buf []byte
+	for _, f := range s.Fields {
+		t, ok := tuples[f.Column]
+		if ok && t.IsTOAST {
+			return nil, errors.Errorf("column %s is an unchanged TOASTed value", f.Column)
+		}
+		if !ok || t.IsNull {
+			if !f.Nullable {
+				return nil, errors.Errorf("column %s is required by the avro schema but has no value", f.Column)
+			}
+			buf = appendAvroLong(buf, 0)
+			continue
+		}
+		if f.Nullable {
+			buf = appendAvroLong(buf, 1)
+		}
+
+		var err error
+		if buf, err = appendAvroValue(buf, &f.Type, string(t.Value)); err != nil {
+			return nil, errors.Errorf("failed to encode column %s: %w", f.Column, err)
+		}
+	}
+	return buf, nil
+}
+
+func appendAvroLong(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendAvroBytes(buf []byte, b []byte) []byte {
+	return append(appendAvroLong(buf, int64(len(b))), b...)
+}
+
+// appendAvroValue appends the Avro binary encoding of a value in PostgreSQL text format.
+func appendAvroValue(buf []byte, t *AvroType, s string) ([]byte, error) {
+	switch t.LogicalType {
+	case "date":
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroLong(buf, d.Unix()/86400), nil
+	case "time-micros":
+		d, err := time.Parse("15:04:05.999999", s)
+		if err != nil {
+			return nil, err
+		}
+		micros := (int64(d.Hour())*3600+int64(d.Minute())*60+int64(d.Second()))*1000000 + int64(d.Nanosecond()/1000)
+		return appendAvroLong(buf, micros), nil
+	case "local-timestamp-micros":
+		ts, err := time.Parse("2006-01-02 15:04:05.999999", s)
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroLong(buf, ts.UnixNano()/int64(time.Microsecond)), nil
+	case "timestamp-micros":
+		ts, err := parseTextTimestamp(s)
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroLong(buf, ts.UnixNano()/int64(time.Microsecond)), nil
+	case "decimal":
+		unscaled, err := parseDecimal(s, t.Scale)
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroBytes(buf, twosComplement(unscaled)), nil
+	}
+
+	switch t.Type {
+	case "boolean":
+		if s == "t" || s == "true" {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "int", "long":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroLong(buf, n), nil
+	case "float":
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, err
+		}
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(f)))
+		return append(buf, b[:]...), nil
+	case "double":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		return append(buf, b[:]...), nil
+	case "bytes":
+		if !strings.HasPrefix(s, `\x`) {
+			return nil, errors.Errorf("unsupported bytea format %q", s)
+		}
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, err
+		}
+		return appendAvroBytes(buf, b), nil
+	case "string":
+		return appendAvroBytes(buf, []byte(s)), nil
+	case "array":
+		elems, rest, err := parseTextArray(PgType{}, s)
+		if err != nil {
+			return nil, err
+		}
+		if rest != "" {
+			return nil, errors.Errorf("unexpected trailing data in array literal: %q", rest)
+		}
+		if len(elems) > 0 {
+			buf = appendAvroLong(buf, int64(len(elems)))
+			for _, elem := range elems {
+				if elem == nil {
+					buf = appendAvroLong(buf, 0)
+					continue
+				}
+				text, ok := elem.(string)
+				if !ok {
+					return nil, errors.New("multidimensional arrays are not supported")
+				}
+				if buf, err = appendAvroValue(appendAvroLong(buf, 1), t.Items, text); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return appendAvroLong(buf, 0), nil
+	}
+
+	return nil, errors.Errorf("unsupported avro type %q", t.Type)
+}
+
+// parseDecimal returns the unscaled value of a numeric in text format.
+func parseDecimal(s string, scale int) (*big.Int, error) {
+	digits := s
+	frac := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > scale {
+		return nil, errors.Errorf("numeric %s has more than %d fractional digits", s, scale)
+	}
+	n, ok := new(big.Int).SetString(digits+frac+strings.Repeat("0", scale-len(frac)), 10)
+	if !ok {
+		return nil, errors.Errorf("invalid numeric %q", s)
+	}
+	return n, nil
+}
+
+// twosComplement returns the big-endian two's complement representation of n with the fewest bytes.
+func twosComplement(n *big.Int) []byte {
+	if n.Sign() >= 0 {
+		b := n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+
+	// -n = ^(n-1): invert the bytes of |n|-1.
+	b := new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).Bytes()
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	if len(b) == 0 || b[0]&0x80 == 0 {
+		b = append([]byte{0xff}, b...)
+	}
+	return b
+}
+
+// AvroRecord is a row encoded with AvroEncoder.
+type AvroRecord struct {
+	Schema  *AvroSchema
+	Version int
+	Data    []byte
+}
+
+// AvroEncoder encodes the rows of changes as Avro binary data. It keeps the current schema of every relation and
+// creates a new schema version whenever the relation changes shape, registering it with the registry if one is set.
+type AvroEncoder struct {
+	registry *AvroRegistry
+	current  map[string]*avroVersion
+}
+
+type avroVersion struct {
+	schema  *AvroSchema
+	json    string
+	version int
+}
+
+// NewAvroEncoder creates an encoder. registry may be nil, in which case versions are counted per relation from 1.
+func NewAvroEncoder(registry *AvroRegistry) *AvroEncoder {
+	return &AvroEncoder{registry: registry, current: make(map[string]*avroVersion)}
+}
+
+// Encode encodes the new row of an Insert or Update or the old row of a Delete. Other changes return nil.
+func (e *AvroEncoder) Encode(wd *WalData) (*AvroRecord, error) {
+	var rel *RelationWalData
+	var td *TupleData
+	switch v := wd.Value.(type) {
+	case *InsertWalData:
+		rel, td = &v.Relation, &v.Tuples
+	case *UpdateWalData:
+		rel, td = &v.Relation, &v.Tuples
+	case *DeleteWalData:
+		rel, td = &v.Relation, &v.Tuples
+	case *RelationWalData:
+		_, err := e.version(v)
+		return nil, err
+	default:
+		return nil, nil
+	}
+
+	current, err := e.version(rel)
+	if err != nil {
+		return nil, err
+	}
+	data, err := current.schema.Encode(td)
+	if err != nil {
+		return nil, errors.Errorf("failed to encode row of %s: %w", rel.FullName(), err)
+	}
+	return &AvroRecord{Schema: current.schema, Version: current.version, Data: data}, nil
+}
+
+// Schema returns the current schema of rel and its version, creating a new version when the shape of rel changed.
+func (e *AvroEncoder) Schema(rel *RelationWalData) (*AvroSchema, int, error) {
+	current, err := e.version(rel)
+	if err != nil {
+		return nil, 0, err
+	}
+	return current.schema, current.version, nil
+}
+
+func (e *AvroEncoder) version(rel *RelationWalData) (*avroVersion, error) {
+	schema := NewAvroSchema(rel)
+	s := schema.String()
+
+	current, ok := e.current[rel.FullName()]
+	if ok && current.json == s {
+		return current, nil
+	}
+
+	next := &avroVersion{schema: schema, json: s, version: 1}
+	if ok {
+		next.version = current.version + 1
+	}
+	if e.registry != nil {
+		version, err := e.registry.Register(schema)
+		if err != nil {
+			return nil, err
+		}
+		next.version = version
+	}
+	e.current[rel.FullName()] = next
+	return next, nil
+}
@@ -0,0 +1,115 @@
+package pglogrepl_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func testAvroRelation(columns ...pglogrepl.RelationColumn) *pglogrepl.RelationWalData {
+	return &pglogrepl.RelationWalData{ID: 1, Namespace: "public", RelationName: "orders", Columns: columns, ColumnsNum: int16(len(columns))}
+}
+
+func testColumn(name string, oid int, key bool, modifier int32) pglogrepl.RelationColumn {
+	ty, isArray := pglogrepl.GetPgTypeById(oid)
+	return pglogrepl.RelationColumn{Name: name, Type: ty, IsArray: isArray, Flag: key, Modifier: modifier}
+}
+
+func TestAvroSchema(t *testing.T) {
+	rel := testAvroRelation(
+		testColumn("id", 20, true, -1),
+		testColumn("total", 1700, false, (10<<16|2)+4),
+		testColumn("created at", 1184, false, -1),
+		testColumn("tags", 1009, false, -1),
+	)
+
+	schema := pglogrepl.NewAvroSchema(rel)
+	assert.JSONEq(t, `{"type":"record","name":"orders","namespace":"public","fields":[
+		{"name":"id","type":"long"},
+		{"name":"total","type":["null",{"type":"bytes","logicalType":"decimal","precision":10,"scale":2}],"default":null},
+		{"name":"created_at","type":["null",{"type":"long","logicalType":"timestamp-micros"}],"default":null,"pg.column":"created at"},
+		{"name":"tags","type":["null",{"type":"array","items":["null","string"]}],"default":null}]}`, schema.String())
+
+	var parsed pglogrepl.AvroSchema
+	require.NoError(t, parsed.UnmarshalJSON([]byte(schema.String())))
+	assert.Equal(t, schema, &parsed)
+
+	tuple := func(col pglogrepl.RelationColumn, value string) pglogrepl.Tuple {
+		return pglogrepl.Tuple{RelCol: col, Value: []byte(value)}
+	}
+	td := &pglogrepl.TupleData{Tuples: []pglogrepl.Tuple{
+		tuple(rel.Columns[0], "-2"),
+		tuple(rel.Columns[1], "-1.5"),
+		tuple(rel.Columns[2], "1970-01-01 00:00:01.5+00"),
+		tuple(rel.Columns[3], `{a,NULL}`),
+	}}
+	data, err := schema.Encode(td)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x03,                   // id: zigzag -2
+		0x02, 0x04, 0xff, 0x6a, // total: union branch 1, 2 bytes, -150
+		0x02, 0xc0, 0x8d, 0xb7, 0x01, // created_at: union branch 1, zigzag 1500000 micros
+		0x02, 0x04, 0x02, 0x02, 'a', 0x00, 0x00, // tags: union branch 1, block of 2 items ("a", null), end
+	}, data)
+
+	// The old key of a Delete has only the key column.
+	data, err = schema.Encode(&pglogrepl.TupleData{Tuples: td.Tuples[:1]})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x03, 0x00, 0x00, 0x00}, data)
+
+	_, err = schema.Encode(&pglogrepl.TupleData{Tuples: []pglogrepl.Tuple{{RelCol: rel.Columns[0], IsTOAST: true}}})
+	assert.Error(t, err)
+}
+
+func TestAvroRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pglogrepl_avro")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	registry, err := pglogrepl.NewAvroRegistry(dir, pglogrepl.AvroCompatibilityBackward)
+	require.NoError(t, err)
+	enc := pglogrepl.NewAvroEncoder(registry)
+
+	v1 := testAvroRelation(testColumn("id", 23, true, -1))
+	insert := &pglogrepl.WalData{Type: pglogrepl.Insert, Value: &pglogrepl.InsertWalData{Relation: *v1,
+		Tuples: pglogrepl.TupleData{Tuples: []pglogrepl.Tuple{{RelCol: v1.Columns[0], Value: []byte("1")}}}}}
+	record, err := enc.Encode(insert)
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.Version)
+	assert.Equal(t, []byte{0x02}, record.Data)
+
+	// Adding a nullable column and widening int to bigint are backward compatible.
+	v2 := testAvroRelation(testColumn("id", 20, true, -1), testColumn("note", 25, false, -1))
+	_, version, err := enc.Schema(v2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	_, version, err = enc.Schema(v2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	// A new required column is not.
+	v3 := testAvroRelation(testColumn("id", 20, true, -1), testColumn("note", 25, false, -1), testColumn("k", 23, true, -1))
+	_, _, err = enc.Schema(v3)
+	assert.Error(t, err)
+
+	versions, err := registry.Versions("public.orders")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, versions)
+
+	// A new encoder picks up the registered versions.
+	_, version, err = pglogrepl.NewAvroEncoder(registry).Schema(v2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	latest, version, err := registry.Latest("public.orders")
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.Len(t, latest.Fields, 2)
+
+	assert.Error(t, pglogrepl.CheckAvroCompatibility(latest, pglogrepl.NewAvroSchema(v1), pglogrepl.AvroCompatibilityFull))
+}
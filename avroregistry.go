@@ -0,0 +1,225 @@
+package pglogrepl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	errors "golang.org/x/xerrors"
+)
+
+// AvroCompatibility is the compatibility a new schema version must have with the previous version.
+type AvroCompatibility int
+
+const (
+	// AvroCompatibilityNone accepts every new version.
+	AvroCompatibilityNone AvroCompatibility = iota
+	// AvroCompatibilityBackward requires that data written with the previous version can be read with the new one.
+	AvroCompatibilityBackward
+	// AvroCompatibilityForward requires that data written with the new version can be read with the previous one.
+	AvroCompatibilityForward
+	// AvroCompatibilityFull requires both backward and forward compatibility.
+	AvroCompatibilityFull
+)
+
+func (c AvroCompatibility) String() string {
+	switch c {
+	case AvroCompatibilityNone:
+		return "NONE"
+	case AvroCompatibilityBackward:
+		return "BACKWARD"
+	case AvroCompatibilityForward:
+		return "FORWARD"
+	case AvroCompatibilityFull:
+		return "FULL"
+	}
+	return fmt.Sprintf("AvroCompatibility(%d)", int(c))
+}
+
+// AvroRegistry is a schema registry in a local directory. The versions of a record are stored as
+// <dir>/<full name>/<version>.avsc.
+type AvroRegistry struct {
+	dir           string
+	compatibility AvroCompatibility
+	mu            sync.Mutex
+}
+
+// NewAvroRegistry opens the registry in dir, creating the directory if it does not exist.
+func NewAvroRegistry(dir string, compatibility AvroCompatibility) (*AvroRegistry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &AvroRegistry{dir: dir, compatibility: compatibility}, nil
+}
+
+// Register returns the version of schema, storing it as a new version if it differs from the latest one. A new
+// version that is not compatible with the latest version according to the registry's compatibility is rejected.
+func (r *AvroRegistry) Register(schema *AvroSchema) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	latest, version, err := r.latest(schema.FullName())
+	if err != nil {
+		return 0, err
+	}
+	if latest != nil {
+		if latest.String() == schema.String() {
+			return version, nil
+		}
+		if err := CheckAvroCompatibility(latest, schema, r.compatibility); err != nil {
+			return 0, errors.Errorf("schema %s is incompatible with version %d: %w", schema.FullName(), version, err)
+		}
+	}
+
+	version++
+	dir := filepath.Join(r.dir, schema.FullName())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.avsc", version))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(schema.String()), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Latest returns the latest version of the record with the full name. It returns a nil schema and version 0 if the
+// record has no versions.
+func (r *AvroRegistry) Latest(fullName string) (*AvroSchema, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest(fullName)
+}
+
+// Schema returns a version of the record with the full name.
+func (r *AvroRegistry) Schema(fullName string, version int) (*AvroSchema, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, fullName, fmt.Sprintf("%d.avsc", version)))
+	if err != nil {
+		return nil, err
+	}
+	schema := &AvroSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, errors.Errorf("failed to parse version %d of %s: %w", version, fullName, err)
+	}
+	return schema, nil
+}
+
+// Versions returns the versions of the record with the full name in ascending order.
+func (r *AvroRegistry) Versions(fullName string) ([]int, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(r.dir, fullName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".avsc") {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSuffix(name, ".avsc")); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (r *AvroRegistry) latest(fullName string) (*AvroSchema, int, error) {
+	versions, err := r.Versions(fullName)
+	if err != nil || len(versions) == 0 {
+		return nil, 0, err
+	}
+	version := versions[len(versions)-1]
+	schema, err := r.Schema(fullName, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	return schema, version, nil
+}
+
+// CheckAvroCompatibility returns an error describing why next does not have the compatibility with prev.
+func CheckAvroCompatibility(prev, next *AvroSchema, compatibility AvroCompatibility) error {
+	if compatibility == AvroCompatibilityBackward || compatibility == AvroCompatibilityFull {
+		if err := checkAvroReadable(prev, next); err != nil {
+			return errors.Errorf("not backward compatible: %w", err)
+		}
+	}
+	if compatibility == AvroCompatibilityForward || compatibility == AvroCompatibilityFull {
+		if err := checkAvroReadable(next, prev); err != nil {
+			return errors.Errorf("not forward compatible: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkAvroReadable checks that data written with writer can be read with reader following Avro schema resolution.
+func checkAvroReadable(writer, reader *AvroSchema) error {
+	written := make(map[string]*AvroField, len(writer.Fields))
+	for i := range writer.Fields {
+		written[writer.Fields[i].Name] = &writer.Fields[i]
+	}
+
+	for _, rf := range reader.Fields {
+		wf, ok := written[rf.Name]
+		if !ok {
+			if !rf.Nullable {
+				return errors.Errorf("field %s is missing and has no default", rf.Name)
+			}
+			continue
+		}
+		if wf.Nullable && !rf.Nullable {
+			return errors.Errorf("field %s may be null", rf.Name)
+		}
+		if !avroTypeReadable(&wf.Type, &rf.Type) {
+			return errors.Errorf("field %s cannot be read as %s", rf.Name, avroTypeString(&rf.Type))
+		}
+	}
+	return nil
+}
+
+func avroTypeReadable(writer, reader *AvroType) bool {
+	if writer.Type == "array" || reader.Type == "array" {
+		return writer.Type == reader.Type && avroTypeReadable(writer.Items, reader.Items)
+	}
+	if writer.LogicalType != reader.LogicalType || writer.Precision != reader.Precision || writer.Scale != reader.Scale {
+		return false
+	}
+	if writer.Type == reader.Type {
+		return true
+	}
+
+	// Promotions allowed by the Avro specification.
+	switch writer.Type {
+	case "int":
+		return reader.Type == "long" || reader.Type == "float" || reader.Type == "double"
+	case "long":
+		return reader.Type == "float" || reader.Type == "double"
+	case "float":
+		return reader.Type == "double"
+	case "string":
+		return reader.Type == "bytes"
+	case "bytes":
+		return reader.Type == "string"
+	}
+	return false
+}
+
+func avroTypeString(t *AvroType) string {
+	b, _ := json.Marshal(t)
+	return string(b)
+}
@@ -0,0 +1,217 @@
+package pglogrepl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// CloudEventsSpecVersion is the CloudEvents version of the events created by CloudEventsEncoder.
+const CloudEventsSpecVersion = "1.0"
+
+// CloudEvent is a CloudEvents 1.0 event. It marshals to the JSON structured mode representation; HTTPHeaders
+// returns the binary mode representation.
+type CloudEvent struct {
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	Data            json.RawMessage
+	// Extensions are extension context attributes, e.g. pglsn and pgxid.
+	Extensions map[string]string
+}
+
+// MarshalJSON implements json.Marshaler.
+func (ce *CloudEvent) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, 8+len(ce.Extensions))
+	for name, value := range ce.Extensions {
+		m[name] = value
+	}
+	m["specversion"] = CloudEventsSpecVersion
+	m["id"] = ce.ID
+	m["source"] = ce.Source
+	m["type"] = ce.Type
+	if ce.Subject != "" {
+		m["subject"] = ce.Subject
+	}
+	if !ce.Time.IsZero() {
+		m["time"] = ce.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if ce.DataContentType != "" {
+		m["datacontenttype"] = ce.DataContentType
+	}
+	if ce.Data != nil {
+		m["data"] = ce.Data
+	}
+	return json.Marshal(m)
+}
+
+// HTTPHeaders returns the headers of the event in the HTTP binary content mode, where Data is sent as the body.
+func (ce *CloudEvent) HTTPHeaders() http.Header {
+	h := http.Header{}
+	h.Set("ce-specversion", CloudEventsSpecVersion)
+	h.Set("ce-id", ce.ID)
+	h.Set("ce-source", ce.Source)
+	h.Set("ce-type", ce.Type)
+	if ce.Subject != "" {
+		h.Set("ce-subject", ce.Subject)
+	}
+	if !ce.Time.IsZero() {
+		h.Set("ce-time", ce.Time.UTC().Format(time.RFC3339Nano))
+	}
+	if ce.DataContentType != "" {
+		h.Set("Content-Type", ce.DataContentType)
+	}
+	for name, value := range ce.Extensions {
+		h.Set("ce-"+name, value)
+	}
+	return h
+}
+
+// CloudEventsOptions configures a CloudEventsEncoder.
+type CloudEventsOptions struct {
+	// TypePrefix prefixes the event types. Defaults to "org.postgresql".
+	TypePrefix string
+}
+
+// CloudEventsEncoder wraps decoded changes in CloudEvents. It is stateful: every change of the stream, including
+// Begin and Commit, must be passed in order.
+//
+// The source is /postgresql/<system identifier>/<database> and the type is <prefix>.<schema>.<table>.<operation>.
+// The id is the final LSN of the transaction, or the LSN of its Begin for plugins that do not send it, followed by
+// the position of the change in the transaction, so the same change gets the same id when it is streamed again.
+// time is the commit timestamp when the plugin sends it.
+type CloudEventsEncoder struct {
+	source     string
+	typePrefix string
+	tx         txContext
+	lastLSN    LSN
+}
+
+// NewCloudEventsEncoder creates an encoder for the changes of the server identified by sysident.
+func NewCloudEventsEncoder(sysident IdentifySystemResult, options CloudEventsOptions) *CloudEventsEncoder {
+	if options.TypePrefix == "" {
+		options.TypePrefix = "org.postgresql"
+	}
+	return &CloudEventsEncoder{
+		source:     fmt.Sprintf("/postgresql/%s/%s", sysident.SystemID, sysident.DBName),
+		typePrefix: options.TypePrefix,
+	}
+}
+
+// cloudEventData is the data of a change event.
+type cloudEventData struct {
+	Before          map[string]interface{} `json:"before,omitempty"`
+	After           map[string]interface{} `json:"after,omitempty"`
+	Cascade         bool                   `json:"cascade,omitempty"`
+	RestartIdentity bool                   `json:"restart_identity,omitempty"`
+}
+
+// Events returns the events of a change received at lsn. Begin, Commit, Relation and other messages only update the
+// encoder state and return no events. A Truncate returns one event per table. Unchanged TOASTed values are left out.
+func (e *CloudEventsEncoder) Events(lsn LSN, wd *WalData) ([]*CloudEvent, error) {
+	if e.tx.track(wd) {
+		if e.tx.inTx && e.tx.finalLSN == 0 {
+			// Without the final LSN the transaction is identified by the LSN of its Begin.
+			e.tx.finalLSN = lsn
+		}
+		return nil, nil
+	}
+
+	switch v := wd.Value.(type) {
+	case *InsertWalData:
+		after, err := v.Tuples.Map()
+		if err != nil {
+			return nil, err
+		}
+		event, err := e.event(lsn, &v.Relation, "insert", &cloudEventData{After: after})
+		return []*CloudEvent{event}, err
+	case *UpdateWalData:
+		after, err := v.Tuples.Map()
+		if err != nil {
+			return nil, err
+		}
+		data := &cloudEventData{After: after}
+		if v.OldTuples != nil {
+			if data.Before, err = v.OldTuples.Map(); err != nil {
+				return nil, err
+			}
+		}
+		event, err := e.event(lsn, &v.Relation, "update", data)
+		return []*CloudEvent{event}, err
+	case *DeleteWalData:
+		before, err := v.Tuples.Map()
+		if err != nil {
+			return nil, err
+		}
+		event, err := e.event(lsn, &v.Relation, "delete", &cloudEventData{Before: before})
+		return []*CloudEvent{event}, err
+	case *TruncateWalData:
+		events := make([]*CloudEvent, 0, len(v.Relations))
+		for i := range v.Relations {
+			event, err := e.event(lsn, &v.Relations[i], "truncate", &cloudEventData{Cascade: v.IsCascade, RestartIdentity: v.IsRestartIdentity})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		return events, nil
+	}
+
+	return nil, nil
+}
+
+// Encode returns the structured mode JSON documents of the events of a change, see Events.
+func (e *CloudEventsEncoder) Encode(lsn LSN, wd *WalData) ([][]byte, error) {
+	events, err := e.Events(lsn, wd)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([][]byte, 0, len(events))
+	for _, event := range events {
+		doc, err := json.Marshal(event)
+		if err != nil {
+			return nil, errors.Errorf("failed to encode CloudEvent: %w", err)
+		}
+		documents = append(documents, doc)
+	}
+	return documents, nil
+}
+
+func (e *CloudEventsEncoder) event(lsn LSN, rel *RelationWalData, op string, data *cloudEventData) (*CloudEvent, error) {
+	if !e.tx.inTx && lsn != e.lastLSN {
+		// Without a transaction the position counts the events of the message at lsn.
+		e.tx.position = 0
+	}
+	e.lastLSN = lsn
+	e.tx.position++
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Errorf("failed to encode CloudEvent data: %w", err)
+	}
+
+	event := &CloudEvent{
+		Source:          e.source,
+		Type:            strings.Join([]string{e.typePrefix, rel.Namespace, rel.RelationName, op}, "."),
+		Subject:         rel.FullName(),
+		Time:            e.tx.commitTime,
+		DataContentType: "application/json",
+		Data:            payload,
+		Extensions:      map[string]string{"pglsn": lsn.String()},
+	}
+	if e.tx.inTx {
+		event.ID = fmt.Sprintf("%s-%d", e.tx.finalLSN, e.tx.position)
+		event.Extensions["pgxid"] = fmt.Sprint(uint32(e.tx.xid))
+	} else {
+		event.ID = fmt.Sprintf("%s-%d", lsn, e.tx.position)
+	}
+	return event, nil
+}
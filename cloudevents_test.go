@@ -0,0 +1,77 @@
+package pglogrepl_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestCloudEventsEncoder(t *testing.T) {
+	p := pglogrepl.NewWal2JSONDecoder(2)
+	enc := pglogrepl.NewCloudEventsEncoder(pglogrepl.IdentifySystemResult{SystemID: "6777", DBName: "shop"}, pglogrepl.CloudEventsOptions{})
+	events := func(lsn pglogrepl.LSN, line string) []*pglogrepl.CloudEvent {
+		wds, err := p.Decode(pglogrepl.XLogData{WALStart: lsn, Data: []byte(line)})
+		require.NoError(t, err)
+		var result []*pglogrepl.CloudEvent
+		for _, wd := range wds {
+			events, err := enc.Events(lsn, wd)
+			require.NoError(t, err)
+			result = append(result, events...)
+		}
+		return result
+	}
+
+	assert.Empty(t, events(0x100, `{"action":"B","xid":42,"timestamp":"2020-01-02 03:04:05.678+00"}`))
+	insert := events(0x110, `{"action":"I","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":1}]}`)
+	truncate := events(0x120, `{"action":"T","schema":"public","table":"orders"}`)
+	assert.Empty(t, events(0x130, `{"action":"C"}`))
+
+	require.Len(t, insert, 1)
+	ce := insert[0]
+	assert.Equal(t, "0/100-1", ce.ID)
+	assert.Equal(t, "/postgresql/6777/shop", ce.Source)
+	assert.Equal(t, "org.postgresql.public.orders.insert", ce.Type)
+	assert.Equal(t, "public.orders", ce.Subject)
+	assert.True(t, ce.Time.Equal(time.Date(2020, 1, 2, 3, 4, 5, 678000000, time.UTC)))
+	assert.JSONEq(t, `{"after":{"id":1}}`, string(ce.Data))
+
+	require.Len(t, truncate, 1)
+	assert.Equal(t, "0/100-2", truncate[0].ID)
+
+	doc, err := json.Marshal(ce)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"specversion":"1.0","id":"0/100-1","source":"/postgresql/6777/shop","type":"org.postgresql.public.orders.insert",
+		"subject":"public.orders","time":"2020-01-02T03:04:05.678Z","datacontenttype":"application/json","data":{"after":{"id":1}},
+		"pglsn":"0/110","pgxid":"42"}`, string(doc))
+
+	h := ce.HTTPHeaders()
+	assert.Equal(t, "1.0", h.Get("ce-specversion"))
+	assert.Equal(t, "0/100-1", h.Get("ce-id"))
+	assert.Equal(t, "org.postgresql.public.orders.insert", h.Get("ce-type"))
+	assert.Equal(t, "2020-01-02T03:04:05.678Z", h.Get("ce-time"))
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.Equal(t, "0/110", h.Get("ce-pglsn"))
+}
+
+func TestCloudEventsEncoderWithoutFinalLSN(t *testing.T) {
+	p := pglogrepl.NewTestDecodingParser()
+	enc := pglogrepl.NewCloudEventsEncoder(pglogrepl.IdentifySystemResult{SystemID: "6777", DBName: "shop"}, pglogrepl.CloudEventsOptions{})
+
+	// A Begin without final LSN identifies the transaction by the LSN it was received at.
+	begin := &pglogrepl.WalData{Type: pglogrepl.BeginWalType, Value: &pglogrepl.BeginWalData{XID: 42}}
+	events, err := enc.Events(0x100, begin)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	wd, err := p.Parse(pglogrepl.XLogData{WALStart: 0x110, Data: []byte("table public.orders: INSERT: id[integer]:1")})
+	require.NoError(t, err)
+	events, err = enc.Events(0x110, wd)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "0/100-1", events[0].ID)
+}
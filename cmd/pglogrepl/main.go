@@ -0,0 +1,245 @@
+// Command pglogrepl inspects and consumes PostgreSQL replication slots from the command line.
+//
+// The connection string is taken from the -d flag or the PGLOGREPL_CONN_STRING environment variable.
+// Replication commands require a replication connection, so replication=database is added to the
+// connection parameters unless the connection string already sets replication.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/jackc/pglogrepl"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"identify-system", "print the system identifier, timeline and current WAL position", runIdentifySystem},
+	{"create-slot", "create a replication slot", runCreateSlot},
+	{"drop-slot", "drop a replication slot", runDropSlot},
+	{"timeline-history", "print the history file of a timeline", runTimelineHistory},
+	{"stream", "stream logical changes from a slot to stdout", runStream},
+	{"recvlogical", "stream a logical slot into a file like pg_recvlogical", runRecvLogical},
+	{"preflight", "check that the server is ready for logical replication", runPreflight},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pglogrepl <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'pglogrepl <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(ctx, os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "pglogrepl:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet creates the flag set of a command with the connection flag shared by all commands.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	connString := fs.String("d", os.Getenv("PGLOGREPL_CONN_STRING"), "connection string (URL or DSN), defaults to $PGLOGREPL_CONN_STRING")
+	return fs, connString
+}
+
+// connect opens a replication connection.
+func connect(ctx context.Context, connString string) (*pgconn.PgConn, error) {
+	config, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config.RuntimeParams["replication"]; !ok {
+		config.RuntimeParams["replication"] = "database"
+	}
+	return pgconn.ConnectConfig(ctx, config)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runIdentifySystem(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("identify-system")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args)
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{
+			"systemid": sysident.SystemID,
+			"timeline": sysident.Timeline,
+			"xlogpos":  sysident.XLogPos.String(),
+			"dbname":   sysident.DBName,
+		})
+	}
+	fmt.Println("SystemID:", sysident.SystemID)
+	fmt.Println("Timeline:", sysident.Timeline)
+	fmt.Println("XLogPos: ", sysident.XLogPos)
+	fmt.Println("DBName:  ", sysident.DBName)
+	return nil
+}
+
+func runCreateSlot(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("create-slot")
+	slotName := fs.String("slot", "", "name of the slot (required)")
+	plugin := fs.String("plugin", "pgoutput", "output plugin of a logical slot")
+	physical := fs.Bool("physical", false, "create a physical slot")
+	temporary := fs.Bool("temporary", false, "create a temporary slot, dropped when the connection closes")
+	snapshotAction := fs.String("snapshot-action", "", "EXPORT_SNAPSHOT, NOEXPORT_SNAPSHOT or USE_SNAPSHOT")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args)
+
+	if *slotName == "" {
+		return fmt.Errorf("-slot is required")
+	}
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	options := pglogrepl.CreateReplicationSlotOptions{Temporary: *temporary, SnapshotAction: *snapshotAction}
+	if *physical {
+		options.Mode = pglogrepl.PhysicalReplication
+		*plugin = ""
+	}
+
+	result, err := pglogrepl.CreateReplicationSlot(ctx, conn, *slotName, *plugin, options)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]interface{}{
+			"slot_name":        result.SlotName,
+			"consistent_point": result.ConsistentPoint,
+			"snapshot_name":    result.SnapshotName,
+			"output_plugin":    result.OutputPlugin,
+		})
+	}
+	fmt.Println("SlotName:       ", result.SlotName)
+	fmt.Println("ConsistentPoint:", result.ConsistentPoint)
+	fmt.Println("SnapshotName:   ", result.SnapshotName)
+	fmt.Println("OutputPlugin:   ", result.OutputPlugin)
+	return nil
+}
+
+func runDropSlot(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("drop-slot")
+	slotName := fs.String("slot", "", "name of the slot (required)")
+	wait := fs.Bool("wait", false, "wait until an active slot becomes inactive instead of failing")
+	fs.Parse(args)
+
+	if *slotName == "" {
+		return fmt.Errorf("-slot is required")
+	}
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	return pglogrepl.DropReplicationSlot(ctx, conn, *slotName, pglogrepl.DropReplicationSlotOptions{Wait: *wait})
+}
+
+func runTimelineHistory(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("timeline-history")
+	timeline := fs.Int("timeline", 0, "timeline to print the history of, defaults to the current timeline")
+	fs.Parse(args)
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if *timeline == 0 {
+		sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+		if err != nil {
+			return err
+		}
+		*timeline = int(sysident.Timeline)
+	}
+
+	result, err := pglogrepl.TimelineHistory(ctx, conn, int32(*timeline))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "FileName:", result.FileName)
+	_, err = os.Stdout.Write(result.Content)
+	return err
+}
+
+// pluginOptions collects repeated -o name=value flags as START_REPLICATION plugin arguments.
+type pluginOptions []string
+
+func (o *pluginOptions) String() string {
+	return strings.Join(*o, ", ")
+}
+
+func (o *pluginOptions) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if parts[0] == "" {
+		return fmt.Errorf("invalid plugin option %q", s)
+	}
+	if len(parts) == 1 {
+		*o = append(*o, parts[0])
+		return nil
+	}
+	*o = append(*o, fmt.Sprintf("%s '%s'", parts[0], strings.Replace(parts[1], "'", "''", -1)))
+	return nil
+}
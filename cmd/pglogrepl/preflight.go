@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func runPreflight(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("preflight")
+	publications := fs.String("publications", "", "comma separated publications to check")
+	slots := fs.Int("slots", 1, "number of replication slots and walsenders needed")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	// The checks run SQL queries, which need a regular connection.
+	config, err := pgconn.ParseConfig(*connString)
+	if err != nil {
+		return err
+	}
+	delete(config.RuntimeParams, "replication")
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	options := pglogrepl.PreflightOptions{Slots: *slots}
+	if *publications != "" {
+		options.Publications = strings.Split(*publications, ",")
+	}
+	report, err := pglogrepl.Preflight(ctx, conn, options)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, check := range report.Checks {
+			fmt.Printf("%-4s %-28s %s\n", check.Status, check.Name, check.Message)
+			if check.Remediation != "" && check.Status != pglogrepl.PreflightPass {
+				fmt.Printf("     %-28s -> %s\n", "", check.Remediation)
+			}
+		}
+	}
+	if !report.OK() {
+		return fmt.Errorf("preflight checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func runRecvLogical(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("recvlogical")
+	slotName := fs.String("slot", "", "name of the logical slot (required)")
+	file := fs.String("f", "", "output file, - for stdout (required)")
+	startPos := fs.String("startpos", "0/0", "LSN to start streaming from, 0/0 continues from the slot's confirmed position")
+	endPos := fs.String("endpos", "0/0", "stop once this LSN is reached, 0/0 streams until interrupted")
+	statusInterval := fs.Duration("status-interval", 10*time.Second, "interval between fsyncs and standby status updates")
+	rotateSize := fs.Int64("rotate-size", 0, "rotate the output file once it reaches this many bytes")
+	rotateInterval := fs.Duration("rotate-interval", 0, "rotate the output file once it has been open this long")
+	metricsAddr := fs.String("metrics", "", "serve lag metrics in the Prometheus text format on this address, e.g. :9187")
+	var options pluginOptions
+	fs.Var(&options, "o", "plugin option as name=value, may be repeated")
+	fs.Parse(args)
+
+	if *slotName == "" || *file == "" {
+		return fmt.Errorf("-slot and -f are required")
+	}
+	start, err := pglogrepl.ParseLSN(*startPos)
+	if err != nil {
+		return err
+	}
+	end, err := pglogrepl.ParseLSN(*endPos)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	var lag *pglogrepl.LagTracker
+	if *metricsAddr != "" {
+		metrics := pglogrepl.NewPrometheusMetrics()
+		lag = pglogrepl.NewLagTracker(metrics)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		server := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintln(os.Stderr, "pglogrepl: metrics:", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	flushed, err := pglogrepl.RecvLogical(ctx, conn, *slotName, pglogrepl.RecvLogicalOptions{
+		File:           *file,
+		RotateSize:     *rotateSize,
+		RotateInterval: *rotateInterval,
+		StatusInterval: *statusInterval,
+		StartPos:       start,
+		EndPos:         end,
+		PluginArgs:     options,
+		Lag:            lag,
+	})
+	if err != nil && err != context.Canceled {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Flushed up to", flushed)
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func runStream(ctx context.Context, args []string) error {
+	fs, connString := newFlagSet("stream")
+	slotName := fs.String("slot", "", "name of the logical slot (required)")
+	startLSN := fs.String("start-lsn", "0/0", "LSN to start streaming from, 0/0 continues from the slot's confirmed position")
+	plugin := fs.String("plugin", "", "output plugin of the slot, defaults to the plugin the slot was created with; changes of plugins without a registered decoder are printed as received")
+	format := fs.String("format", "text", "output format: text, json (NDJSON) or debezium (NDJSON Debezium change events)")
+	statusInterval := fs.Duration("status-interval", 10*time.Second, "interval between standby status updates")
+	var options pluginOptions
+	fs.Var(&options, "o", "plugin option as name=value, may be repeated (e.g. -o proto_version=1 -o publication_names=pub)")
+	fs.Parse(args)
+
+	if *slotName == "" {
+		return fmt.Errorf("-slot is required")
+	}
+	if *format != "text" && *format != "json" && *format != "debezium" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	lsn, err := pglogrepl.ParseLSN(*startLSN)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, *connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if *plugin == "" {
+		if *plugin, err = slotPlugin(ctx, conn, *slotName); err != nil {
+			return err
+		}
+	}
+	decoder, err := pglogrepl.NewDecoder(*plugin, options)
+	if err != nil && !errors.Is(err, pglogrepl.ErrUnknownPlugin) {
+		return err
+	}
+
+	var debezium *pglogrepl.DebeziumEncoder
+	if *format == "debezium" {
+		if decoder == nil {
+			return fmt.Errorf("-format debezium requires a decoder for plugin %q", *plugin)
+		}
+		sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+		if err != nil {
+			return err
+		}
+		debezium = pglogrepl.NewDebeziumEncoder(pglogrepl.DebeziumOptions{ServerName: *slotName, Database: sysident.DBName})
+	}
+
+	err = pglogrepl.StartReplication(ctx, conn, *slotName, lsn, pglogrepl.StartReplicationOptions{PluginArgs: options})
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	clientXLogPos := lsn
+	nextStandbyMessageDeadline := time.Now().Add(*statusInterval)
+
+	for {
+		if time.Now().After(nextStandbyMessageDeadline) {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			err = pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: clientXLogPos})
+			if err != nil {
+				return fmt.Errorf("SendStandbyStatusUpdate failed: %v", err)
+			}
+			nextStandbyMessageDeadline = time.Now().Add(*statusInterval)
+		}
+
+		recvCtx, cancel := context.WithDeadline(ctx, nextStandbyMessageDeadline)
+		msg, err := conn.ReceiveMessage(recvCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				// Interrupted: acknowledge what was printed before leaving.
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				return pglogrepl.SendStandbyStatusUpdate(context.Background(), conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: clientXLogPos})
+			}
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return fmt.Errorf("ReceiveMessage failed: %v", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			switch msg.Data[0] {
+			case pglogrepl.PrimaryKeepaliveMessageByteID:
+				pkm, err := pglogrepl.ParsePrimaryKeepaliveMessage(msg.Data[1:])
+				if err != nil {
+					return fmt.Errorf("ParsePrimaryKeepaliveMessage failed: %v", err)
+				}
+				if pkm.ReplyRequested {
+					nextStandbyMessageDeadline = time.Time{}
+				}
+
+			case pglogrepl.XLogDataByteID:
+				xld, err := pglogrepl.ParseXLogData(msg.Data[1:])
+				if err != nil {
+					return fmt.Errorf("ParseXLogData failed: %v", err)
+				}
+
+				if decoder != nil {
+					var walData []*pglogrepl.WalData
+					walData, err = decoder.Decode(xld)
+					if err != nil {
+						return fmt.Errorf("failed to parse XLogData at %s: %v", xld.WALStart, err)
+					}
+					for _, wd := range walData {
+						switch *format {
+						case "json":
+							err = enc.Encode(walDataJSON(xld.WALStart, wd))
+						case "debezium":
+							err = writeDebezium(out, debezium, xld.WALStart, wd)
+						default:
+							_, err = fmt.Fprintln(out, wd.Value.String())
+						}
+						if err != nil {
+							break
+						}
+					}
+				} else if *format == "json" {
+					err = enc.Encode(map[string]interface{}{"lsn": xld.WALStart.String(), "data": string(xld.Data)})
+				} else {
+					_, err = fmt.Fprintln(out, string(xld.Data))
+				}
+				if err != nil {
+					return err
+				}
+
+				clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.Data))
+			}
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		default:
+			fmt.Fprintf(os.Stderr, "Received unexpected message: %#v\n", msg)
+		}
+	}
+}
+
+// slotPlugin returns the output plugin of a logical slot.
+func slotPlugin(ctx context.Context, conn *pgconn.PgConn, slotName string) (string, error) {
+	escaped, err := conn.EscapeString(slotName)
+	if err != nil {
+		return "", err
+	}
+	results, err := conn.Exec(ctx, fmt.Sprintf("SELECT plugin FROM pg_catalog.pg_replication_slots WHERE slot_name = '%s'", escaped)).ReadAll()
+	if err != nil {
+		return "", err
+	}
+	if len(results) != 1 || len(results[0].Rows) != 1 {
+		return "", fmt.Errorf("replication slot %q does not exist", slotName)
+	}
+	return string(results[0].Rows[0][0]), nil
+}
+
+func writeDebezium(out *bufio.Writer, debezium *pglogrepl.DebeziumEncoder, lsn pglogrepl.LSN, wd *pglogrepl.WalData) error {
+	messages, err := debezium.Encode(lsn, wd)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if _, err := out.Write(msg); err != nil {
+			return err
+		}
+		if err := out.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walDataJSON converts a parsed change into the object printed as one NDJSON line.
+func walDataJSON(lsn pglogrepl.LSN, wd *pglogrepl.WalData) map[string]interface{} {
+	m := map[string]interface{}{"lsn": lsn.String()}
+
+	switch v := wd.Value.(type) {
+	case *pglogrepl.BeginWalData:
+		m["type"] = "begin"
+		m["xid"] = v.XID
+		if v.Lsn != 0 {
+			m["final_lsn"] = v.Lsn.String()
+		}
+		m["commit_time"] = v.CommitTime()
+	case *pglogrepl.CommitWalData:
+		m["type"] = "commit"
+		m["commit_lsn"] = v.LsnCommit.String()
+		m["end_lsn"] = v.LsnTransaction.String()
+		m["commit_time"] = v.CommitTime()
+	case *pglogrepl.RelationWalData:
+		m["type"] = "relation"
+		m["schema"] = v.Namespace
+		m["table"] = v.RelationName
+		columns := make([]map[string]interface{}, 0, len(v.Columns))
+		for _, c := range v.Columns {
+			columns = append(columns, map[string]interface{}{"name": c.Name, "type": c.Type.Typname, "array": c.IsArray, "key": c.Flag})
+		}
+		m["columns"] = columns
+	case *pglogrepl.InsertWalData:
+		m["type"] = "insert"
+		addTupleJSON(m, "new", &v.Relation, &v.Tuples)
+	case *pglogrepl.UpdateWalData:
+		m["type"] = "update"
+		if v.OldTuples != nil {
+			addTupleJSON(m, "old", &v.Relation, v.OldTuples)
+		}
+		addTupleJSON(m, "new", &v.Relation, &v.Tuples)
+	case *pglogrepl.DeleteWalData:
+		m["type"] = "delete"
+		addTupleJSON(m, "old", &v.Relation, &v.Tuples)
+	case *pglogrepl.TruncateWalData:
+		m["type"] = "truncate"
+		tables := make([]string, 0, len(v.Relations))
+		for _, rel := range v.Relations {
+			tables = append(tables, rel.FullName())
+		}
+		m["tables"] = tables
+		m["cascade"] = v.IsCascade
+		m["restart_identity"] = v.IsRestartIdentity
+	default:
+		m["type"] = "unknown"
+		m["data"] = wd.Value.String()
+	}
+
+	return m
+}
+
+func addTupleJSON(m map[string]interface{}, key string, rel *pglogrepl.RelationWalData, td *pglogrepl.TupleData) {
+	m["schema"] = rel.Namespace
+	m["table"] = rel.RelationName
+	values, err := td.Map()
+	if err != nil {
+		m["error"] = err.Error()
+		return
+	}
+	m[key] = values
+}
@@ -0,0 +1,350 @@
+package pglogrepl
+
+import (
+	"encoding/json"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// DebeziumUnavailableValue is the placeholder Debezium uses for unchanged TOASTed values.
+const DebeziumUnavailableValue = "__debezium_unavailable_value"
+
+// DebeziumOptions configures a DebeziumEncoder.
+type DebeziumOptions struct {
+	// ServerName is the logical name of the server. It is reported as source.name and prefixes schema names.
+	ServerName string
+	// Database is reported as source.db.
+	Database string
+	// IncludeSchema adds the schema section of Debezium's JSON converter with schemas.enable=true.
+	IncludeSchema bool
+}
+
+// DebeziumEvent is a change event in the Debezium envelope.
+type DebeziumEvent struct {
+	// Key holds the values of the key columns. It is not part of the envelope but is what Debezium uses as
+	// message key.
+	Key     map[string]interface{} `json:"-"`
+	Schema  *DebeziumSchema        `json:"schema,omitempty"`
+	Payload DebeziumPayload        `json:"payload"`
+}
+
+// DebeziumPayload is the value of a Debezium change event.
+type DebeziumPayload struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source DebeziumSource         `json:"source"`
+	// Op is c (create), u (update), d (delete), t (truncate) or r (read during a snapshot).
+	Op   string `json:"op"`
+	TsMs int64  `json:"ts_ms"`
+}
+
+// DebeziumSource is the source block of a Debezium change event.
+type DebeziumSource struct {
+	Version   string `json:"version"`
+	Connector string `json:"connector"`
+	Name      string `json:"name"`
+	TsMs      int64  `json:"ts_ms"`
+	Snapshot  string `json:"snapshot"`
+	DB        string `json:"db"`
+	Schema    string `json:"schema"`
+	Table     string `json:"table"`
+	TxID      *int64 `json:"txId"`
+	LSN       *int64 `json:"lsn"`
+}
+
+// DebeziumSchema is a Kafka Connect schema as written by the JSON converter.
+type DebeziumSchema struct {
+	Type     string            `json:"type"`
+	Optional bool              `json:"optional"`
+	Name     string            `json:"name,omitempty"`
+	Field    string            `json:"field,omitempty"`
+	Fields   []*DebeziumSchema `json:"fields,omitempty"`
+	Items    *DebeziumSchema   `json:"items,omitempty"`
+}
+
+// DebeziumEncoder turns decoded changes into Debezium change events. It is stateful: every change of the stream,
+// including Begin and Commit, must be passed in order so that events carry their transaction.
+type DebeziumEncoder struct {
+	options DebeziumOptions
+	tx      txContext
+}
+
+// NewDebeziumEncoder ...
+func NewDebeziumEncoder(options DebeziumOptions) *DebeziumEncoder {
+	return &DebeziumEncoder{options: options}
+}
+
+// Events returns the events of a change received at lsn. Begin, Commit, Relation and other messages only update the
+// encoder state and return no events. A Truncate returns one event per table.
+func (e *DebeziumEncoder) Events(lsn LSN, wd *WalData) ([]*DebeziumEvent, error) {
+	if e.tx.track(wd) {
+		return nil, nil
+	}
+
+	switch v := wd.Value.(type) {
+	case *InsertWalData:
+		after, err := debeziumValues(&v.Tuples)
+		if err != nil {
+			return nil, err
+		}
+		op := "c"
+		if v.Snapshot {
+			op = "r"
+		}
+		return []*DebeziumEvent{e.event(lsn, &v.Relation, op, nil, after, &v.Tuples)}, nil
+	case *UpdateWalData:
+		after, err := debeziumValues(&v.Tuples)
+		if err != nil {
+			return nil, err
+		}
+		var before map[string]interface{}
+		if v.OldTuples != nil {
+			if before, err = debeziumValues(v.OldTuples); err != nil {
+				return nil, err
+			}
+		}
+		return []*DebeziumEvent{e.event(lsn, &v.Relation, "u", before, after, &v.Tuples)}, nil
+	case *DeleteWalData:
+		before, err := debeziumValues(&v.Tuples)
+		if err != nil {
+			return nil, err
+		}
+		return []*DebeziumEvent{e.event(lsn, &v.Relation, "d", before, nil, &v.Tuples)}, nil
+	case *TruncateWalData:
+		events := make([]*DebeziumEvent, 0, len(v.Relations))
+		for i := range v.Relations {
+			events = append(events, e.event(lsn, &v.Relations[i], "t", nil, nil, nil))
+		}
+		return events, nil
+	}
+
+	return nil, nil
+}
+
+// Encode returns the JSON messages of the events of a change, see Events. Without IncludeSchema a message is the
+// payload alone, as written by the JSON converter with schemas.enable=false.
+func (e *DebeziumEncoder) Encode(lsn LSN, wd *WalData) ([][]byte, error) {
+	events, err := e.Events(lsn, wd)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([][]byte, 0, len(events))
+	for _, event := range events {
+		var msg []byte
+		if event.Schema != nil {
+			msg, err = json.Marshal(event)
+		} else {
+			msg, err = json.Marshal(&event.Payload)
+		}
+		if err != nil {
+			return nil, errors.Errorf("failed to encode Debezium event: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (e *DebeziumEncoder) event(lsn LSN, rel *RelationWalData, op string, before, after map[string]interface{}, td *TupleData) *DebeziumEvent {
+	source := DebeziumSource{
+		Version:   "pglogrepl",
+		Connector: "postgresql",
+		Name:      e.options.ServerName,
+		Snapshot:  "false",
+		DB:        e.options.Database,
+		Schema:    rel.Namespace,
+		Table:     rel.RelationName,
+	}
+	if op == "r" {
+		source.Snapshot = "true"
+	}
+	if !e.tx.commitTime.IsZero() {
+		source.TsMs = e.tx.commitTime.UnixNano() / int64(time.Millisecond)
+	}
+	if e.tx.inTx {
+		xid := int64(uint32(e.tx.xid))
+		source.TxID = &xid
+	}
+	pos := int64(lsn)
+	source.LSN = &pos
+
+	event := &DebeziumEvent{
+		Payload: DebeziumPayload{
+			Before: before,
+			After:  after,
+			Source: source,
+			Op:     op,
+			TsMs:   time.Now().UnixNano() / int64(time.Millisecond),
+		},
+	}
+	if td != nil {
+		event.Key = debeziumKey(td)
+	}
+	if e.options.IncludeSchema {
+		event.Schema = e.schema(rel)
+	}
+	return event
+}
+
+// schema returns the envelope schema of rel.
+func (e *DebeziumEncoder) schema(rel *RelationWalData) *DebeziumSchema {
+	prefix := rel.Namespace + "." + rel.RelationName
+	if e.options.ServerName != "" {
+		prefix = e.options.ServerName + "." + prefix
+	}
+
+	columns := make([]*DebeziumSchema, 0, len(rel.Columns))
+	for _, c := range rel.Columns {
+		s := debeziumColumnSchema(c.Type)
+		if c.IsArray {
+			s = &DebeziumSchema{Type: "array", Items: s}
+		}
+		s.Optional = !c.Flag
+		s.Field = c.Name
+		columns = append(columns, s)
+	}
+
+	value := func(field string) *DebeziumSchema {
+		return &DebeziumSchema{Type: "struct", Fields: columns, Optional: true, Name: prefix + ".Value", Field: field}
+	}
+	str := func(field string, optional bool) *DebeziumSchema {
+		return &DebeziumSchema{Type: "string", Optional: optional, Field: field}
+	}
+	int64Field := func(field string, optional bool) *DebeziumSchema {
+		return &DebeziumSchema{Type: "int64", Optional: optional, Field: field}
+	}
+
+	source := &DebeziumSchema{
+		Type: "struct",
+		Fields: []*DebeziumSchema{
+			str("version", false),
+			str("connector", false),
+			str("name", false),
+			int64Field("ts_ms", false),
+			{Type: "string", Optional: true, Name: "io.debezium.data.Enum", Field: "snapshot"},
+			str("db", false),
+			str("schema", false),
+			str("table", false),
+			int64Field("txId", true),
+			int64Field("lsn", true),
+		},
+		Name:  "io.debezium.connector.postgresql.Source",
+		Field: "source",
+	}
+
+	return &DebeziumSchema{
+		Type: "struct",
+		Fields: []*DebeziumSchema{
+			value("before"),
+			value("after"),
+			source,
+			str("op", false),
+			int64Field("ts_ms", true),
+		},
+		Name: prefix + ".Envelope",
+	}
+}
+
+// debeziumColumnSchema maps a column type to the Kafka Connect type of the values produced by Tuple.Decode.
+func debeziumColumnSchema(ty PgType) *DebeziumSchema {
+	switch ty.Typname {
+	case "bool":
+		return &DebeziumSchema{Type: "boolean"}
+	case "int2":
+		return &DebeziumSchema{Type: "int16"}
+	case "int4":
+		return &DebeziumSchema{Type: "int32"}
+	case "int8", "oid", "xid", "cid":
+		return &DebeziumSchema{Type: "int64"}
+	case "float4":
+		return &DebeziumSchema{Type: "float"}
+	case "float8":
+		return &DebeziumSchema{Type: "double"}
+	case "bytea":
+		return &DebeziumSchema{Type: "bytes"}
+	case "json", "jsonb":
+		return &DebeziumSchema{Type: "string", Name: "io.debezium.data.Json"}
+	case "uuid":
+		return &DebeziumSchema{Type: "string", Name: "io.debezium.data.Uuid"}
+	}
+	return &DebeziumSchema{Type: "string"}
+}
+
+// debeziumValues decodes a tuple into the values of a before or after block.
+func debeziumValues(td *TupleData) (map[string]interface{}, error) {
+	m, err := tupleValues(td, DebeziumUnavailableValue)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range m {
+		if raw, ok := v.(json.RawMessage); ok {
+			// Debezium represents json values as strings.
+			m[name] = string(raw)
+		}
+	}
+	return m, nil
+}
+
+func debeziumKey(td *TupleData) map[string]interface{} {
+	var key map[string]interface{}
+	for i := range td.Tuples {
+		t := &td.Tuples[i]
+		if !t.RelCol.Flag || t.IsTOAST {
+			continue
+		}
+		v, err := t.Decode()
+		if err != nil {
+			continue
+		}
+		if key == nil {
+			key = make(map[string]interface{})
+		}
+		key[t.RelCol.Name] = v
+	}
+	return key
+}
+
+// tupleValues decodes a tuple by column name like TupleData.Map, but reports unchanged TOASTed values as
+// unavailable instead of leaving them out.
+func tupleValues(td *TupleData, unavailable interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(td.Tuples))
+	for i := range td.Tuples {
+		t := &td.Tuples[i]
+		if t.IsTOAST {
+			m[t.RelCol.Name] = unavailable
+			continue
+		}
+		v, err := t.Decode()
+		if err != nil {
+			return nil, err
+		}
+		m[t.RelCol.Name] = v
+	}
+	return m, nil
+}
+
+// txContext tracks the transaction the changes of a stream belong to.
+type txContext struct {
+	inTx       bool
+	xid        int32
+	finalLSN   LSN
+	commitTime time.Time
+	position   int // number of changes of the transaction encoded so far
+}
+
+// track updates the context on Begin and Commit and reports whether wd was one of them.
+func (c *txContext) track(wd *WalData) bool {
+	switch v := wd.Value.(type) {
+	case *BeginWalData:
+		*c = txContext{inTx: true, xid: v.XID, finalLSN: v.Lsn}
+		if v.Timestamp != 0 {
+			c.commitTime = v.CommitTime()
+		}
+		return true
+	case *CommitWalData:
+		*c = txContext{}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package pglogrepl_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestDebeziumEncoder(t *testing.T) {
+	p := pglogrepl.NewTestDecodingParser()
+	enc := pglogrepl.NewDebeziumEncoder(pglogrepl.DebeziumOptions{ServerName: "srv", Database: "db", IncludeSchema: true})
+	encode := func(lsn pglogrepl.LSN, line string) [][]byte {
+		wd, err := p.Parse(pglogrepl.XLogData{WALStart: lsn, Data: []byte(line)})
+		require.NoError(t, err)
+		messages, err := enc.Encode(lsn, wd)
+		require.NoError(t, err)
+		return messages
+	}
+
+	assert.Empty(t, encode(0x100, "BEGIN 700"))
+	assert.Len(t, encode(0x101, "table public.t: DELETE: id[integer]:0"), 1)
+
+	messages := encode(0x110, `table public.t: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 doc[jsonb]:'{"a": 1}' big[text]:unchanged-toast-datum`)
+	require.Len(t, messages, 1)
+
+	var event struct {
+		Schema  pglogrepl.DebeziumSchema
+		Payload pglogrepl.DebeziumPayload
+	}
+	require.NoError(t, json.Unmarshal(messages[0], &event))
+	assert.Equal(t, "u", event.Payload.Op)
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, event.Payload.Before)
+	assert.Equal(t, map[string]interface{}{"id": float64(2), "doc": `{"a": 1}`, "big": pglogrepl.DebeziumUnavailableValue}, event.Payload.After)
+	assert.Equal(t, "postgresql", event.Payload.Source.Connector)
+	assert.Equal(t, "srv", event.Payload.Source.Name)
+	assert.Equal(t, "t", event.Payload.Source.Table)
+	assert.Equal(t, "false", event.Payload.Source.Snapshot)
+	require.NotNil(t, event.Payload.Source.TxID)
+	assert.Equal(t, int64(700), *event.Payload.Source.TxID)
+	assert.Equal(t, int64(0x110), *event.Payload.Source.LSN)
+
+	assert.Equal(t, "srv.public.t.Envelope", event.Schema.Name)
+	after := event.Schema.Fields[1]
+	assert.Equal(t, "after", after.Field)
+	assert.Equal(t, "int32", after.Fields[0].Type)
+	assert.False(t, after.Fields[0].Optional)
+	assert.Equal(t, "io.debezium.data.Json", after.Fields[1].Name)
+
+	messages = encode(0x120, "table public.t, public.u: TRUNCATE: (no-flags)")
+	require.Len(t, messages, 2)
+
+	enc = pglogrepl.NewDebeziumEncoder(pglogrepl.DebeziumOptions{})
+	wd, err := p.Parse(pglogrepl.XLogData{WALStart: 0x130, Data: []byte("table public.t: INSERT: id[integer]:3")})
+	require.NoError(t, err)
+	events, err := enc.Events(0x130, wd)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, map[string]interface{}{"id": int64(3)}, events[0].Key)
+	messages, err = enc.Encode(0x130, wd)
+	require.NoError(t, err)
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(messages[0], &payload))
+	assert.Equal(t, "c", payload["op"])
+	assert.Nil(t, payload["before"])
+
+	wd.Value.(*pglogrepl.InsertWalData).Snapshot = true
+	events, err = enc.Events(0x130, wd)
+	require.NoError(t, err)
+	assert.Equal(t, "r", events[0].Payload.Op)
+	assert.Equal(t, "true", events[0].Payload.Source.Snapshot)
+}
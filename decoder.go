@@ -0,0 +1,90 @@
+package pglogrepl
+
+import (
+	"strings"
+	"sync"
+
+	errors "golang.org/x/xerrors"
+)
+
+// Decoder decodes the XLogData of a logical replication output plugin into changes.
+// Decoders are stateful: XLogData must be passed in the order it was received.
+type Decoder interface {
+	// Decode decodes one XLogData. Depending on the plugin it returns one change, several changes
+	// (e.g. a whole transaction) or none (e.g. an incomplete chunk).
+	Decode(xlog XLogData) ([]*WalData, error)
+	// Reset discards the state tied to a replication connection. It must be called before decoding
+	// the XLogData of a new START_REPLICATION.
+	Reset()
+}
+
+// DecoderFactory creates a Decoder for the plugin arguments passed to StartReplication.
+type DecoderFactory func(pluginArgs []string) Decoder
+
+// ErrUnknownPlugin is returned by NewDecoder when no decoder is registered for the output plugin.
+var ErrUnknownPlugin = errors.New("no decoder registered for output plugin")
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]DecoderFactory{}
+)
+
+func init() {
+	RegisterDecoder("pgoutput", func([]string) Decoder {
+		p := NewWalParser()
+		return &p
+	})
+	RegisterDecoder("test_decoding", func([]string) Decoder {
+		return NewTestDecodingParser()
+	})
+	RegisterDecoder("wal2json", func(pluginArgs []string) Decoder {
+		formatVersion := 1
+		if v, ok := PluginArg(pluginArgs, "format-version"); ok && v == "2" {
+			formatVersion = 2
+		}
+		return NewWal2JSONDecoder(formatVersion)
+	})
+	RegisterDecoder("decoderbufs", func([]string) Decoder {
+		return NewDecoderbufsDecoder()
+	})
+}
+
+// RegisterDecoder registers the decoder of the output plugin with the name passed to CreateReplicationSlot.
+// Registering a plugin again replaces its decoder, so applications can also override the builtin decoders.
+func RegisterDecoder(plugin string, factory DecoderFactory) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[plugin] = factory
+}
+
+// NewDecoder creates a decoder for the output plugin. pluginArgs are the arguments passed to StartReplication,
+// which some decoders need to know the format of the plugin output.
+func NewDecoder(plugin string, pluginArgs []string) (Decoder, error) {
+	decodersMu.RLock()
+	factory, ok := decoders[plugin]
+	decodersMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("output plugin %q: %w", plugin, ErrUnknownPlugin)
+	}
+	return factory(pluginArgs), nil
+}
+
+// PluginArg returns the value of the named plugin argument in the "name 'value'" form used by StartReplication.
+// Arguments without a value return an empty value.
+func PluginArg(pluginArgs []string, name string) (string, bool) {
+	for _, arg := range pluginArgs {
+		arg = strings.TrimSpace(arg)
+		argName, value := arg, ""
+		if i := strings.IndexAny(arg, " \t"); i >= 0 {
+			argName, value = arg[:i], strings.TrimSpace(arg[i:])
+		}
+		if strings.Trim(argName, `"`) != name {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = strings.Replace(value[1:len(value)-1], "''", "'", -1)
+		}
+		return value, true
+	}
+	return "", false
+}
@@ -0,0 +1,63 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+type lineDecoder struct {
+	resets int
+}
+
+func (d *lineDecoder) Decode(xlog pglogrepl.XLogData) ([]*pglogrepl.WalData, error) {
+	wd, err := pglogrepl.NewUndefinedWalData(xlog.Data)
+	return []*pglogrepl.WalData{{Type: pglogrepl.Undefined, Value: wd}}, err
+}
+
+func (d *lineDecoder) Reset() {
+	d.resets++
+}
+
+func TestDecoderRegistry(t *testing.T) {
+	for _, plugin := range []string{"pgoutput", "test_decoding", "wal2json"} {
+		d, err := pglogrepl.NewDecoder(plugin, nil)
+		require.NoError(t, err, plugin)
+		assert.NotNil(t, d, plugin)
+	}
+
+	_, err := pglogrepl.NewDecoder("in_house", nil)
+	assert.True(t, errors.Is(err, pglogrepl.ErrUnknownPlugin))
+
+	pglogrepl.RegisterDecoder("in_house", func([]string) pglogrepl.Decoder { return &lineDecoder{} })
+	d, err := pglogrepl.NewDecoder("in_house", nil)
+	require.NoError(t, err)
+	wds, err := d.Decode(pglogrepl.XLogData{Data: []byte("hello")})
+	require.NoError(t, err)
+	require.Len(t, wds, 1)
+	d.Reset()
+	assert.Equal(t, 1, d.(*lineDecoder).resets)
+
+	d, err = pglogrepl.NewDecoder("wal2json", []string{`"format-version" '2'`})
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.(*pglogrepl.Wal2JSONDecoder).FormatVersion)
+}
+
+func TestPluginArg(t *testing.T) {
+	args := []string{"proto_version '1'", "publication_names 'it''s'", "include-xids"}
+
+	v, ok := pglogrepl.PluginArg(args, "publication_names")
+	assert.True(t, ok)
+	assert.Equal(t, "it's", v)
+
+	v, ok = pglogrepl.PluginArg(args, "include-xids")
+	assert.True(t, ok)
+	assert.Equal(t, "", v)
+
+	_, ok = pglogrepl.PluginArg(args, "messages")
+	assert.False(t, ok)
+}
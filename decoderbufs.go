@@ -0,0 +1,446 @@
+package pglogrepl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// decoderbufs RowMessage.op values.
+const (
+	decoderbufsOpUnknown = -1
+	decoderbufsOpInsert  = 0
+	decoderbufsOpUpdate  = 1
+	decoderbufsOpDelete  = 2
+	decoderbufsOpBegin   = 3
+	decoderbufsOpCommit  = 4
+)
+
+// Type OIDs of the temporal values decoderbufs sends as integers.
+const (
+	decoderbufsDateOID        = 1082
+	decoderbufsTimeOID        = 1083
+	decoderbufsTimestampOID   = 1114
+	decoderbufsTimestamptzOID = 1184
+)
+
+// DecoderbufsDecoder decodes the Protocol Buffers RowMessage payloads of the decoderbufs output plugin into the same
+// WalData values as WalParser. Typed datums are converted to the PostgreSQL text format; a missing datum is an
+// unchanged TOAST value and a column without datum is NULL.
+//
+// decoderbufs identifies tables by name, so relation IDs are assigned by the decoder and key flags are learned from
+// the old tuples of UPDATE and DELETE.
+type DecoderbufsDecoder struct {
+	relationCache
+}
+
+// NewDecoderbufsDecoder ...
+func NewDecoderbufsDecoder() *DecoderbufsDecoder {
+	return &DecoderbufsDecoder{relationCache: newRelationCache()}
+}
+
+// Decode implements Decoder. Every RowMessage decodes into exactly one WalData.
+func (d *DecoderbufsDecoder) Decode(xlog XLogData) ([]*WalData, error) {
+	msg, err := parseDecoderbufsRowMessage(xlog.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamp int64
+	if msg.commitTime != 0 {
+		timestamp = timeToPgTime(time.Unix(0, int64(msg.commitTime)*int64(time.Microsecond)))
+	}
+
+	var wd *WalData
+	switch msg.op {
+	case decoderbufsOpBegin:
+		// decoderbufs does not send the final LSN, the message is written at the first LSN of the transaction.
+		wd = &WalData{Type: BeginWalType, Value: &BeginWalData{XID: int32(msg.transactionID), Timestamp: timestamp}}
+	case decoderbufsOpCommit:
+		wd = &WalData{Type: CommitWalType, Value: &CommitWalData{LsnCommit: xlog.WALStart, LsnTransaction: xlog.WALStart, Timestamp: timestamp}}
+	case decoderbufsOpInsert:
+		name, err := splitDecoderbufsTable(msg.table)
+		if err != nil {
+			return nil, err
+		}
+		rel := d.relation(name, msg.newTuple)
+		wd = &WalData{Type: Insert, Value: &InsertWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, msg.newTuple)}}
+	case decoderbufsOpUpdate:
+		name, err := splitDecoderbufsTable(msg.table)
+		if err != nil {
+			return nil, err
+		}
+		if msg.oldTuple != nil {
+			d.learnKeys(name, msg.oldTuple)
+		}
+		rel := d.relation(name, msg.newTuple)
+		update := &UpdateWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, msg.newTuple)}
+		if msg.oldTuple != nil {
+			old := d.tupleData(name, msg.oldTuple)
+			update.OldTuples = &old
+		}
+		wd = &WalData{Type: Update, Value: update}
+	case decoderbufsOpDelete:
+		name, err := splitDecoderbufsTable(msg.table)
+		if err != nil {
+			return nil, err
+		}
+		d.learnKeys(name, msg.oldTuple)
+		rel := d.relation(name, nil)
+		wd = &WalData{Type: Delete, Value: &DeleteWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, msg.oldTuple)}}
+	default:
+		undefined, err := NewUndefinedWalData(xlog.Data)
+		if err != nil {
+			return nil, err
+		}
+		wd = &WalData{Type: Undefined, Value: undefined}
+	}
+
+	return []*WalData{wd}, nil
+}
+
+// Reset implements Decoder. decoderbufs has no per-connection state; relation IDs are kept so that
+// they stay stable across reconnects.
+func (d *DecoderbufsDecoder) Reset() {}
+
+// splitDecoderbufsTable unquotes the table name decoderbufs prints with quote_qualified_identifier.
+func splitDecoderbufsTable(table string) (string, error) {
+	var parts []string
+	for s := table; ; {
+		var part string
+		if strings.HasPrefix(s, `"`) {
+			var err error
+			if part, s, err = parseQuoted(s, '"'); err != nil {
+				return "", errors.Errorf("bad decoderbufs table name %q: %w", table, err)
+			}
+		} else {
+			i := strings.IndexByte(s, '.')
+			if i < 0 {
+				i = len(s)
+			}
+			part, s = s[:i], s[i:]
+		}
+		parts = append(parts, part)
+		if s == "" {
+			break
+		}
+		if s[0] != '.' {
+			return "", errors.Errorf("bad decoderbufs table name %q", table)
+		}
+		s = s[1:]
+	}
+	return strings.Join(parts, "."), nil
+}
+
+type decoderbufsRowMessage struct {
+	transactionID uint32
+	commitTime    uint64
+	table         string
+	op            int32
+	newTuple      []textColumn
+	oldTuple      []textColumn
+}
+
+func parseDecoderbufsRowMessage(data []byte) (*decoderbufsRowMessage, error) {
+	msg := &decoderbufsRowMessage{op: decoderbufsOpUnknown}
+	r := protobufReader{data: data}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == 1 && wireType == protobufVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			msg.transactionID = uint32(v)
+		case field == 2 && wireType == protobufVarint:
+			if msg.commitTime, err = r.varint(); err != nil {
+				return nil, err
+			}
+		case field == 3 && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			msg.table = string(b)
+		case field == 4 && wireType == protobufVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			msg.op = int32(v)
+		case (field == 5 || field == 6) && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			col, err := parseDecoderbufsDatum(b)
+			if err != nil {
+				return nil, err
+			}
+			if field == 5 {
+				msg.newTuple = append(msg.newTuple, col)
+			} else {
+				msg.oldTuple = append(msg.oldTuple, col)
+			}
+		default:
+			if err := r.skip(wireType); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return msg, nil
+}
+
+// parseDecoderbufsDatum parses a DatumMessage into a column with its value in the text format.
+func parseDecoderbufsDatum(data []byte) (textColumn, error) {
+	var name string
+	var typeOid uint64
+	tuple := Tuple{IsNull: true}
+	isInteger := false
+
+	r := protobufReader{data: data}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return textColumn{}, err
+		}
+
+		var value string
+		hasValue := true
+		switch {
+		case field == 1 && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return textColumn{}, err
+			}
+			name = string(b)
+			hasValue = false
+		case field == 2 && wireType == protobufVarint:
+			if typeOid, err = r.varint(); err != nil {
+				return textColumn{}, err
+			}
+			hasValue = false
+		case field == 3 && wireType == protobufVarint:
+			v, err := r.varint()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = strconv.FormatInt(int64(int32(v)), 10)
+			isInteger = true
+		case field == 4 && wireType == protobufVarint:
+			v, err := r.varint()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = strconv.FormatInt(int64(v), 10)
+			isInteger = true
+		case field == 5 && wireType == protobufFixed32:
+			v, err := r.fixed32()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = strconv.FormatFloat(float64(math.Float32frombits(v)), 'g', -1, 32)
+		case field == 6 && wireType == protobufFixed64:
+			v, err := r.fixed64()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64)
+		case field == 7 && wireType == protobufVarint:
+			v, err := r.varint()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = "f"
+			if v != 0 {
+				value = "t"
+			}
+		case field == 8 && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = string(b)
+		case field == 9 && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return textColumn{}, err
+			}
+			value = `\x` + hex.EncodeToString(b)
+		case field == 10 && wireType == protobufBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return textColumn{}, err
+			}
+			if value, err = parseDecoderbufsPoint(b); err != nil {
+				return textColumn{}, err
+			}
+		case field == 11 && wireType == protobufVarint:
+			if _, err := r.varint(); err != nil {
+				return textColumn{}, err
+			}
+			tuple = Tuple{IsTOAST: true}
+			hasValue = false
+		default:
+			if err := r.skip(wireType); err != nil {
+				return textColumn{}, err
+			}
+			hasValue = false
+		}
+
+		if hasValue {
+			tuple = Tuple{Value: []byte(value)}
+		}
+	}
+	if isInteger && tuple.Value != nil {
+		// The type OID may follow the value, so temporal values are converted last.
+		n, _ := strconv.ParseInt(string(tuple.Value), 10, 64)
+		tuple.Value = []byte(formatDecoderbufsInteger(typeOid, n))
+	}
+
+	pgty, isArray := GetPgTypeById(int(typeOid))
+	col := RelationColumn{Name: name, Type: pgty, IsArray: isArray, Modifier: -1}
+	tuple.RelCol = col
+	return textColumn{RelationColumn: col, Tuple: tuple}, nil
+}
+
+// formatDecoderbufsInteger formats an integer datum as PostgreSQL text. decoderbufs sends dates as days and
+// timestamps as microseconds since the Unix epoch, and times as microseconds since midnight. timestamptz is formatted
+// in UTC.
+func formatDecoderbufsInteger(typeOid uint64, n int64) string {
+	micros := func(n int64) time.Time {
+		return time.Unix(n/1000000, n%1000000*1000).UTC()
+	}
+	switch typeOid {
+	case decoderbufsDateOID:
+		return time.Unix(n*86400, 0).UTC().Format("2006-01-02")
+	case decoderbufsTimeOID:
+		return micros(n).Format("15:04:05.999999")
+	case decoderbufsTimestampOID:
+		return micros(n).Format("2006-01-02 15:04:05.999999")
+	case decoderbufsTimestamptzOID:
+		return micros(n).Format("2006-01-02 15:04:05.999999-07")
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func parseDecoderbufsPoint(data []byte) (string, error) {
+	var x, y float64
+	r := protobufReader{data: data}
+	for !r.done() {
+		field, wireType, err := r.key()
+		if err != nil {
+			return "", err
+		}
+		if (field == 1 || field == 2) && wireType == protobufFixed64 {
+			v, err := r.fixed64()
+			if err != nil {
+				return "", err
+			}
+			if field == 1 {
+				x = math.Float64frombits(v)
+			} else {
+				y = math.Float64frombits(v)
+			}
+			continue
+		}
+		if err := r.skip(wireType); err != nil {
+			return "", err
+		}
+	}
+	return "(" + strconv.FormatFloat(x, 'g', -1, 64) + "," + strconv.FormatFloat(y, 'g', -1, 64) + ")", nil
+}
+
+// Protocol Buffers wire types.
+const (
+	protobufVarint  = 0
+	protobufFixed64 = 1
+	protobufBytes   = 2
+	protobufFixed32 = 5
+)
+
+var errProtobufTruncated = errors.New("truncated protobuf message")
+
+// protobufReader is a cursor over a Protocol Buffers encoded message.
+type protobufReader struct {
+	data []byte
+	off  int
+}
+
+func (r *protobufReader) done() bool {
+	return r.off >= len(r.data)
+}
+
+func (r *protobufReader) key() (int, int, error) {
+	v, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 0x7), nil
+}
+
+func (r *protobufReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 {
+		return 0, errProtobufTruncated
+	}
+	r.off += n
+	return v, nil
+}
+
+func (r *protobufReader) fixed32() (uint32, error) {
+	if len(r.data)-r.off < 4 {
+		return 0, errProtobufTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.data[r.off:])
+	r.off += 4
+	return v, nil
+}
+
+func (r *protobufReader) fixed64() (uint64, error) {
+	if len(r.data)-r.off < 8 {
+		return 0, errProtobufTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.data[r.off:])
+	r.off += 8
+	return v, nil
+}
+
+func (r *protobufReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)-r.off) < n {
+		return nil, errProtobufTruncated
+	}
+	b := r.data[r.off : r.off+int(n)]
+	r.off += int(n)
+	return b, nil
+}
+
+func (r *protobufReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case protobufVarint:
+		_, err = r.varint()
+	case protobufFixed64:
+		_, err = r.fixed64()
+	case protobufBytes:
+		_, err = r.bytes()
+	case protobufFixed32:
+		_, err = r.fixed32()
+	default:
+		err = errors.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return err
+}
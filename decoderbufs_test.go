@@ -0,0 +1,125 @@
+package pglogrepl_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func pbVarint(buf []byte, field int, v uint64) []byte {
+	return appendUvarint(appendUvarint(buf, uint64(field<<3)), v)
+}
+
+func pbBytes(buf []byte, field int, b []byte) []byte {
+	buf = appendUvarint(buf, uint64(field<<3|2))
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func pbDouble(buf []byte, field int, v float64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	return append(appendUvarint(buf, uint64(field<<3|1)), b[:]...)
+}
+
+func pbDatum(name string, typeOid uint64, value func([]byte) []byte) []byte {
+	buf := pbBytes(nil, 1, []byte(name))
+	buf = pbVarint(buf, 2, typeOid)
+	if value != nil {
+		buf = value(buf)
+	}
+	return buf
+}
+
+func TestDecoderbufsDecoder(t *testing.T) {
+	d, err := pglogrepl.NewDecoder("decoderbufs", nil)
+	require.NoError(t, err)
+	decode := func(msg []byte) *pglogrepl.WalData {
+		wds, err := d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: msg})
+		require.NoError(t, err)
+		require.Len(t, wds, 1)
+		return wds[0]
+	}
+
+	begin := pbVarint(pbVarint(pbVarint(nil, 1, 600), 2, 1577595514806671), 4, 3)
+	wd := decode(begin)
+	assert.Equal(t, int32(600), wd.Value.(*pglogrepl.BeginWalData).XID)
+	assert.Zero(t, wd.Value.(*pglogrepl.BeginWalData).Lsn)
+	assert.Equal(t, int64(1577595514806671), wd.Value.(*pglogrepl.BeginWalData).CommitTime().UnixNano()/1000)
+
+	insert := pbBytes(nil, 3, []byte(`public."My Table"`))
+	insert = pbVarint(insert, 4, 0)
+	insert = pbBytes(insert, 5, pbDatum("id", 23, func(b []byte) []byte { return pbVarint(b, 3, uint64(math.MaxUint64)) }))
+	insert = pbBytes(insert, 5, pbDatum("ok", 16, func(b []byte) []byte { return pbVarint(b, 7, 1) }))
+	insert = pbBytes(insert, 5, pbDatum("data", 17, func(b []byte) []byte { return pbBytes(b, 9, []byte{0xde, 0xad}) }))
+	insert = pbBytes(insert, 5, pbDatum("p", 600, func(b []byte) []byte { return pbBytes(b, 10, pbDouble(pbDouble(nil, 1, 1.5), 2, -2)) }))
+	insert = pbBytes(insert, 5, pbDatum("note", 25, nil))
+	wd = decode(insert)
+	require.Equal(t, pglogrepl.Insert, wd.Type)
+	ins := wd.Value.(*pglogrepl.InsertWalData)
+	assert.Equal(t, "public", ins.Relation.Namespace)
+	assert.Equal(t, "My Table", ins.Relation.RelationName)
+	values := []string{}
+	for _, tuple := range ins.Tuples.Tuples {
+		values = append(values, string(tuple.Value))
+	}
+	assert.Equal(t, []string{"-1", "t", `\xdead`, "(1.5,-2)", ""}, values)
+	assert.True(t, ins.Tuples.Tuples[4].IsNull)
+	assert.Equal(t, "bool", ins.Relation.Columns[1].Type.Typname)
+
+	update := pbBytes(nil, 3, []byte(`public."My Table"`))
+	update = pbVarint(update, 4, 1)
+	update = pbBytes(update, 5, pbDatum("id", 23, func(b []byte) []byte { return pbVarint(b, 3, 2) }))
+	update = pbBytes(update, 5, pbDatum("note", 25, func(b []byte) []byte { return pbVarint(b, 11, 1) }))
+	update = pbBytes(update, 6, pbDatum("id", 23, func(b []byte) []byte { return pbVarint(b, 3, 1) }))
+	wd = decode(update)
+	upd := wd.Value.(*pglogrepl.UpdateWalData)
+	assert.NotEqual(t, ins.Relation.Columns, upd.Relation.Columns)
+	assert.True(t, upd.Relation.Columns[0].Flag)
+	assert.True(t, upd.Tuples.Tuples[1].IsTOAST)
+	require.NotNil(t, upd.OldTuples)
+	assert.Equal(t, "1", string(upd.OldTuples.Tuples[0].Value))
+
+	wd = decode(pbVarint(pbVarint(nil, 1, 600), 4, 4))
+	assert.Equal(t, pglogrepl.CommitWalType, wd.Type)
+
+	_, err = d.Decode(pglogrepl.XLogData{Data: insert[:len(insert)-3]})
+	assert.Error(t, err)
+}
+
+func TestDecoderbufsDecoderTemporal(t *testing.T) {
+	d := pglogrepl.NewDecoderbufsDecoder()
+
+	insert := pbBytes(nil, 3, []byte("public.events"))
+	insert = pbVarint(insert, 4, 0)
+	insert = pbBytes(insert, 5, pbDatum("d", 1082, func(b []byte) []byte { return pbVarint(b, 3, 18262) }))
+	insert = pbBytes(insert, 5, pbDatum("t", 1083, func(b []byte) []byte { return pbVarint(b, 4, 45296500000) }))
+	insert = pbBytes(insert, 5, pbDatum("ts", 1114, func(b []byte) []byte { return pbVarint(b, 4, 1577836800000000) }))
+	insert = pbBytes(insert, 5, pbDatum("tstz", 1184, func(b []byte) []byte { return pbVarint(b, 4, 1577836800123456) }))
+	insert = pbBytes(insert, 5, pbDatum("old", 1114, func(b []byte) []byte { return pbVarint(b, 4, uint64(1<<64-86400000000)) }))
+	wds, err := d.Decode(pglogrepl.XLogData{Data: insert})
+	require.NoError(t, err)
+	require.Len(t, wds, 1)
+
+	values := []string{}
+	for _, tuple := range wds[0].Value.(*pglogrepl.InsertWalData).Tuples.Tuples {
+		values = append(values, string(tuple.Value))
+	}
+	assert.Equal(t, []string{
+		"2020-01-01",
+		"12:34:56.5",
+		"2020-01-01 00:00:00",
+		"2020-01-01 00:00:00.123456+00",
+		"1969-12-31 00:00:00",
+	}, values)
+}
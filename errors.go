@@ -0,0 +1,146 @@
+package pglogrepl
+
+import (
+	"strings"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// Errors matched with errors.Is by the errors of this package. Server errors are mapped from their SQLSTATE and
+// remain available as *pgconn.PgError with errors.As.
+var (
+	// ErrSlotInUse is returned for a replication slot that is active for another process (55006).
+	ErrSlotInUse = errors.New("replication slot in use")
+	// ErrSlotNotFound is returned for a replication slot that does not exist (42704).
+	ErrSlotNotFound = errors.New("replication slot not found")
+	// ErrPublicationNotFound is returned for a publication that does not exist (42704).
+	ErrPublicationNotFound = errors.New("publication not found")
+	// ErrInsufficientPrivilege is returned when the user lacks a privilege, e.g. REPLICATION (42501).
+	ErrInsufficientPrivilege = errors.New("insufficient privilege")
+	// ErrProtocolViolation is returned for a message the replication protocol does not allow at that point (08P01).
+	ErrProtocolViolation = errors.New("protocol violation")
+	// ErrSlotInvalidated matches a SlotInvalidatedError.
+	ErrSlotInvalidated = errors.New("replication slot invalidated")
+)
+
+// ReplicationError is an error classified as one of the Err values above. Its message is the one of the underlying
+// error.
+type ReplicationError struct {
+	// Kind is the Err value the error matches.
+	Kind error
+	Err  error
+}
+
+func (e *ReplicationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ReplicationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the Kind of the error.
+func (e *ReplicationError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// ClassifyError wraps a *pgconn.PgError with a known SQLSTATE in a ReplicationError. Other errors are returned
+// unchanged. The functions of this package classify the errors they return by the object they address; ClassifyError
+// is for the errors a caller receives itself, e.g. an ErrorResponse read from the stream, and tells slot errors from
+// publication errors by their message.
+func ClassifyError(err error) error {
+	pgErr, ok := err.(*pgconn.PgError)
+	if !ok {
+		return err
+	}
+	var notFound, inUse error
+	if strings.Contains(pgErr.Message, "replication slot") {
+		notFound, inUse = ErrSlotNotFound, ErrSlotInUse
+	} else if strings.Contains(pgErr.Message, "publication") {
+		notFound = ErrPublicationNotFound
+	}
+	return classifyError(err, notFound, inUse)
+}
+
+// classifyError classifies err of a command that addresses a single object: 42704 is notFound and 55006 is inUse,
+// unless they are nil.
+func classifyError(err error, notFound, inUse error) error {
+	pgErr, ok := err.(*pgconn.PgError)
+	if !ok {
+		return err
+	}
+	var kind error
+	switch pgErr.Code {
+	case "55006":
+		kind = inUse
+	case "42704":
+		kind = notFound
+	case "42501":
+		kind = ErrInsufficientPrivilege
+	case "08P01":
+		kind = ErrProtocolViolation
+	}
+	if kind == nil {
+		return err
+	}
+	return &ReplicationError{Kind: kind, Err: err}
+}
+
+// protocolViolation returns an ErrProtocolViolation with the given message.
+func protocolViolation(format string, args ...interface{}) error {
+	return &ReplicationError{Kind: ErrProtocolViolation, Err: errors.Errorf(format, args...)}
+}
+
+// SlotInvalidatedError is returned when a replication slot was invalidated, typically because it retained more WAL
+// than max_slot_wal_keep_size allows. The slot can no longer stream changes; it must be dropped and recreated, and
+// the changes it missed can only be recovered from a new snapshot. See ResyncReplicationSlot.
+type SlotInvalidatedError struct {
+	SlotName string
+	// Reason is the invalidation reason, e.g. wal_removed, or the detail of the server error if it is unknown.
+	Reason string
+	// Err is the server error, nil if the invalidation was found in pg_replication_slots.
+	Err error
+}
+
+func (e *SlotInvalidatedError) Error() string {
+	msg := "replication slot " + e.SlotName + " was invalidated"
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Unwrap returns the server error.
+func (e *SlotInvalidatedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrSlotInvalidated.
+func (e *SlotInvalidatedError) Is(target error) bool {
+	return target == ErrSlotInvalidated
+}
+
+// slotError converts the error response of a walsender streaming slotName into an error. The stream also reports
+// errors of the output plugin, e.g. a missing publication, so they are classified by ClassifyError.
+func slotError(slotName string, err *pgconn.PgError) error {
+	if invalidated := slotInvalidatedError(slotName, err); invalidated != nil {
+		return invalidated
+	}
+	return ClassifyError(err)
+}
+
+// slotInvalidatedError returns a SlotInvalidatedError if err reports that slotName was invalidated, nil otherwise.
+func slotInvalidatedError(slotName string, err *pgconn.PgError) error {
+	// Up to PostgreSQL 16 "can no longer get changes from replication slot", from 17 on "can no longer access
+	// replication slot".
+	if err.Code == "55000" && strings.Contains(err.Message, "can no longer ") && strings.Contains(err.Message, "replication slot") {
+		reason := err.Detail
+		if reason == "" {
+			reason = err.Message
+		}
+		return &SlotInvalidatedError{SlotName: slotName, Reason: reason, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  *pgconn.PgError
+		kind error
+	}{
+		{&pgconn.PgError{Code: "55006", Message: `replication slot "s" is active for PID 42`}, pglogrepl.ErrSlotInUse},
+		{&pgconn.PgError{Code: "42704", Message: `replication slot "s" does not exist`}, pglogrepl.ErrSlotNotFound},
+		{&pgconn.PgError{Code: "42704", Message: `publication "p" does not exist`}, pglogrepl.ErrPublicationNotFound},
+		{&pgconn.PgError{Code: "42501", Message: "permission denied to start WAL sender"}, pglogrepl.ErrInsufficientPrivilege},
+		{&pgconn.PgError{Code: "08P01", Message: "unexpected message type"}, pglogrepl.ErrProtocolViolation},
+	}
+	for _, tt := range tests {
+		err := errors.Errorf("failed: %w", pglogrepl.ClassifyError(tt.err))
+		assert.True(t, errors.Is(err, tt.kind), tt.err.Message)
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr))
+		assert.Equal(t, tt.err, pgErr)
+		assert.Equal(t, "failed: "+tt.err.Error(), err.Error())
+	}
+
+	other := &pgconn.PgError{Code: "42704", Message: `type "t" does not exist`}
+	assert.Equal(t, error(other), pglogrepl.ClassifyError(other))
+	assert.Nil(t, pglogrepl.ClassifyError(nil))
+}
+
+func TestStartReplicationSlotNotFound(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	err = pglogrepl.StartReplication(ctx, conn, "pglogrepl_missing", 0, pglogrepl.StartReplicationOptions{})
+	assert.True(t, errors.Is(err, pglogrepl.ErrSlotNotFound))
+
+	err = pglogrepl.DropReplicationSlot(ctx, conn, "pglogrepl_missing", pglogrepl.DropReplicationSlotOptions{})
+	assert.True(t, errors.Is(err, pglogrepl.ErrSlotNotFound))
+
+	// The connection is still usable after the error.
+	_, err = pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+}
@@ -23,20 +23,18 @@ func main() {
 
 	var pluginArguments []string
 	if outputPlugin == "pgoutput" {
-		result := conn.Exec(context.Background(), "DROP PUBLICATION IF EXISTS pglogrepl_demo;")
-		_, err := result.ReadAll()
+		err := pglogrepl.DropPublication(context.Background(), conn, "pglogrepl_demo", true)
 		if err != nil {
 			log.Fatalln("drop publication if exists error", err)
 		}
 
-		result = conn.Exec(context.Background(), "CREATE PUBLICATION pglogrepl_demo FOR ALL TABLES;")
-		_, err = result.ReadAll()
+		err = pglogrepl.CreatePublication(context.Background(), conn, "pglogrepl_demo", pglogrepl.PublicationOptions{AllTables: true})
 		if err != nil {
 			log.Fatalln("create publication error", err)
 		}
 		log.Println("create publication pglogrepl_demo")
 
-		pluginArguments = []string{"proto_version '1'", "publication_names 'pglogrepl_demo'"}
+		pluginArguments = pglogrepl.PgOutputOptions{Publications: []string{"pglogrepl_demo"}}.PluginArgs()
 	}
 
 	sysident, err := pglogrepl.IdentifySystem(context.Background(), conn)
@@ -62,7 +60,11 @@ func main() {
 	standbyMessageTimeout := time.Second * 10
 	nextStandbyMessageDeadline := time.Now().Add(standbyMessageTimeout)
 
-	walParser := pglogrepl.NewWalParser()
+	decoder, err := pglogrepl.NewDecoder(outputPlugin, pluginArguments)
+	if err != nil {
+		log.Fatalln("NewDecoder failed:", err)
+	}
+
 	for {
 		if time.Now().After(nextStandbyMessageDeadline) {
 			err = pglogrepl.SendStandbyStatusUpdate(context.Background(), conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: clientXLogPos})
@@ -107,12 +109,14 @@ func main() {
 
 				clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.Data))
 
-				walData, err := walParser.Parse(xld)
+				walData, err := decoder.Decode(xld)
 				if err != nil {
 					panic(err)
 				}
 
-				log.Println(walData.Value.String())
+				for _, wd := range walData {
+					log.Println(wd.Value.String())
+				}
 			}
 		default:
 			log.Printf("Received unexpected message: %#v\n", msg)
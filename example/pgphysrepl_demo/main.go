@@ -46,6 +46,9 @@ func main() {
 	finishTimeout := time.Second * 15
 	finishDeadline := time.Now().Add(finishTimeout)
 
+	walDecoder := pglogrepl.NewXLogRecordDecoder()
+	walStats := pglogrepl.NewWalStats(sysident.XLogPos, 0)
+
 	for {
 		if time.Now().After(finishDeadline) {
 			log.Println("Stopping replication since finish timeout expired", finishTimeout)
@@ -90,15 +93,27 @@ func main() {
 				if err != nil {
 					log.Fatalln("ParseXLogData failed:", err)
 				}
-				log.Println("XLogData =>", "WALStart", xld.WALStart, "ServerWALEnd", xld.ServerWALEnd, "ServerTime:", xld.ServerTime, "WALData size", len(xld.WALData))
+				log.Println("XLogData =>", "WALStart", xld.WALStart, "ServerWALEnd", xld.ServerWALEnd, "ServerTime:", xld.ServerTime, "WALData size", len(xld.Data))
+
+				clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.Data))
 
-				clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.WALData))
+				records, err := walDecoder.Decode(xld)
+				if err != nil {
+					log.Fatalln("XLogRecordDecoder failed:", err)
+				}
+				for _, rec := range records {
+					walStats.Add(rec)
+				}
 			}
 		default:
 			log.Printf("Received unexpected message: %#v\n", msg)
 		}
 
 	}
+	if err := walStats.WriteReport(os.Stdout, false); err != nil {
+		log.Fatalln("failed to write WAL statistics:", err)
+	}
+
 	copyDoneResult, err := pglogrepl.SendStandbyCopyDone(context.Background(), conn)
 	if err != nil {
 		log.Fatalln("failed to end replicating:", err)
@@ -146,9 +161,9 @@ func main() {
 			if err != nil {
 				log.Fatalln("ParseXLogData failed:", err)
 			}
-			log.Println("XLogData =>", "WALStart", xld.WALStart, "ServerWALEnd", xld.ServerWALEnd, "ServerTime:", xld.ServerTime, "WALData size", len(xld.WALData))
+			log.Println("XLogData =>", "WALStart", xld.WALStart, "ServerWALEnd", xld.ServerWALEnd, "ServerTime:", xld.ServerTime, "WALData size", len(xld.Data))
 
-			clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.WALData))
+			clientXLogPos = xld.WALStart + pglogrepl.LSN(len(xld.Data))
 		}
 	default:
 		log.Printf("Received unexpected message: %#v\n", msg)
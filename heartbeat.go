@@ -0,0 +1,188 @@
+package pglogrepl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// HeartbeatOptions configures a Heartbeat.
+type HeartbeatOptions struct {
+	// Interval is the interval between heartbeats written by Run. Defaults to 10 seconds.
+	Interval time.Duration
+	// Prefix is the prefix of the heartbeat messages. Defaults to "pglogrepl.heartbeat".
+	Prefix string
+	// Table, given as schema.table, switches from logical decoding messages to an upsert on a heartbeat table, for
+	// output plugins that do not send messages. The table is created if it does not exist and must be published.
+	Table string
+}
+
+// Heartbeat keeps a slot advancing on a database with little traffic. Without changes in the database of a slot the
+// stream only carries keepalives, so the consumer has nothing to acknowledge and the slot retains the WAL of the
+// other databases.
+//
+// Run periodically writes a heartbeat on a regular connection. The stream consumer passes every decoded change
+// through Process, which recognizes the heartbeats, and reports the position returned by Ack as flushed. Ack only
+// moves past the consumer's own flush position while no user transaction is pending: the consumer has flushed every
+// user transaction received and is not inside one.
+type Heartbeat struct {
+	conn    *pgconn.PgConn
+	options HeartbeatOptions
+
+	created bool // the heartbeat table exists
+
+	mu         sync.Mutex
+	inTx       bool
+	userTx     bool // the current transaction has user changes
+	lastUser   LSN  // position of the last user transaction
+	lastBeat   LSN  // position of the last heartbeat outside user transactions
+	lastBeatAt time.Time
+}
+
+// NewHeartbeat creates a Heartbeat writing on the regular connection conn.
+func NewHeartbeat(conn *pgconn.PgConn, options HeartbeatOptions) *Heartbeat {
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Second
+	}
+	if options.Prefix == "" {
+		options.Prefix = "pglogrepl.heartbeat"
+	}
+	return &Heartbeat{conn: conn, options: options}
+}
+
+// Run writes a heartbeat every Interval until ctx is canceled, then returns ctx.Err(). It must not be called
+// concurrently with Beat.
+func (h *Heartbeat) Run(ctx context.Context) error {
+	ticker := time.NewTicker(h.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.Beat(ctx); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Beat writes one heartbeat.
+func (h *Heartbeat) Beat(ctx context.Context) error {
+	if h.options.Table == "" {
+		params := [][]byte{[]byte(h.options.Prefix), []byte(time.Now().UTC().Format(time.RFC3339Nano))}
+		result := h.conn.ExecParams(ctx, "SELECT pg_logical_emit_message(false, $1, $2)", params, nil, nil, nil).Read()
+		if result.Err != nil {
+			return errors.Errorf("failed to emit heartbeat: %w", result.Err)
+		}
+		return nil
+	}
+
+	table := quoteQualifiedName(h.options.Table)
+	if !h.created {
+		sql := "CREATE TABLE IF NOT EXISTS " + table + " (id int PRIMARY KEY, ts timestamptz NOT NULL)"
+		if _, err := h.conn.Exec(ctx, sql).ReadAll(); err != nil {
+			return errors.Errorf("failed to create heartbeat table: %w", err)
+		}
+		h.created = true
+	}
+	sql := "INSERT INTO " + table + " (id, ts) VALUES (1, now()) ON CONFLICT (id) DO UPDATE SET ts = excluded.ts"
+	if _, err := h.conn.Exec(ctx, sql).ReadAll(); err != nil {
+		return errors.Errorf("failed to write heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Process handles a change decoded from the stream. lsn is the WALStart of the XLogData that carried it. It reports
+// whether the change is a heartbeat, which the consumer should not pass on.
+func (h *Heartbeat) Process(wd *WalData, lsn LSN) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch v := wd.Value.(type) {
+	case *BeginWalData:
+		h.inTx = true
+		h.userTx = false
+	case *CommitWalData:
+		if h.userTx {
+			h.lastUser = lsn
+		} else {
+			// A transaction with nothing but heartbeats.
+			h.beat(lsn)
+		}
+		h.inTx = false
+		h.userTx = false
+	case *MessageWalData:
+		if v.Prefix == h.options.Prefix {
+			if !h.inTx {
+				h.beat(lsn)
+			}
+			return true
+		}
+		h.change(lsn)
+	case *InsertWalData:
+		if h.isTable(&v.Relation) {
+			return true
+		}
+		h.change(lsn)
+	case *UpdateWalData:
+		if h.isTable(&v.Relation) {
+			return true
+		}
+		h.change(lsn)
+	case *RelationWalData:
+		return h.isTable(v)
+	case *OriginWalData:
+	default:
+		h.change(lsn)
+	}
+	return false
+}
+
+// Ack returns the position to report as flushed, given the position the consumer has flushed.
+func (h *Heartbeat) Ack(flushed LSN) LSN {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inTx || flushed < h.lastUser || h.lastBeat <= flushed {
+		return flushed
+	}
+	return h.lastBeat
+}
+
+// LastBeat returns the time the last heartbeat was received, the zero time if none was.
+func (h *Heartbeat) LastBeat() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastBeatAt
+}
+
+func (h *Heartbeat) beat(lsn LSN) {
+	if lsn > h.lastBeat {
+		h.lastBeat = lsn
+	}
+	h.lastBeatAt = time.Now()
+}
+
+// change notes a user change, which may arrive outside a transaction with plugins that do not send Begin.
+func (h *Heartbeat) change(lsn LSN) {
+	if h.inTx {
+		h.userTx = true
+	} else if lsn > h.lastUser {
+		h.lastUser = lsn
+	}
+}
+
+func (h *Heartbeat) isTable(rel *RelationWalData) bool {
+	if h.options.Table == "" {
+		return false
+	}
+	table := h.options.Table
+	if !strings.Contains(table, ".") {
+		table = "public." + table
+	}
+	return rel.FullName() == table
+}
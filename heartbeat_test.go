@@ -0,0 +1,43 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestHeartbeatAck(t *testing.T) {
+	h := pglogrepl.NewHeartbeat(nil, pglogrepl.HeartbeatOptions{})
+	beat := &pglogrepl.WalData{Type: pglogrepl.Message, Value: &pglogrepl.MessageWalData{Prefix: "pglogrepl.heartbeat", Content: []byte("x")}}
+	rel := pglogrepl.RelationWalData{Namespace: "public", RelationName: "t"}
+
+	assert.True(t, h.Process(beat, 100))
+	assert.Equal(t, pglogrepl.LSN(100), h.Ack(50))
+	assert.False(t, h.LastBeat().IsZero())
+
+	// A user transaction holds the heartbeat back until the consumer has flushed it.
+	assert.False(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.BeginWalType, Value: &pglogrepl.BeginWalData{}}, 200))
+	assert.False(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.Insert, Value: &pglogrepl.InsertWalData{Relation: rel}}, 200))
+	assert.Equal(t, pglogrepl.LSN(100), h.Ack(100))
+	assert.False(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.CommitWalType, Value: &pglogrepl.CommitWalData{}}, 210))
+	assert.True(t, h.Process(beat, 300))
+	assert.Equal(t, pglogrepl.LSN(100), h.Ack(100))
+	assert.Equal(t, pglogrepl.LSN(300), h.Ack(210))
+
+	// The consumer's position is never moved back.
+	assert.Equal(t, pglogrepl.LSN(400), h.Ack(400))
+}
+
+func TestHeartbeatTable(t *testing.T) {
+	h := pglogrepl.NewHeartbeat(nil, pglogrepl.HeartbeatOptions{Table: "pglogrepl_heartbeat"})
+	rel := pglogrepl.RelationWalData{Namespace: "public", RelationName: "pglogrepl_heartbeat"}
+
+	assert.False(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.BeginWalType, Value: &pglogrepl.BeginWalData{}}, 100))
+	assert.True(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.Relation, Value: &rel}, 100))
+	assert.True(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.Update, Value: &pglogrepl.UpdateWalData{Relation: rel}}, 100))
+	assert.Equal(t, pglogrepl.LSN(50), h.Ack(50))
+	assert.False(t, h.Process(&pglogrepl.WalData{Type: pglogrepl.CommitWalType, Value: &pglogrepl.CommitWalData{}}, 120))
+	assert.Equal(t, pglogrepl.LSN(120), h.Ack(50))
+}
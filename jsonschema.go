@@ -0,0 +1,125 @@
+package pglogrepl
+
+// JSONSchemaDraft is the JSON Schema dialect of the generated schemas.
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is a JSON Schema document, limited to the keywords used for describing rows.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+// NewJSONSchema generates the JSON Schema of the rows of rel as returned by TupleData.Map.
+//
+// Key columns are required and not nullable. Other columns are nullable and not required, because unchanged
+// TOASTed values are left out of updated rows. Types are mapped like Tuple.Decode maps values: float NaN and
+// infinities are strings, bytea is base64 and json columns accept any value. Timestamps, dates, times and uuids carry
+// format hints. Since the values are in the PostgreSQL text format, which the date-time and time formats do not
+// match, temporal columns are validated by patterns of the ISO DateStyle output; format is only an annotation in
+// draft 2020-12.
+func NewJSONSchema(rel *RelationWalData) *JSONSchema {
+	noAdditional := false
+	s := &JSONSchema{
+		Schema:               JSONSchemaDraft,
+		Title:                rel.FullName(),
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema, len(rel.Columns)),
+		AdditionalProperties: &noAdditional,
+	}
+
+	for _, c := range rel.Columns {
+		column := jsonSchemaType(c.Type)
+		if c.IsArray {
+			column = &JSONSchema{Type: "array", Items: nullableJSONSchema(column)}
+		}
+		if c.Flag {
+			s.Required = append(s.Required, c.Name)
+		} else {
+			column = nullableJSONSchema(column)
+		}
+		s.Properties[c.Name] = column
+	}
+	return s
+}
+
+// NewJSONSchemas generates the JSON Schemas of relations, e.g. the relations known to a parser, by full name.
+func NewJSONSchemas(relations []RelationWalData) map[string]*JSONSchema {
+	schemas := make(map[string]*JSONSchema, len(relations))
+	for i := range relations {
+		schemas[relations[i].FullName()] = NewJSONSchema(&relations[i])
+	}
+	return schemas
+}
+
+func jsonSchemaType(ty PgType) *JSONSchema {
+	switch ty.Typname {
+	case "bool":
+		return &JSONSchema{Type: "boolean"}
+	case "int2", "int4", "int8", "oid", "xid", "cid":
+		return &JSONSchema{Type: "integer"}
+	case "float4", "float8":
+		return &JSONSchema{AnyOf: []*JSONSchema{
+			{Type: "number"},
+			{Type: "string", Enum: []interface{}{"NaN", "Infinity", "-Infinity"}},
+		}}
+	case "bytea":
+		return &JSONSchema{Type: "string", ContentEncoding: "base64"}
+	case "json", "jsonb":
+		return &JSONSchema{}
+	case "timestamp":
+		return &JSONSchema{Type: "string", Format: "date-time", Pattern: jsonSchemaInfinity(`\d{4,}-\d{2}-\d{2} ` + jsonSchemaTime + `( BC)?`)}
+	case "timestamptz":
+		return &JSONSchema{Type: "string", Format: "date-time", Pattern: jsonSchemaInfinity(`\d{4,}-\d{2}-\d{2} ` + jsonSchemaTime + jsonSchemaOffset + `( BC)?`)}
+	case "date":
+		return &JSONSchema{Type: "string", Format: "date", Pattern: jsonSchemaInfinity(`\d{4,}-\d{2}-\d{2}( BC)?`)}
+	case "time":
+		return &JSONSchema{Type: "string", Format: "time", Pattern: "^" + jsonSchemaTime + "$"}
+	case "timetz":
+		return &JSONSchema{Type: "string", Format: "time", Pattern: "^" + jsonSchemaTime + jsonSchemaOffset + "$"}
+	case "uuid":
+		return &JSONSchema{Type: "string", Format: "uuid"}
+	}
+	return &JSONSchema{Type: "string"}
+}
+
+// Patterns of the parts of PostgreSQL temporal values in the ISO DateStyle.
+const (
+	jsonSchemaTime   = `\d{2}:\d{2}:\d{2}(\.\d+)?`
+	jsonSchemaOffset = `[+-]\d{2}(:\d{2}){0,2}`
+)
+
+// jsonSchemaInfinity returns a pattern matching the values matched by pattern and the infinities of dates and
+// timestamps.
+func jsonSchemaInfinity(pattern string) string {
+	return "^(" + pattern + "|infinity|-infinity)$"
+}
+
+// nullableJSONSchema returns s extended to accept null.
+func nullableJSONSchema(s *JSONSchema) *JSONSchema {
+	switch t := s.Type.(type) {
+	case string:
+		nullable := *s
+		nullable.Type = []string{t, "null"}
+		return &nullable
+	case nil:
+		if s.AnyOf == nil {
+			// An empty schema already accepts null.
+			return s
+		}
+		nullable := *s
+		nullable.AnyOf = append(append([]*JSONSchema{}, s.AnyOf...), &JSONSchema{Type: "null"})
+		return &nullable
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package pglogrepl_test
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestJSONSchema(t *testing.T) {
+	p := pglogrepl.NewTestDecodingParser()
+	for _, line := range []string{
+		`table public.b: INSERT: x[text]:'x'`,
+		`table public.a: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:1 at[timestamp with time zone]:'2020-01-01 00:00:00+00' ratio[double precision]:null doc[jsonb]:null tags[uuid[]]:null`,
+	} {
+		_, err := p.Parse(pglogrepl.XLogData{Data: []byte(line)})
+		require.NoError(t, err)
+	}
+
+	relations := p.Relations()
+	require.Len(t, relations, 2)
+	assert.Equal(t, "public.b", relations[0].FullName())
+
+	schemas := pglogrepl.NewJSONSchemas(relations)
+	doc, err := json.Marshal(schemas["public.a"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "public.a",
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"at": {"type": ["string", "null"], "format": "date-time", "pattern": "^(\\d{4,}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}(\\.\\d+)?[+-]\\d{2}(:\\d{2}){0,2}( BC)?|infinity|-infinity)$"},
+			"ratio": {"anyOf": [{"type": "number"}, {"type": "string", "enum": ["NaN", "Infinity", "-Infinity"]}, {"type": "null"}]},
+			"doc": {},
+			"tags": {"type": ["array", "null"], "items": {"type": ["string", "null"], "format": "uuid"}}
+		},
+		"required": ["id"],
+		"additionalProperties": false
+	}`, string(doc))
+
+	walParser := pglogrepl.NewWalParser()
+	assert.Empty(t, walParser.Relations())
+}
+
+func TestJSONSchemaValidatesRows(t *testing.T) {
+	p := pglogrepl.NewTestDecodingParser()
+	wd, err := p.Parse(pglogrepl.XLogData{Data: []byte(`table public.events: INSERT: id[integer]:1 ` +
+		`ts[timestamp without time zone]:'2020-01-01 00:00:00.5' tstz[timestamp with time zone]:'2020-01-01 00:00:00+05:30' ` +
+		`d[date]:'2020-01-01' old[date]:'0044-03-15 BC' never[timestamp without time zone]:'infinity' ` +
+		`t[time without time zone]:'12:34:56' ttz[time with time zone]:'12:34:56.123+00' ratio[double precision]:NaN`)})
+	require.NoError(t, err)
+	insert := wd.Value.(*pglogrepl.InsertWalData)
+
+	row, err := insert.Tuples.Map()
+	require.NoError(t, err)
+	doc, err := json.Marshal(row)
+	require.NoError(t, err)
+	var values map[string]interface{}
+	require.NoError(t, json.Unmarshal(doc, &values))
+
+	schema := pglogrepl.NewJSONSchema(&insert.Relation)
+	assert.Len(t, values, len(schema.Properties))
+	for name, value := range values {
+		property := schema.Properties[name]
+		require.NotNil(t, property, name)
+		assert.True(t, jsonSchemaAccepts(property, value), "%s: %v", name, value)
+	}
+	assert.False(t, jsonSchemaAccepts(schema.Properties["tstz"], "2020-01-01T00:00:00Z"))
+}
+
+// jsonSchemaAccepts validates value against the keywords NewJSONSchema uses for scalar columns. format is an
+// annotation in draft 2020-12 and not validated.
+func jsonSchemaAccepts(s *pglogrepl.JSONSchema, value interface{}) bool {
+	if s.AnyOf != nil {
+		for _, sub := range s.AnyOf {
+			if jsonSchemaAccepts(sub, value) {
+				return true
+			}
+		}
+		return false
+	}
+	types, ok := s.Type.([]string)
+	if !ok && s.Type != nil {
+		types = []string{s.Type.(string)}
+	}
+	typeOK := s.Type == nil
+	for _, ty := range types {
+		switch value.(type) {
+		case nil:
+			typeOK = typeOK || ty == "null"
+		case float64:
+			typeOK = typeOK || ty == "number" || ty == "integer"
+		case string:
+			typeOK = typeOK || ty == "string"
+		}
+	}
+	if !typeOK {
+		return false
+	}
+	if str, ok := value.(string); ok {
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			return false
+		}
+		if len(s.Enum) > 0 {
+			for _, e := range s.Enum {
+				if e == str {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
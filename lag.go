@@ -0,0 +1,196 @@
+package pglogrepl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the measurements of a LagTracker.
+type Metrics interface {
+	// SetGauge sets the current value of the gauge name. help describes the gauge.
+	SetGauge(name, help string, value float64)
+}
+
+// Lag is a snapshot of the measurements of a LagTracker.
+type Lag struct {
+	// ServerWALEnd is the end of the WAL on the server as of the last message received.
+	ServerWALEnd LSN
+	// Received is the position the stream has been received up to, as reported to XLogData.
+	Received LSN
+	// Flushed is the flush position of the last standby status update sent.
+	Flushed LSN
+	// ReceiveLagBytes is the distance from Received to ServerWALEnd.
+	ReceiveLagBytes int64
+	// FlushLagBytes is the distance from Flushed to ServerWALEnd.
+	FlushLagBytes int64
+	// CommitLag is the delay between the commit of the last transaction on the server and its reception, as of the
+	// last commit. It includes the difference between the clocks of the server and the client.
+	CommitLag time.Duration
+	// StatusRTT is the round-trip time of the last standby status update that requested a reply.
+	StatusRTT time.Duration
+}
+
+// LagTracker measures how far a replication stream lags behind the server. The stream consumer reports the messages
+// it receives and the status updates it sends; the tracker derives the lags and reports them to its Metrics after
+// every change. The commit lag is only reported once the consumer records a commit with Commit. A LagTracker is safe
+// for concurrent use.
+type LagTracker struct {
+	metrics Metrics
+
+	mu       sync.Mutex
+	lag      Lag
+	rttStart time.Time
+	commits  bool // whether Commit was called
+}
+
+// NewLagTracker creates a LagTracker reporting to metrics, which may be nil.
+func NewLagTracker(metrics Metrics) *LagTracker {
+	return &LagTracker{metrics: metrics}
+}
+
+// Keepalive records a primary keepalive message. It completes the round trip of a status update that requested a
+// reply, unless the keepalive requests a reply itself: the server answers with a keepalive that does not. The reply
+// carries nothing that identifies the status update, so a keepalive the server sent for another reason in the
+// meantime, e.g. while waiting for WAL, completes the round trip early.
+func (t *LagTracker) Keepalive(pkm PrimaryKeepaliveMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pkm.ServerWALEnd > t.lag.ServerWALEnd {
+		t.lag.ServerWALEnd = pkm.ServerWALEnd
+	}
+	if !t.rttStart.IsZero() && !pkm.ReplyRequested {
+		t.lag.StatusRTT = time.Since(t.rttStart)
+		t.rttStart = time.Time{}
+	}
+	t.update()
+}
+
+// XLogData records a received XLogData message. received is the position the stream has been received up to:
+// WALStart for a logical stream, whose messages are not WAL bytes, and WALStart plus the length of Data for a
+// physical stream.
+func (t *LagTracker) XLogData(xld XLogData, received LSN) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if xld.ServerWALEnd > t.lag.ServerWALEnd {
+		t.lag.ServerWALEnd = xld.ServerWALEnd
+	}
+	if received > t.lag.Received {
+		t.lag.Received = received
+	}
+	t.update()
+}
+
+// Commit records the commit time of a received transaction, e.g. CommitWalData.TransactionEndTime.
+func (t *LagTracker) Commit(commitTime time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lag.CommitLag = time.Since(commitTime)
+	t.commits = true
+	t.update()
+}
+
+// StatusUpdate records a standby status update sent to the server. If it requests a reply, the next Keepalive
+// measures the round-trip time.
+func (t *LagTracker) StatusUpdate(ssu StandbyStatusUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	flushed := ssu.WALFlushPosition
+	if flushed == 0 {
+		// SendStandbyStatusUpdate substitutes a zero flush position with the write position.
+		flushed = ssu.WALWritePosition
+	}
+	if flushed > t.lag.Flushed {
+		t.lag.Flushed = flushed
+	}
+	if ssu.ReplyRequested {
+		t.rttStart = time.Now()
+	}
+	t.update()
+}
+
+// Lag returns the current measurements.
+func (t *LagTracker) Lag() Lag {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lag
+}
+
+func (t *LagTracker) update() {
+	t.lag.ReceiveLagBytes = lsnDistance(t.lag.Received, t.lag.ServerWALEnd)
+	t.lag.FlushLagBytes = lsnDistance(t.lag.Flushed, t.lag.ServerWALEnd)
+	if t.metrics == nil {
+		return
+	}
+	t.metrics.SetGauge("pglogrepl_server_wal_end_bytes", "End of the WAL on the server.", float64(t.lag.ServerWALEnd))
+	t.metrics.SetGauge("pglogrepl_receive_lag_bytes", "Distance from the end of the received WAL to the end of the WAL on the server.", float64(t.lag.ReceiveLagBytes))
+	t.metrics.SetGauge("pglogrepl_flush_lag_bytes", "Distance from the flush position to the end of the WAL on the server.", float64(t.lag.FlushLagBytes))
+	if t.commits {
+		t.metrics.SetGauge("pglogrepl_commit_lag_seconds", "Delay between the commit of the last transaction and its reception.", t.lag.CommitLag.Seconds())
+	}
+	t.metrics.SetGauge("pglogrepl_status_rtt_seconds", "Round-trip time of the last standby status update.", t.lag.StatusRTT.Seconds())
+}
+
+// lsnDistance returns the number of bytes from lsn to end, 0 if lsn is not behind end.
+func lsnDistance(lsn, end LSN) int64 {
+	if lsn >= end {
+		return 0
+	}
+	return int64(end - lsn)
+}
+
+// PrometheusMetrics is a Metrics that keeps the last value of every gauge and writes them in the Prometheus text
+// exposition format. It is an http.Handler, so it can be served as the metrics endpoint. It is safe for concurrent
+// use.
+type PrometheusMetrics struct {
+	mu     sync.Mutex
+	gauges map[string]prometheusGauge
+}
+
+type prometheusGauge struct {
+	help  string
+	value float64
+}
+
+// NewPrometheusMetrics creates an empty PrometheusMetrics.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{gauges: make(map[string]prometheusGauge)}
+}
+
+// SetGauge implements Metrics.
+func (m *PrometheusMetrics) SetGauge(name, help string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[name] = prometheusGauge{help: help, value: value}
+}
+
+// WriteTo writes the gauges ordered by name in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.gauges))
+	for name := range m.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		gauge := m.gauges[name]
+		help := strings.Replace(strings.Replace(gauge.help, `\`, `\\`, -1), "\n", `\n`, -1)
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(gauge.value, 'g', -1, 64))
+	}
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
@@ -0,0 +1,59 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestLagTracker(t *testing.T) {
+	metrics := pglogrepl.NewPrometheusMetrics()
+	tracker := pglogrepl.NewLagTracker(metrics)
+
+	tracker.XLogData(pglogrepl.XLogData{WALStart: 1000, ServerWALEnd: 1500, Data: make([]byte, 100)}, 1100)
+	// A logical stream is received up to the WALStart of its last message, whatever the length of the message.
+	tracker.XLogData(pglogrepl.XLogData{WALStart: 1050, ServerWALEnd: 1500, Data: make([]byte, 500)}, 1050)
+	var buf bytes.Buffer
+	_, err := metrics.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "pglogrepl_commit_lag_seconds")
+	tracker.StatusUpdate(pglogrepl.StandbyStatusUpdate{WALWritePosition: 1100, WALFlushPosition: 900, ReplyRequested: true})
+	tracker.Commit(time.Now().Add(-2 * time.Second))
+	// A keepalive that requests a reply is not the reply to the status update.
+	tracker.Keepalive(pglogrepl.PrimaryKeepaliveMessage{ServerWALEnd: 1500, ReplyRequested: true})
+	assert.Zero(t, tracker.Lag().StatusRTT)
+	time.Sleep(10 * time.Millisecond)
+	tracker.Keepalive(pglogrepl.PrimaryKeepaliveMessage{ServerWALEnd: 2000})
+
+	lag := tracker.Lag()
+	assert.Equal(t, pglogrepl.LSN(2000), lag.ServerWALEnd)
+	assert.Equal(t, pglogrepl.LSN(1100), lag.Received)
+	assert.Equal(t, pglogrepl.LSN(900), lag.Flushed)
+	assert.EqualValues(t, 900, lag.ReceiveLagBytes)
+	assert.EqualValues(t, 1100, lag.FlushLagBytes)
+	assert.True(t, lag.CommitLag >= 2*time.Second)
+	assert.True(t, lag.StatusRTT >= 10*time.Millisecond)
+
+	// A keepalive without a pending status update keeps the last round-trip time.
+	tracker.Keepalive(pglogrepl.PrimaryKeepaliveMessage{ServerWALEnd: 1800})
+	assert.Equal(t, lag.StatusRTT, tracker.Lag().StatusRTT)
+	assert.Equal(t, pglogrepl.LSN(2000), tracker.Lag().ServerWALEnd)
+
+	buf.Reset()
+	_, err = metrics.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "pglogrepl_commit_lag_seconds")
+	assert.Contains(t, buf.String(), "# TYPE pglogrepl_flush_lag_bytes gauge\npglogrepl_flush_lag_bytes 1100\n")
+	assert.Contains(t, buf.String(), "# TYPE pglogrepl_receive_lag_bytes gauge\npglogrepl_receive_lag_bytes 900\n")
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, buf.String(), rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+}
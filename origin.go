@@ -0,0 +1,67 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// CreateReplicationOrigin creates a replication origin on a regular connection.
+func CreateReplicationOrigin(ctx context.Context, conn *pgconn.PgConn, name string) error {
+	return execOriginFunction(ctx, conn, "SELECT pg_replication_origin_create($1)", name)
+}
+
+// DropReplicationOrigin drops a replication origin.
+func DropReplicationOrigin(ctx context.Context, conn *pgconn.PgConn, name string) error {
+	return execOriginFunction(ctx, conn, "SELECT pg_replication_origin_drop($1)", name)
+}
+
+// ReplicationOriginSessionSetup marks the session of conn as replaying from the origin. Changes committed in the
+// session are tagged with the origin, and the progress of the origin is advanced by ReplicationOriginXactSetup.
+func ReplicationOriginSessionSetup(ctx context.Context, conn *pgconn.PgConn, name string) error {
+	return execOriginFunction(ctx, conn, "SELECT pg_replication_origin_session_setup($1)", name)
+}
+
+// ReplicationOriginSessionReset undoes ReplicationOriginSessionSetup.
+func ReplicationOriginSessionReset(ctx context.Context, conn *pgconn.PgConn) error {
+	return execOriginFunction(ctx, conn, "SELECT pg_replication_origin_session_reset()")
+}
+
+// ReplicationOriginXactSetup sets the source position and commit time of the current transaction. The progress of
+// the session's origin advances to lsn when the transaction commits.
+func ReplicationOriginXactSetup(ctx context.Context, conn *pgconn.PgConn, lsn LSN, commitTime time.Time) error {
+	return execOriginFunction(ctx, conn, "SELECT pg_replication_origin_xact_setup($1, $2)", lsn.String(), commitTime.Format(time.RFC3339Nano))
+}
+
+// ReplicationOriginProgress returns the position replayed from the origin, 0 if there is none. With flush only the
+// position of transactions flushed to disk is returned.
+func ReplicationOriginProgress(ctx context.Context, conn *pgconn.PgConn, name string, flush bool) (LSN, error) {
+	result := conn.ExecParams(ctx, "SELECT pg_replication_origin_progress($1, $2)", [][]byte{[]byte(name), []byte(fmt.Sprint(flush))}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return 0, result.Err
+	}
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+		return 0, errors.Errorf("expected 1 result row, got %d", len(result.Rows))
+	}
+	if result.Rows[0][0] == nil {
+		return 0, nil
+	}
+	return ParseLSN(string(result.Rows[0][0]))
+}
+
+func execOriginFunction(ctx context.Context, conn *pgconn.PgConn, sql string, args ...string) error {
+	params := make([][]byte, len(args))
+	for i, arg := range args {
+		params[i] = []byte(arg)
+	}
+	return conn.ExecParams(ctx, sql, params, nil, nil, nil).Read().Err
+}
+
+// quoteLiteral quotes an SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
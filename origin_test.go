@@ -0,0 +1,69 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestWalParserOrigin(t *testing.T) {
+	data := []byte{'O'}
+	data = append(data, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(data[1:], 0x1234)
+	data = append(data, "node_b\x00"...)
+
+	p := pglogrepl.NewWalParser()
+	wd, err := p.Parse(pglogrepl.XLogData{Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.Origin, wd.Type)
+	assert.Equal(t, &pglogrepl.OriginWalData{Lsn: 0x1234, Name: "node_b"}, wd.Value)
+}
+
+func TestApplierOrigin(t *testing.T) {
+	ctx := context.Background()
+	target := &recordingTarget{row: [][]byte{[]byte("0/100")}}
+	applier := pglogrepl.NewApplier(target, pglogrepl.ApplierOptions{Origin: "node_a", SkipOrigins: []string{"node_b"}})
+
+	lsn, err := applier.SetupOrigin(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x100), lsn)
+	assert.Equal(t, []string{
+		"SELECT pg_replication_origin_create($1) WHERE NOT EXISTS (SELECT 1 FROM pg_replication_origin WHERE roname = $1) [node_a]",
+		"SELECT pg_replication_origin_session_setup($1) [node_a]",
+		"SELECT pg_replication_origin_progress($1, true) [node_a]",
+	}, target.log)
+
+	p := pglogrepl.NewTestDecodingParser()
+	apply := func(lsn pglogrepl.LSN, line string) {
+		wd, err := p.Parse(pglogrepl.XLogData{WALStart: lsn, Data: []byte(line)})
+		require.NoError(t, err)
+		require.NoError(t, applier.Apply(ctx, wd))
+	}
+
+	// A transaction the source replayed from node_b is not applied.
+	target.log = nil
+	apply(0x200, "BEGIN 700")
+	require.NoError(t, applier.Apply(ctx, &pglogrepl.WalData{Type: pglogrepl.Origin, Value: &pglogrepl.OriginWalData{Name: "node_b"}}))
+	apply(0x201, "table public.t: INSERT: id[integer]:1")
+	apply(0x300, "COMMIT 700 (at 2020-08-20 10:00:00+00)")
+	require.Len(t, target.log, 3)
+	assert.Equal(t, "BEGIN", target.log[0])
+	assert.Equal(t, "SELECT pg_replication_origin_xact_setup($1, $2) [0/300,2020-08-20T10:00:00Z]", target.log[1])
+	assert.Equal(t, "COMMIT", target.log[2])
+
+	target.log = nil
+	apply(0x400, "BEGIN 701")
+	apply(0x401, "table public.t: INSERT: id[integer]:2")
+	apply(0x500, "COMMIT 701 (at 2020-08-20 10:00:01+00)")
+	assert.Equal(t, []string{
+		"BEGIN",
+		`INSERT INTO "public"."t" ("id") VALUES ($1) [2]`,
+		"SELECT pg_replication_origin_xact_setup($1, $2) [0/500,2020-08-20T10:00:01Z]",
+		"COMMIT",
+	}, target.log)
+}
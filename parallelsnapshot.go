@@ -0,0 +1,378 @@
+package pglogrepl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// ParallelSnapshotOptions configures ExportSnapshotParallel.
+type ParallelSnapshotOptions struct {
+	SnapshotOptions
+	// Connect opens a regular connection to the source database. It is called once per worker.
+	Connect func(ctx context.Context) (*pgconn.PgConn, error)
+	// Workers is the number of connections reading chunks in parallel. Defaults to 4.
+	Workers int
+	// ChunkSize is the number of rows per chunk of tables with a single integer key column. Defaults to 100000.
+	ChunkSize int64
+	// ChunkPages is the number of pages per chunk of other tables, which are split by ctid. Defaults to 1000. Ignored
+	// before PostgreSQL 14, where such tables are read in one chunk.
+	ChunkPages int64
+	// ProgressFile records the finished chunks as JSON. When it exists, the chunks it lists are not read again.
+	ProgressFile string
+}
+
+// snapshotChunk is a part of a table read with one COPY.
+type snapshotChunk struct {
+	id    string
+	table string
+	rel   *RelationWalData
+	where string
+}
+
+// snapshotProgress is the content of a progress file.
+type snapshotProgress struct {
+	Snapshot string   `json:"snapshot"`
+	Done     []string `json:"done"`
+}
+
+// ExportSnapshotParallel reads the selected tables like ExportSnapshot, but splits them into chunks that several
+// connections sharing the exported snapshot read in parallel. Tables with a single integer replica identity column
+// are split into key ranges of ChunkSize rows, other tables into ctid ranges. PostgreSQL scans ctid ranges without
+// reading the whole table only since version 14, so on older servers the other tables are read in one chunk.
+//
+// The Relation of every table is emitted before any row, then the rows of the chunks are emitted as they are read, so
+// rows of different chunks interleave. emit is never called concurrently. The connections are closed on return.
+//
+// With a ProgressFile an interrupted export can be resumed as long as the exported snapshot still exists: the
+// chunks are planned again from the same snapshot and the finished ones are skipped. Rows of chunks that were
+// interrupted are emitted again.
+func ExportSnapshotParallel(ctx context.Context, snapshotName string, options ParallelSnapshotOptions, emit func(*WalData) error) error {
+	if options.Connect == nil {
+		return errors.New("no Connect function")
+	}
+	if options.Workers <= 0 {
+		options.Workers = 4
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = 100000
+	}
+	if options.ChunkPages <= 0 {
+		options.ChunkPages = 1000
+	}
+
+	progress, err := loadSnapshotProgress(options.ProgressFile, snapshotName)
+	if err != nil {
+		return err
+	}
+	done := make(map[string]bool, len(progress.Done))
+	for _, id := range progress.Done {
+		done[id] = true
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var conns []*pgconn.PgConn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close(context.Background())
+		}
+	}()
+	connect := func() (*pgconn.PgConn, error) {
+		conn, err := options.Connect(ctx)
+		if err != nil {
+			return nil, errors.Errorf("failed to connect: %w", err)
+		}
+		conns = append(conns, conn)
+		return conn, BeginSnapshotTransaction(ctx, conn, snapshotName)
+	}
+
+	conn, err := connect()
+	if err != nil {
+		return err
+	}
+	tables, err := SnapshotTables(ctx, conn, options.SnapshotOptions)
+	if err != nil {
+		return err
+	}
+	var chunks []snapshotChunk
+	for _, table := range tables {
+		rel, err := CatalogRelation(ctx, conn, table)
+		if err != nil {
+			return err
+		}
+		if err := emit(&WalData{Type: Relation, Value: rel}); err != nil {
+			return err
+		}
+		tableChunks, err := planSnapshotChunks(ctx, conn, table, rel, &options)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range tableChunks {
+			if !done[chunk.id] {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	workers := options.Workers
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	workerConns := []*pgconn.PgConn{conn}
+	for len(workerConns) < workers {
+		conn, err := connect()
+		if err != nil {
+			return err
+		}
+		workerConns = append(workerConns, conn)
+	}
+
+	var mu sync.Mutex // serializes emit and progress updates
+	lockedEmit := func(wd *WalData) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return emit(wd)
+	}
+	finish := func(chunk snapshotChunk) error {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Done = append(progress.Done, chunk.id)
+		return saveSnapshotProgress(options.ProgressFile, progress)
+	}
+
+	jobs := make(chan snapshotChunk)
+	errs := make(chan error, len(workerConns))
+	var wg sync.WaitGroup
+	for _, conn := range workerConns {
+		wg.Add(1)
+		go func(conn *pgconn.PgConn) {
+			defer wg.Done()
+			for chunk := range jobs {
+				sql := fmt.Sprintf("COPY (SELECT %s FROM %s WHERE %s) TO STDOUT", columnList(chunk.rel), quoteQualifiedName(chunk.table), chunk.where)
+				err := CopySnapshotRows(ctx, conn, sql, chunk.rel, lockedEmit)
+				if err == nil {
+					err = finish(chunk)
+				}
+				if err != nil {
+					errs <- errors.Errorf("chunk %s: %w", chunk.id, err)
+					cancel()
+					return
+				}
+			}
+		}(conn)
+	}
+
+	func() {
+		defer close(jobs)
+		for _, chunk := range chunks {
+			select {
+			case jobs <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// planSnapshotChunks splits a table into chunks. The plan only depends on the snapshot, so it is the same when an
+// export is resumed.
+func planSnapshotChunks(ctx context.Context, conn *pgconn.PgConn, table string, rel *RelationWalData, options *ParallelSnapshotOptions) ([]snapshotChunk, error) {
+	var conditions []string
+	if key, ok := integerKeyColumn(rel); ok {
+		bounds, err := keyChunkBounds(ctx, conn, table, key, options.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		conditions = keyRangeConditions(quoteIdentifier(key), bounds)
+	} else if serverMajorVersion(conn) < 14 {
+		// Without TID range scans every ctid chunk would scan the whole table.
+		conditions = []string{"true"}
+	} else {
+		sql := fmt.Sprintf("SELECT pg_relation_size(%s::regclass) / current_setting('block_size')::bigint", quoteLiteral(quoteQualifiedName(table)))
+		row, err := querySnapshotRow(ctx, conn, sql, 1)
+		if err != nil {
+			return nil, err
+		}
+		pages, err := strconv.ParseInt(string(row[0]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		conditions = ctidRangeConditions(pages, options.ChunkPages)
+	}
+
+	chunks := make([]snapshotChunk, len(conditions))
+	for i, where := range conditions {
+		chunks[i] = snapshotChunk{id: fmt.Sprintf("%s:%d", table, i), table: table, rel: rel, where: where}
+	}
+	return chunks, nil
+}
+
+// keyChunkBounds returns the key values starting the chunks after the first one, found by skipping chunkSize rows in
+// key order, so that the number of chunks follows the number of rows however sparse the keys are.
+func keyChunkBounds(ctx context.Context, conn *pgconn.PgConn, table, key string, chunkSize int64) ([]int64, error) {
+	column := quoteIdentifier(key)
+	var bounds []int64
+	for {
+		sql := fmt.Sprintf("SELECT %s FROM %s", column, quoteQualifiedName(table))
+		var params [][]byte
+		if len(bounds) > 0 {
+			sql += fmt.Sprintf(" WHERE %s >= $1", column)
+			params = [][]byte{[]byte(strconv.FormatInt(bounds[len(bounds)-1], 10))}
+		}
+		sql += fmt.Sprintf(" ORDER BY %s OFFSET %d LIMIT 1", column, chunkSize)
+
+		result := conn.ExecParams(ctx, sql, params, nil, nil, nil).Read()
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if len(result.Rows) == 0 {
+			return bounds, nil
+		}
+		bound, err := strconv.ParseInt(string(result.Rows[0][0]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, bound)
+	}
+}
+
+// keyRangeConditions returns the conditions of the chunks delimited by bounds. The first and last chunks are
+// open-ended so that no row is left out.
+func keyRangeConditions(column string, bounds []int64) []string {
+	if len(bounds) == 0 {
+		return []string{"true"}
+	}
+	conditions := []string{fmt.Sprintf("%s < %d", column, bounds[0])}
+	for i := 1; i < len(bounds); i++ {
+		conditions = append(conditions, fmt.Sprintf("%s >= %d AND %s < %d", column, bounds[i-1], column, bounds[i]))
+	}
+	return append(conditions, fmt.Sprintf("%s >= %d", column, bounds[len(bounds)-1]))
+}
+
+// ctidRangeConditions returns the conditions of the chunks of chunkPages pages of a table of the given size. The
+// first and last chunks are open-ended so that no row is left out.
+func ctidRangeConditions(pages, chunkPages int64) []string {
+	var conditions []string
+	for lo := int64(0); ; lo += chunkPages {
+		var where string
+		switch {
+		case lo == 0 && pages-lo <= chunkPages:
+			where = "true"
+		case lo == 0:
+			where = fmt.Sprintf("ctid < '(%d,0)'::tid", lo+chunkPages)
+		case pages-lo <= chunkPages:
+			where = fmt.Sprintf("ctid >= '(%d,0)'::tid", lo)
+		default:
+			where = fmt.Sprintf("ctid >= '(%d,0)'::tid AND ctid < '(%d,0)'::tid", lo, lo+chunkPages)
+		}
+		conditions = append(conditions, where)
+		if pages-lo <= chunkPages {
+			return conditions
+		}
+	}
+}
+
+// integerKeyColumn returns the replica identity column of rel if it is a single integer column.
+func integerKeyColumn(rel *RelationWalData) (string, bool) {
+	if rel.RelReplIdent == 'f' {
+		return "", false
+	}
+	var key *RelationColumn
+	for i := range rel.Columns {
+		if rel.Columns[i].Flag {
+			if key != nil {
+				return "", false
+			}
+			key = &rel.Columns[i]
+		}
+	}
+	if key == nil || key.IsArray {
+		return "", false
+	}
+	switch key.Type.Typname {
+	case "int2", "int4", "int8":
+		return key.Name, true
+	}
+	return "", false
+}
+
+// querySnapshotRow returns the only row of a query returning the given number of columns.
+func querySnapshotRow(ctx context.Context, conn *pgconn.PgConn, sql string, columns int) ([][]byte, error) {
+	result := conn.ExecParams(ctx, sql, nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if len(result.Rows) != 1 || len(result.Rows[0]) != columns {
+		return nil, errors.Errorf("expected 1 row with %d columns from %q", columns, sql)
+	}
+	return result.Rows[0], nil
+}
+
+func loadSnapshotProgress(path, snapshotName string) (*snapshotProgress, error) {
+	progress := &snapshotProgress{Snapshot: snapshotName}
+	if path == "" {
+		return progress, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, errors.Errorf("bad snapshot progress file %s: %w", path, err)
+	}
+	if progress.Snapshot != snapshotName {
+		return nil, errors.Errorf("snapshot progress file %s belongs to snapshot %s, not %s", path, progress.Snapshot, snapshotName)
+	}
+	return progress, nil
+}
+
+func saveSnapshotProgress(path string, progress *snapshotProgress) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
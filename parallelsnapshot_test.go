@@ -0,0 +1,53 @@
+package pglogrepl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRangeConditions(t *testing.T) {
+	assert.Equal(t, []string{"true"}, keyRangeConditions(`"id"`, nil))
+
+	// Bounds of sparse keys give one chunk per bound, not per key value.
+	assert.Equal(t, []string{
+		`"id" < -5`,
+		`"id" >= -5 AND "id" < 1000000000000000`,
+		`"id" >= 1000000000000000`,
+	}, keyRangeConditions(`"id"`, []int64{-5, 1000000000000000}))
+}
+
+func TestCtidRangeConditions(t *testing.T) {
+	assert.Equal(t, []string{"true"}, ctidRangeConditions(0, 1000))
+	assert.Equal(t, []string{"true"}, ctidRangeConditions(1000, 1000))
+	assert.Equal(t, []string{
+		"ctid < '(10,0)'::tid",
+		"ctid >= '(10,0)'::tid AND ctid < '(20,0)'::tid",
+		"ctid >= '(20,0)'::tid",
+	}, ctidRangeConditions(25, 10))
+}
+
+func TestSnapshotProgressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pglogrepl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "progress.json")
+
+	progress := &snapshotProgress{Snapshot: "00000003-00000002-1", Done: []string{"public.t:0"}}
+	require.NoError(t, saveSnapshotProgress(path, progress))
+	progress.Done = append(progress.Done, "public.t:1")
+	require.NoError(t, saveSnapshotProgress(path, progress))
+
+	loaded, err := loadSnapshotProgress(path, "00000003-00000002-1")
+	require.NoError(t, err)
+	assert.Equal(t, progress, loaded)
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = loadSnapshotProgress(path, "00000003-00000002-2")
+	assert.Error(t, err)
+}
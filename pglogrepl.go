@@ -87,7 +87,7 @@ func ParseIdentifySystem(mrr *pgconn.MultiResultReader) (IdentifySystemResult, e
 	var isr IdentifySystemResult
 	results, err := mrr.ReadAll()
 	if err != nil {
-		return isr, err
+		return isr, ClassifyError(err)
 	}
 
 	if len(results) != 1 {
@@ -138,7 +138,7 @@ func ParseTimelineHistory(mrr *pgconn.MultiResultReader) (TimelineHistoryResult,
 	var thr TimelineHistoryResult
 	results, err := mrr.ReadAll()
 	if err != nil {
-		return thr, err
+		return thr, ClassifyError(err)
 	}
 
 	if len(results) != 1 {
@@ -195,7 +195,7 @@ func ParseCreateReplicationSlot(mrr *pgconn.MultiResultReader) (CreateReplicatio
 	var crsr CreateReplicationSlotResult
 	results, err := mrr.ReadAll()
 	if err != nil {
-		return crsr, err
+		return crsr, ClassifyError(err)
 	}
 
 	if len(results) != 1 {
@@ -232,7 +232,7 @@ func DropReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName stri
 	}
 	sql := fmt.Sprintf("DROP_REPLICATION_SLOT %s %s", slotName, waitString)
 	_, err := conn.Exec(ctx, sql).ReadAll()
-	return err
+	return classifyError(err, ErrSlotNotFound, ErrSlotInUse)
 }
 
 type StartReplicationOptions struct {
@@ -273,12 +273,26 @@ func StartReplication(ctx context.Context, conn *pgconn.PgConn, slotName string,
 		switch msg := msg.(type) {
 		case *pgproto3.NoticeResponse:
 		case *pgproto3.ErrorResponse:
-			return pgconn.ErrorResponseToPgError(msg)
+			// Wait for ReadyForQuery so that the connection can run other commands.
+			for {
+				next, err := conn.ReceiveMessage(ctx)
+				if err != nil {
+					return errors.Errorf("failed to receive message: %w", err)
+				}
+				if _, ok := next.(*pgproto3.ReadyForQuery); ok {
+					break
+				}
+			}
+			pgErr := pgconn.ErrorResponseToPgError(msg)
+			if invalidated := slotInvalidatedError(slotName, pgErr); invalidated != nil {
+				return invalidated
+			}
+			return classifyError(pgErr, ErrSlotNotFound, ErrSlotInUse)
 		case *pgproto3.CopyBothResponse:
 			// This signals the start of the replication stream.
 			return nil
 		default:
-			return errors.Errorf("unexpected response: %t", msg)
+			return protocolViolation("unexpected response: %T", msg)
 		}
 	}
 }
@@ -3,7 +3,9 @@ package pglogrepl_test
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -274,3 +276,53 @@ func TestSendStandbyStatusUpdate(t *testing.T) {
 	err = pglogrepl.SendStandbyStatusUpdate(ctx, conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: sysident.XLogPos})
 	require.NoError(t, err)
 }
+
+func TestRecvLogical(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Temporary: true})
+	require.NoError(t, err)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	sqlConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, sqlConn)
+
+	results, err := sqlConn.Exec(ctx, `
+create table t(id int primary key, name text);
+insert into t values (1, 'foo');
+drop table t;
+select pg_current_wal_lsn();
+`).ReadAll()
+	require.NoError(t, err)
+	endPos, err := pglogrepl.ParseLSN(string(results[len(results)-1].Rows[0][0]))
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "pglogrepl_recvlogical")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "out.txt")
+
+	flushed, err := pglogrepl.RecvLogical(ctx, conn, slotName, pglogrepl.RecvLogicalOptions{
+		File:           file,
+		StartPos:       sysident.XLogPos,
+		EndPos:         endPos,
+		StatusInterval: time.Second,
+	})
+	require.NoError(t, err)
+	assert.True(t, flushed > sysident.XLogPos)
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "table public.t: INSERT: id[integer]:1 name[text]:'foo'\n")
+}
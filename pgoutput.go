@@ -0,0 +1,36 @@
+package pglogrepl
+
+import "strings"
+
+// PgOutputOptions are the options of the pgoutput plugin. PluginArgs turns them into StartReplicationOptions.PluginArgs.
+//
+// Only protocol version 1 with values in text format is requested, the format WalParser decodes. The binary and
+// streaming options of newer protocol versions are not supported.
+type PgOutputOptions struct {
+	Publications []string
+	Messages     bool // send logical decoding messages, PostgreSQL 14+
+	// Origin is "none" to only send changes that have no replication origin, so that changes replayed into the
+	// source by an Applier with an Origin are not sent back, or "any". PostgreSQL 16+. Older servers send an Origin
+	// message for replayed transactions instead, which ApplierOptions.SkipOrigins acts on.
+	Origin string
+}
+
+// PluginArgs returns the options as arguments of START_REPLICATION.
+func (o PgOutputOptions) PluginArgs() []string {
+	args := []string{"proto_version '1'"}
+
+	if len(o.Publications) > 0 {
+		names := make([]string, len(o.Publications))
+		for i, name := range o.Publications {
+			names[i] = quoteIdentifier(name)
+		}
+		args = append(args, "publication_names "+quoteLiteral(strings.Join(names, ",")))
+	}
+	if o.Messages {
+		args = append(args, "messages 'true'")
+	}
+	if o.Origin != "" {
+		args = append(args, "origin "+quoteLiteral(o.Origin))
+	}
+	return args
+}
@@ -0,0 +1,29 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestPgOutputOptions(t *testing.T) {
+	assert.Equal(t, []string{"proto_version '1'"}, pglogrepl.PgOutputOptions{}.PluginArgs())
+
+	args := pglogrepl.PgOutputOptions{
+		Publications: []string{"pub", "Other's"},
+		Messages:     true,
+		Origin:       "none",
+	}.PluginArgs()
+	assert.Equal(t, []string{
+		"proto_version '1'",
+		`publication_names '"pub","Other''s"'`,
+		"messages 'true'",
+		"origin 'none'",
+	}, args)
+
+	v, ok := pglogrepl.PluginArg(args, "origin")
+	assert.True(t, ok)
+	assert.Equal(t, "none", v)
+}
@@ -2,6 +2,7 @@ package pglogrepl
 
 import (
 	"fmt"
+	"strings"
 )
 
 type PgType struct {
@@ -34,6 +35,59 @@ func GetPgTypeById(oid int) (PgType, bool) {
 	return PgUnknownType, false
 }
 
+// sqlTypeNames maps the names printed by format_type() for builtin types to their oid.
+var sqlTypeNames = map[string]int{
+	"boolean":                     16,
+	`"char"`:                      18,
+	"bigint":                      20,
+	"smallint":                    21,
+	"integer":                     23,
+	"real":                        700,
+	"double precision":            701,
+	"character":                   1042,
+	"character varying":           1043,
+	"time without time zone":      1083,
+	"timestamp without time zone": 1114,
+	"timestamp with time zone":    1184,
+	"time with time zone":         1266,
+	"bit varying":                 1562,
+	"decimal":                     1700,
+}
+
+// GetPgTypeByName searches type by the name printed by format_type(), e.g. "integer",
+// "character varying(10)" or "timestamp(3) with time zone[]", or by its typname.
+// Type modifiers are ignored, trailing [] marks an array.
+//
+// returns PgType and flag is_array, PgUnknownType if the type is not known
+func GetPgTypeByName(name string) (PgType, bool) {
+	name = strings.TrimSpace(name)
+	isArray := false
+	for strings.HasSuffix(name, "[]") {
+		name = strings.TrimSpace(strings.TrimSuffix(name, "[]"))
+		isArray = true
+	}
+
+	// Strip the type modifier, which may be in the middle: timestamp(3) without time zone.
+	if i := strings.Index(name, "("); i >= 0 {
+		if j := strings.Index(name[i:], ")"); j >= 0 {
+			name = strings.TrimSpace(name[:i] + name[i+j+1:])
+			name = strings.Replace(name, "  ", " ", -1)
+		}
+	}
+	name = strings.TrimPrefix(name, "pg_catalog.")
+
+	if oid, ok := sqlTypeNames[name]; ok {
+		return PgTypes[oid], isArray
+	}
+	for _, pgt := range PgTypes {
+		if pgt.Typname == name {
+			return pgt, isArray
+		}
+	}
+
+	return PgUnknownType, isArray
+}
+
 var PgUnknownType = PgType{
 	Oid:          705,
 	ArrayTypeOid: 0,
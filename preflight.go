@@ -0,0 +1,213 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// PreflightStatus is the outcome of a preflight check.
+type PreflightStatus int
+
+const (
+	PreflightPass PreflightStatus = iota
+	PreflightWarn
+	PreflightFail
+)
+
+func (s PreflightStatus) String() string {
+	switch s {
+	case PreflightPass:
+		return "PASS"
+	case PreflightWarn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s PreflightStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// PreflightCheck is the result of one preflight check.
+type PreflightCheck struct {
+	// Name identifies the check, e.g. wal_level or publication:name.
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+	// Remediation tells how to fix a failed check or address a warning.
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// PreflightReport is the result of Preflight.
+type PreflightReport struct {
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// OK reports whether no check failed.
+func (r *PreflightReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == PreflightFail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *PreflightReport) add(name string, status PreflightStatus, message, remediation string) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Status: status, Message: message, Remediation: remediation})
+}
+
+// PreflightOptions configures Preflight.
+type PreflightOptions struct {
+	// Publications are the publications the consumer streams. Each must exist and the tables they publish updates or
+	// deletes of must have a primary key or a usable replica identity.
+	Publications []string
+	// Slots is the number of replication slots and walsenders the consumer needs. Defaults to 1.
+	Slots int
+}
+
+// Preflight checks on the regular connection conn that the server and the current user are ready for logical
+// replication: wal_level is logical, enough replication slots and walsenders are free, the user has the REPLICATION
+// attribute, and the publications exist with a replica identity for every table. pg_hba.conf cannot be checked from
+// SQL. The error is only set if a check could not be run.
+func Preflight(ctx context.Context, conn *pgconn.PgConn, options PreflightOptions) (*PreflightReport, error) {
+	if options.Slots <= 0 {
+		options.Slots = 1
+	}
+	report := &PreflightReport{}
+
+	row, err := querySnapshotRow(ctx, conn, `SELECT current_setting('wal_level'),
+	current_setting('max_replication_slots')::int - (SELECT count(*) FROM pg_replication_slots),
+	current_setting('max_wal_senders')::int - (SELECT count(*) FROM pg_stat_replication),
+	current_user, (SELECT rolreplication OR rolsuper FROM pg_roles WHERE rolname = current_user)`, 5)
+	if err != nil {
+		return nil, errors.Errorf("failed to read server settings: %w", ClassifyError(err))
+	}
+
+	if walLevel := string(row[0]); walLevel == "logical" {
+		report.add("wal_level", PreflightPass, "wal_level is logical", "")
+	} else {
+		report.add("wal_level", PreflightFail, fmt.Sprintf("wal_level is %s", walLevel),
+			"set wal_level = logical in postgresql.conf and restart the server")
+	}
+
+	freeSlots, err := strconv.Atoi(string(row[1]))
+	if err != nil {
+		return nil, err
+	}
+	if freeSlots >= options.Slots {
+		report.add("max_replication_slots", PreflightPass, fmt.Sprintf("%d replication slots free", freeSlots), "")
+	} else {
+		report.add("max_replication_slots", PreflightFail, fmt.Sprintf("%d replication slots free, %d needed", freeSlots, options.Slots),
+			"drop unused replication slots or raise max_replication_slots in postgresql.conf and restart the server")
+	}
+
+	freeSenders, err := strconv.Atoi(string(row[2]))
+	if err != nil {
+		return nil, err
+	}
+	if freeSenders >= options.Slots {
+		report.add("max_wal_senders", PreflightPass, fmt.Sprintf("%d walsenders free", freeSenders), "")
+	} else {
+		report.add("max_wal_senders", PreflightFail, fmt.Sprintf("%d walsenders free, %d needed", freeSenders, options.Slots),
+			"stop unused replication connections or raise max_wal_senders in postgresql.conf and restart the server")
+	}
+
+	user := string(row[3])
+	if textBool(row[4]) {
+		report.add("replication_privilege", PreflightPass, fmt.Sprintf("%s has the REPLICATION attribute", user), "")
+	} else {
+		report.add("replication_privilege", PreflightFail, fmt.Sprintf("%s does not have the REPLICATION attribute", user),
+			fmt.Sprintf("ALTER ROLE %s WITH REPLICATION", quoteIdentifier(user)))
+	}
+
+	if len(options.Publications) == 0 {
+		return report, nil
+	}
+	publications, err := ListPublications(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range options.Publications {
+		var pub *Publication
+		for i := range publications {
+			if publications[i].Name == name {
+				pub = &publications[i]
+			}
+		}
+		if pub == nil {
+			report.add("publication:"+name, PreflightFail, fmt.Sprintf("publication %s does not exist", name),
+				fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE ... or FOR ALL TABLES", quoteIdentifier(name)))
+			continue
+		}
+		report.add("publication:"+name, PreflightPass, fmt.Sprintf("publication %s exists", name), "")
+		if err := checkReplicaIdentity(ctx, conn, pub, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// checkReplicaIdentity checks that the tables of a publication publishing updates or deletes have a replica identity.
+func checkReplicaIdentity(ctx context.Context, conn *pgconn.PgConn, pub *Publication, report *PreflightReport) error {
+	name := "replica_identity:" + pub.Name
+	changes := false
+	for _, op := range pub.Publish {
+		if op == "update" || op == "delete" {
+			changes = true
+		}
+	}
+	if !changes {
+		report.add(name, PreflightPass, fmt.Sprintf("publication %s publishes no updates or deletes", pub.Name), "")
+		return nil
+	}
+
+	sql := `SELECT quote_ident(t.schemaname) || '.' || quote_ident(t.tablename), c.relreplident,
+	EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisprimary),
+	EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisreplident)
+FROM pg_publication_tables t
+JOIN pg_namespace n ON n.nspname = t.schemaname
+JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = t.tablename
+WHERE t.pubname = $1 ORDER BY 1`
+	result := conn.ExecParams(ctx, sql, [][]byte{[]byte(pub.Name)}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return errors.Errorf("failed to read replica identities of publication %s: %w", pub.Name, ClassifyError(result.Err))
+	}
+
+	var missing, full []string
+	for _, row := range result.Rows {
+		table := string(row[0])
+		switch string(row[1]) {
+		case "d":
+			if !textBool(row[2]) {
+				missing = append(missing, table)
+			}
+		case "i":
+			if !textBool(row[3]) {
+				missing = append(missing, table)
+			}
+		case "n":
+			missing = append(missing, table)
+		case "f":
+			full = append(full, table)
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		report.add(name, PreflightFail, "tables without a primary key or replica identity: "+strings.Join(missing, ", "),
+			"add a primary key, or ALTER TABLE ... REPLICA IDENTITY USING INDEX with a unique index on NOT NULL columns, or REPLICA IDENTITY FULL")
+	case len(full) > 0:
+		report.add(name, PreflightWarn, "tables with REPLICA IDENTITY FULL: "+strings.Join(full, ", "),
+			"add a primary key to avoid logging whole old rows and matching rows on every column")
+	default:
+		report.add(name, PreflightPass, fmt.Sprintf("all %d tables of publication %s have a replica identity", len(result.Rows), pub.Name), "")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestPreflightReport(t *testing.T) {
+	report := pglogrepl.PreflightReport{Checks: []pglogrepl.PreflightCheck{
+		{Name: "wal_level", Status: pglogrepl.PreflightPass, Message: "wal_level is logical"},
+		{Name: "replica_identity:p", Status: pglogrepl.PreflightWarn, Message: "tables with REPLICA IDENTITY FULL: t"},
+	}}
+	assert.True(t, report.OK())
+
+	data, err := json.Marshal(report.Checks[1])
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"replica_identity:p","status":"WARN","message":"tables with REPLICA IDENTITY FULL: t"}`, string(data))
+
+	report.Checks = append(report.Checks, pglogrepl.PreflightCheck{Name: "publication:q", Status: pglogrepl.PreflightFail})
+	assert.False(t, report.OK())
+}
+
+func TestPreflight(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	_, err = conn.Exec(ctx, `
+drop table if exists preflight_a, preflight_b;
+create table preflight_a(id int primary key);
+create table preflight_b(id int);
+`).ReadAll()
+	require.NoError(t, err)
+	defer conn.Exec(context.Background(), "drop table preflight_a, preflight_b").ReadAll()
+
+	const name = "pglogrepl_preflight"
+	require.NoError(t, pglogrepl.DropPublication(ctx, conn, name, true))
+	require.NoError(t, pglogrepl.CreatePublication(ctx, conn, name, pglogrepl.PublicationOptions{
+		Tables: []pglogrepl.PublicationTable{{Name: "public.preflight_a"}, {Name: "public.preflight_b"}},
+	}))
+	defer pglogrepl.DropPublication(context.Background(), conn, name, true)
+
+	report, err := pglogrepl.Preflight(ctx, conn, pglogrepl.PreflightOptions{Publications: []string{name, "pglogrepl_missing"}})
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+
+	statuses := make(map[string]pglogrepl.PreflightStatus)
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(t, pglogrepl.PreflightPass, statuses["wal_level"])
+	assert.Equal(t, pglogrepl.PreflightPass, statuses["replication_privilege"])
+	assert.Equal(t, pglogrepl.PreflightPass, statuses["publication:"+name])
+	assert.Equal(t, pglogrepl.PreflightFail, statuses["replica_identity:"+name])
+	assert.Equal(t, pglogrepl.PreflightFail, statuses["publication:pglogrepl_missing"])
+}
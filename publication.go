@@ -0,0 +1,239 @@
+package pglogrepl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// PublicationTable is a table of a publication.
+type PublicationTable struct {
+	// Name is the table as schema.table.
+	Name string
+	// Columns is the column list, nil for all columns. PostgreSQL 15+.
+	Columns []string
+	// Where is the row filter, an SQL expression used as is. PostgreSQL 15+.
+	Where string
+}
+
+// PublicationOptions describes the content and options of a publication.
+type PublicationOptions struct {
+	// AllTables publishes every table of the database, now and in the future. Tables and Schemas must be empty.
+	AllTables bool
+	Tables    []PublicationTable
+	// Schemas publishes every table of these schemas (FOR TABLES IN SCHEMA). PostgreSQL 15+.
+	Schemas []string
+	// Publish lists the published operations among insert, update, delete and truncate. nil publishes all of them.
+	Publish []string
+	// PublishViaPartitionRoot publishes changes of partitions as changes of their root table. PostgreSQL 13+.
+	PublishViaPartitionRoot bool
+}
+
+// Publication is a publication as listed by ListPublications.
+type Publication struct {
+	Name                    string
+	AllTables               bool
+	Publish                 []string
+	PublishViaPartitionRoot bool
+}
+
+// CreatePublication creates a publication. Like AlterPublication and DropPublication it uses the simple query
+// protocol, so it also works on a database replication connection; the List functions need a regular connection.
+func CreatePublication(ctx context.Context, conn *pgconn.PgConn, name string, options PublicationOptions) error {
+	sql := "CREATE PUBLICATION " + quoteIdentifier(name)
+	if options.AllTables {
+		sql += " FOR ALL TABLES"
+	} else if objects := publicationObjects(&options); objects != "" {
+		sql += " FOR " + objects
+	}
+
+	with := []string{"publish = " + quoteLiteral(publishList(conn, &options))}
+	if options.PublishViaPartitionRoot {
+		with = append(with, "publish_via_partition_root = true")
+	}
+	sql += " WITH (" + strings.Join(with, ", ") + ")"
+
+	return execPublicationSQL(ctx, conn, sql, nil)
+}
+
+// AlterPublication replaces the tables, schemas and options of a publication. The tables of a publication for all
+// tables cannot be changed, only its options.
+func AlterPublication(ctx context.Context, conn *pgconn.PgConn, name string, options PublicationOptions) error {
+	var objects string
+	if !options.AllTables {
+		objects = publicationObjects(&options)
+		if objects == "" {
+			return errors.Errorf("publication %s must have at least one table or schema", name)
+		}
+	}
+
+	// The options go first: that statement only names the publication, so a missing object is the publication.
+	with := []string{"publish = " + quoteLiteral(publishList(conn, &options))}
+	if options.PublishViaPartitionRoot || serverMajorVersion(conn) >= 13 {
+		with = append(with, fmt.Sprintf("publish_via_partition_root = %t", options.PublishViaPartitionRoot))
+	}
+	sql := fmt.Sprintf("ALTER PUBLICATION %s SET (%s)", quoteIdentifier(name), strings.Join(with, ", "))
+	if err := execPublicationSQL(ctx, conn, sql, ErrPublicationNotFound); err != nil {
+		return err
+	}
+	if objects == "" {
+		return nil
+	}
+	return execPublicationSQL(ctx, conn, fmt.Sprintf("ALTER PUBLICATION %s SET %s", quoteIdentifier(name), objects), nil)
+}
+
+// EnsurePublication creates the publication if it does not exist and otherwise brings it in line with options. It
+// fails if the publication must switch between all tables and a table list, which requires recreating it.
+func EnsurePublication(ctx context.Context, conn *pgconn.PgConn, name string, options PublicationOptions) error {
+	publications, err := ListPublications(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, pub := range publications {
+		if pub.Name != name {
+			continue
+		}
+		if pub.AllTables != options.AllTables {
+			return errors.Errorf("publication %s: cannot switch between all tables and a table list", name)
+		}
+		return AlterPublication(ctx, conn, name, options)
+	}
+	return CreatePublication(ctx, conn, name, options)
+}
+
+// DropPublication drops a publication.
+func DropPublication(ctx context.Context, conn *pgconn.PgConn, name string, ifExists bool) error {
+	sql := "DROP PUBLICATION "
+	if ifExists {
+		sql += "IF EXISTS "
+	}
+	return execPublicationSQL(ctx, conn, sql+quoteIdentifier(name), ErrPublicationNotFound)
+}
+
+// ListPublications returns the publications of the database ordered by name.
+func ListPublications(ctx context.Context, conn *pgconn.PgConn) ([]Publication, error) {
+	// to_jsonb tolerates the columns missing from older versions.
+	sql := `SELECT pubname, puballtables, pubinsert, pubupdate, pubdelete,
+	coalesce(to_jsonb(p)->>'pubtruncate', 'false'), coalesce(to_jsonb(p)->>'pubviaroot', 'false')
+FROM pg_publication p ORDER BY pubname`
+	result := conn.ExecParams(ctx, sql, nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to list publications: %w", result.Err)
+	}
+
+	publications := make([]Publication, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		pub := Publication{
+			Name:                    string(row[0]),
+			AllTables:               textBool(row[1]),
+			PublishViaPartitionRoot: textBool(row[6]),
+		}
+		for i, op := range []string{"insert", "update", "delete", "truncate"} {
+			if textBool(row[2+i]) {
+				pub.Publish = append(pub.Publish, op)
+			}
+		}
+		publications = append(publications, pub)
+	}
+	return publications, nil
+}
+
+// ListPublicationTables returns the tables of a publication ordered by name. Columns is the column list and Where is
+// the row filter on PostgreSQL 15+.
+func ListPublicationTables(ctx context.Context, conn *pgconn.PgConn, name string) ([]PublicationTable, error) {
+	// pg_publication_tables.attnames lists every column without a column list, so the column list is read from
+	// pg_publication_rel.prattrs, which is NULL then. Tables published by schema or as all tables have no row there.
+	sql := `SELECT t.schemaname, t.tablename,
+	(SELECT json_agg(a.attname ORDER BY a.attnum) FROM pg_publication_rel r
+		JOIN pg_attribute a ON a.attrelid = r.prrelid AND a.attnum = ANY(string_to_array(to_jsonb(r)->>'prattrs', ' ')::int2[])
+	WHERE r.prpubid = p.oid AND r.prrelid = format('%I.%I', t.schemaname, t.tablename)::regclass),
+	to_jsonb(t)->>'rowfilter'
+FROM pg_publication_tables t JOIN pg_publication p ON p.pubname = t.pubname WHERE t.pubname = $1 ORDER BY 1, 2`
+	result := conn.ExecParams(ctx, sql, [][]byte{[]byte(name)}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to list tables of publication %s: %w", name, result.Err)
+	}
+
+	tables := make([]PublicationTable, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		table := PublicationTable{Name: string(row[0]) + "." + string(row[1]), Where: string(row[3])}
+		if row[2] != nil {
+			if err := json.Unmarshal(row[2], &table.Columns); err != nil {
+				return nil, errors.Errorf("bad column list of %s: %w", table.Name, err)
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// publicationObjects returns the TABLE and TABLES IN SCHEMA list of a publication.
+func publicationObjects(options *PublicationOptions) string {
+	var objects []string
+	if len(options.Tables) > 0 {
+		tables := make([]string, len(options.Tables))
+		for i, table := range options.Tables {
+			tables[i] = quoteQualifiedName(table.Name)
+			if table.Columns != nil {
+				columns := make([]string, len(table.Columns))
+				for j, column := range table.Columns {
+					columns[j] = quoteIdentifier(column)
+				}
+				tables[i] += " (" + strings.Join(columns, ", ") + ")"
+			}
+			if table.Where != "" {
+				tables[i] += " WHERE (" + table.Where + ")"
+			}
+		}
+		objects = append(objects, "TABLE "+strings.Join(tables, ", "))
+	}
+	if len(options.Schemas) > 0 {
+		schemas := make([]string, len(options.Schemas))
+		for i, schema := range options.Schemas {
+			schemas[i] = quoteIdentifier(schema)
+		}
+		objects = append(objects, "TABLES IN SCHEMA "+strings.Join(schemas, ", "))
+	}
+	return strings.Join(objects, ", ")
+}
+
+// publishList returns the value of the publish option.
+func publishList(conn *pgconn.PgConn, options *PublicationOptions) string {
+	if options.Publish != nil {
+		return strings.Join(options.Publish, ", ")
+	}
+	if v := serverMajorVersion(conn); v > 0 && v < 11 {
+		return "insert, update, delete"
+	}
+	return "insert, update, delete, truncate"
+}
+
+// execPublicationSQL executes sql. A missing object (42704) is classified as notFound unless it is nil, e.g. for
+// statements whose row filters can name types.
+func execPublicationSQL(ctx context.Context, conn *pgconn.PgConn, sql string, notFound error) error {
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		return errors.Errorf("%s: %w", sql, classifyError(err, notFound, nil))
+	}
+	return nil
+}
+
+// serverMajorVersion returns the major version of the server, 0 if it is unknown.
+func serverMajorVersion(conn *pgconn.PgConn) int {
+	version := conn.ParameterStatus("server_version")
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+	major, _ := strconv.Atoi(version[:end])
+	return major
+}
+
+// textBool parses a bool in text format.
+func textBool(v []byte) bool {
+	return string(v) == "t" || string(v) == "true"
+}
@@ -0,0 +1,79 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestPublication(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	_, err = conn.Exec(ctx, `
+drop table if exists pub_a, "Pub B";
+create table pub_a(id int primary key, name text);
+create table "Pub B"(id int primary key);
+`).ReadAll()
+	require.NoError(t, err)
+	defer conn.Exec(context.Background(), `drop table pub_a, "Pub B"`).ReadAll()
+
+	const name = "pglogrepl Test"
+	require.NoError(t, pglogrepl.DropPublication(ctx, conn, name, true))
+	defer pglogrepl.DropPublication(context.Background(), conn, name, true)
+
+	options := pglogrepl.PublicationOptions{
+		Tables:  []pglogrepl.PublicationTable{{Name: "public.pub_a"}},
+		Publish: []string{"insert", "update"},
+	}
+	require.NoError(t, pglogrepl.EnsurePublication(ctx, conn, name, options))
+
+	publications, err := pglogrepl.ListPublications(ctx, conn)
+	require.NoError(t, err)
+	var pub *pglogrepl.Publication
+	for i := range publications {
+		if publications[i].Name == name {
+			pub = &publications[i]
+		}
+	}
+	require.NotNil(t, pub)
+	assert.False(t, pub.AllTables)
+	assert.Equal(t, []string{"insert", "update"}, pub.Publish)
+
+	options.Tables = append(options.Tables, pglogrepl.PublicationTable{Name: "public.Pub B"})
+	options.Publish = nil
+	require.NoError(t, pglogrepl.EnsurePublication(ctx, conn, name, options))
+
+	tables, err := pglogrepl.ListPublicationTables(ctx, conn, name)
+	require.NoError(t, err)
+	require.Len(t, tables, 2)
+	assert.Equal(t, "public.Pub B", tables[0].Name)
+	assert.Equal(t, "public.pub_a", tables[1].Name)
+	// Tables published without a column list publish all columns.
+	assert.Nil(t, tables[0].Columns)
+	assert.Nil(t, tables[1].Columns)
+
+	options.AllTables = true
+	assert.Error(t, pglogrepl.EnsurePublication(ctx, conn, name, options))
+
+	require.NoError(t, pglogrepl.DropPublication(ctx, conn, name, false))
+	err = pglogrepl.DropPublication(ctx, conn, name, false)
+	assert.True(t, errors.Is(err, pglogrepl.ErrPublicationNotFound))
+	err = pglogrepl.AlterPublication(ctx, conn, name, options)
+	assert.True(t, errors.Is(err, pglogrepl.ErrPublicationNotFound))
+}
@@ -0,0 +1,281 @@
+package pglogrepl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	errors "golang.org/x/xerrors"
+)
+
+// RecvLogicalOptions configures RecvLogical.
+type RecvLogicalOptions struct {
+	// File is the path of the output file. "-" writes to stdout, which is never rotated.
+	File string
+	// RotateSize rotates the output file once it reaches this many bytes. 0 disables size based rotation.
+	RotateSize int64
+	// RotateInterval rotates the output file once it has been open this long. 0 disables time based rotation.
+	RotateInterval time.Duration
+	// StatusInterval is the interval between fsyncs and standby status updates. Defaults to 10 seconds.
+	StatusInterval time.Duration
+	// StartPos is the LSN to start streaming from. 0 continues from the slot's confirmed position.
+	StartPos LSN
+	// EndPos stops streaming once a record past it or a keepalive at or past it is received. 0 streams forever.
+	EndPos     LSN
+	PluginArgs []string
+	// Lag, if set, tracks the lag of the stream.
+	Lag *LagTracker
+}
+
+// RecvLogical streams a logical replication slot into a file like pg_recvlogical: the output plugin's data of
+// every XLogData message is written followed by a newline.
+//
+// The file is fsynced on every status interval, on rotation and before returning, and the flush position reported
+// to the server only covers data that has been fsynced. Rotated files are renamed to File with the LSN of their
+// first record appended.
+//
+// RecvLogical returns the last fsynced LSN, 0 if nothing was written. It returns a nil error when EndPos is reached and ctx.Err() when ctx
+// is canceled, after the written data was fsynced and acknowledged.
+func RecvLogical(ctx context.Context, conn *pgconn.PgConn, slotName string, options RecvLogicalOptions) (LSN, error) {
+	if options.File == "" {
+		return 0, errors.New("RecvLogicalOptions.File is required")
+	}
+	if options.StatusInterval <= 0 {
+		options.StatusInterval = 10 * time.Second
+	}
+
+	out := &recvLogicalFile{options: options}
+	if err := out.open(); err != nil {
+		return 0, err
+	}
+	defer out.close()
+
+	err := StartReplication(ctx, conn, slotName, options.StartPos, StartReplicationOptions{PluginArgs: options.PluginArgs})
+	if err != nil {
+		return 0, err
+	}
+
+	sendStatus := func(ctx context.Context) error {
+		if err := out.sync(); err != nil {
+			return err
+		}
+		ssu := StandbyStatusUpdate{WALWritePosition: out.written, WALFlushPosition: out.flushed, WALApplyPosition: out.flushed}
+		if out.flushed == 0 {
+			// SendStandbyStatusUpdate substitutes a zero flush position with the write position.
+			ssu.WALWritePosition = 0
+		}
+		if err := SendStandbyStatusUpdate(ctx, conn, ssu); err != nil {
+			return err
+		}
+		if options.Lag != nil {
+			options.Lag.StatusUpdate(ssu)
+		}
+		return nil
+	}
+
+	stop := func() (LSN, error) {
+		if err := sendStatus(context.Background()); err != nil {
+			return out.flushed, errors.Errorf("failed to send final standby status update: %w", err)
+		}
+		if _, err := SendStandbyCopyDone(context.Background(), conn); err != nil {
+			return out.flushed, errors.Errorf("failed to end replication: %w", err)
+		}
+		return out.flushed, nil
+	}
+
+	nextStatusDeadline := time.Now().Add(options.StatusInterval)
+	for {
+		if time.Now().After(nextStatusDeadline) {
+			if err := sendStatus(ctx); err != nil {
+				return out.flushed, errors.Errorf("failed to send standby status update: %w", err)
+			}
+			nextStatusDeadline = time.Now().Add(options.StatusInterval)
+		}
+
+		if out.rotateDue() {
+			if err := out.rotate(); err != nil {
+				return out.flushed, err
+			}
+		}
+
+		recvCtx, cancel := context.WithDeadline(ctx, nextStatusDeadline)
+		msg, err := conn.ReceiveMessage(recvCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				if err := sendStatus(context.Background()); err != nil {
+					return out.flushed, errors.Errorf("failed to send final standby status update: %w", err)
+				}
+				return out.flushed, ctx.Err()
+			}
+			if pgconn.Timeout(err) {
+				continue
+			}
+			return out.flushed, errors.Errorf("failed to receive message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			switch msg.Data[0] {
+			case PrimaryKeepaliveMessageByteID:
+				pkm, err := ParsePrimaryKeepaliveMessage(msg.Data[1:])
+				if err != nil {
+					return out.flushed, err
+				}
+				if options.Lag != nil {
+					options.Lag.Keepalive(pkm)
+				}
+				if options.EndPos != 0 && pkm.ServerWALEnd >= options.EndPos {
+					return stop()
+				}
+				if pkm.ReplyRequested {
+					nextStatusDeadline = time.Time{}
+				}
+
+			case XLogDataByteID:
+				xld, err := ParseXLogData(msg.Data[1:])
+				if err != nil {
+					return out.flushed, err
+				}
+				if options.Lag != nil {
+					options.Lag.XLogData(xld, xld.WALStart)
+				}
+				if options.EndPos != 0 && xld.WALStart > options.EndPos {
+					return stop()
+				}
+				if err := out.write(xld); err != nil {
+					return out.flushed, err
+				}
+				if options.EndPos != 0 && xld.WALStart == options.EndPos {
+					return stop()
+				}
+			}
+		case *pgproto3.CopyDone:
+			return stop()
+		case *pgproto3.ErrorResponse:
+			return out.flushed, slotError(slotName, pgconn.ErrorResponseToPgError(msg))
+		default:
+			return out.flushed, protocolViolation("unexpected message: %T", msg)
+		}
+	}
+}
+
+// recvLogicalFile is the output file of RecvLogical with its write and fsync positions.
+type recvLogicalFile struct {
+	options RecvLogicalOptions
+
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	opened   time.Time
+	firstLSN LSN
+
+	// Like in pg_recvlogical the positions start invalid (0), so no position is reported before a record was written
+	// and fsynced.
+	written LSN // LSN of the last record written
+	flushed LSN // LSN of the last record fsynced
+}
+
+func (o *recvLogicalFile) open() error {
+	if o.options.File == "-" {
+		o.f = os.Stdout
+	} else {
+		f, err := os.OpenFile(o.options.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		o.f = f
+		o.size = info.Size()
+	}
+	o.w = bufio.NewWriter(o.f)
+	o.opened = time.Now()
+	o.firstLSN = 0
+	return nil
+}
+
+func (o *recvLogicalFile) write(xld XLogData) error {
+	if o.firstLSN == 0 {
+		o.firstLSN = xld.WALStart
+	}
+	if _, err := o.w.Write(xld.Data); err != nil {
+		return err
+	}
+	if err := o.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	o.size += int64(len(xld.Data)) + 1
+	if xld.WALStart > o.written {
+		o.written = xld.WALStart
+	}
+	return nil
+}
+
+// sync makes everything written so far durable and advances the flush position.
+func (o *recvLogicalFile) sync() error {
+	if err := o.w.Flush(); err != nil {
+		return err
+	}
+	if o.f != os.Stdout {
+		if err := o.f.Sync(); err != nil {
+			return errors.Errorf("failed to fsync %s: %w", o.options.File, err)
+		}
+	}
+	o.flushed = o.written
+	return nil
+}
+
+func (o *recvLogicalFile) rotateDue() bool {
+	if o.f == os.Stdout || o.firstLSN == 0 {
+		return false
+	}
+	return (o.options.RotateSize > 0 && o.size >= o.options.RotateSize) ||
+		(o.options.RotateInterval > 0 && time.Since(o.opened) >= o.options.RotateInterval)
+}
+
+func (o *recvLogicalFile) rotate() error {
+	if err := o.sync(); err != nil {
+		return err
+	}
+	if err := o.f.Close(); err != nil {
+		return err
+	}
+
+	rotated := fmt.Sprintf("%s.%016X", o.options.File, uint64(o.firstLSN))
+	if err := os.Rename(o.options.File, rotated); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(o.options.File)); err != nil {
+		return err
+	}
+	o.size = 0
+	return o.open()
+}
+
+func (o *recvLogicalFile) close() error {
+	if o.f == os.Stdout {
+		return o.w.Flush()
+	}
+	return o.f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package pglogrepl
+
+import (
+	"sort"
+	"strings"
+)
+
+// relationCache synthesizes RelationWalData for output plugins that identify tables by name instead of
+// sending Relation messages. Relations get IDs assigned in the order they are first seen, and columns are
+// flagged as key columns once they are seen in an old key or primary key.
+type relationCache struct {
+	relations map[string]*RelationWalData
+	keys      map[string]map[string]bool
+	nextID    int32
+}
+
+func newRelationCache() relationCache {
+	return relationCache{
+		relations: make(map[string]*RelationWalData),
+		keys:      make(map[string]map[string]bool),
+	}
+}
+
+// textColumn is a column of a change printed in text form along with its value.
+type textColumn struct {
+	RelationColumn
+	Tuple Tuple
+}
+
+// Relations returns the relations seen so far ordered by ID.
+func (c *relationCache) Relations() []RelationWalData {
+	relations := make([]RelationWalData, 0, len(c.relations))
+	for _, rel := range c.relations {
+		relations = append(relations, *rel)
+	}
+	return sortedRelations(relations)
+}
+
+// relation returns the relation for name, redefining its columns when columns differ from the cached ones.
+func (c *relationCache) relation(name string, columns []textColumn) *RelationWalData {
+	rel, ok := c.relations[name]
+	if !ok {
+		c.nextID++
+		rel = &RelationWalData{ID: c.nextID, RelReplIdent: 'd'}
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			rel.Namespace, rel.RelationName = name[:i], name[i+1:]
+		} else {
+			rel.RelationName = name
+		}
+		c.relations[name] = rel
+	}
+
+	if columns != nil && !sameTextColumns(rel.Columns, columns) {
+		rel.Columns = make([]RelationColumn, 0, len(columns))
+		for _, col := range columns {
+			rel.Columns = append(rel.Columns, col.RelationColumn)
+		}
+		rel.ColumnsNum = int16(len(rel.Columns))
+	}
+
+	keys := c.keys[name]
+	for i := range rel.Columns {
+		rel.Columns[i].Flag = keys[rel.Columns[i].Name]
+	}
+	return rel
+}
+
+func (c *relationCache) learnKeys(name string, columns []textColumn) {
+	keys, ok := c.keys[name]
+	if !ok {
+		keys = make(map[string]bool)
+		c.keys[name] = keys
+	}
+	for _, col := range columns {
+		keys[col.Name] = true
+	}
+}
+
+func (c *relationCache) tupleData(name string, columns []textColumn) TupleData {
+	keys := c.keys[name]
+	td := TupleData{Tuples: make([]Tuple, 0, len(columns))}
+	for _, col := range columns {
+		t := col.Tuple
+		t.RelCol.Flag = keys[col.Name]
+		td.Tuples = append(td.Tuples, t)
+	}
+	return td
+}
+
+func sameTextColumns(cols []RelationColumn, columns []textColumn) bool {
+	if len(cols) != len(columns) {
+		return false
+	}
+	for i := range cols {
+		if cols[i].Name != columns[i].Name || cols[i].Type.Oid != columns[i].Type.Oid || cols[i].IsArray != columns[i].IsArray {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedRelations orders relations by ID.
+func sortedRelations(relations []RelationWalData) []RelationWalData {
+	sort.Slice(relations, func(i, j int) bool { return relations[i].ID < relations[j].ID })
+	return relations
+}
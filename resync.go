@@ -0,0 +1,100 @@
+package pglogrepl
+
+import (
+	"context"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// ResyncOptions configures how Resync recreates a slot.
+type ResyncOptions struct {
+	OutputPlugin string
+	// Slot are the options of the new slot. SnapshotAction is overridden with EXPORT_SNAPSHOT.
+	Slot CreateReplicationSlotOptions
+	// Snapshot selects the tables exported again.
+	Snapshot SnapshotOptions
+	// Start are the options of the replication started on the new slot.
+	Start StartReplicationOptions
+}
+
+// CheckReplicationSlot returns a SlotInvalidatedError if the slot was invalidated, e.g. because its wal_status is
+// lost. It needs a regular connection.
+func CheckReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string) error {
+	slot, err := GetReplicationSlot(ctx, conn, slotName)
+	if err != nil {
+		return err
+	}
+	if slot.Invalidated() {
+		reason := slot.InvalidationReason
+		if reason == "" && slot.Conflicting {
+			reason = "conflict with recovery"
+		}
+		return &SlotInvalidatedError{SlotName: slotName, Reason: reason}
+	}
+	return nil
+}
+
+// StartReplicationOrResync starts replication like StartReplication and, if the slot was invalidated, recovers
+// with ResyncReplicationSlot. It returns the position replication started from: startLSN, or the consistent point
+// of the new slot.
+func StartReplicationOrResync(
+	ctx context.Context,
+	replConn, dataConn *pgconn.PgConn,
+	slotName string,
+	startLSN LSN,
+	options ResyncOptions,
+	emit func(*WalData) error,
+) (LSN, error) {
+	err := StartReplication(ctx, replConn, slotName, startLSN, options.Start)
+	if err == nil {
+		return startLSN, nil
+	}
+	var invalidated *SlotInvalidatedError
+	if !errors.As(err, &invalidated) {
+		return 0, err
+	}
+	return ResyncReplicationSlot(ctx, replConn, dataConn, slotName, invalidated.Reason, options, emit)
+}
+
+// ResyncReplicationSlot drops the slot slotName on the replication connection replConn, then creates it again,
+// exports the tables selected by options and starts replication with SnapshotAndStream.
+//
+// emit first receives a Resync with reason, then the snapshot as described for ExportSnapshot. Replication is
+// started at the consistent point of the new slot, which is returned.
+func ResyncReplicationSlot(
+	ctx context.Context,
+	replConn, dataConn *pgconn.PgConn,
+	slotName, reason string,
+	options ResyncOptions,
+	emit func(*WalData) error,
+) (LSN, error) {
+	if err := DropReplicationSlot(ctx, replConn, slotName, DropReplicationSlotOptions{}); err != nil {
+		return 0, errors.Errorf("failed to drop replication slot: %w", err)
+	}
+
+	resynced := false
+	resync := func() error {
+		resynced = true
+		return emit(&WalData{Type: Resync, Value: &ResyncWalData{SlotName: slotName, Reason: reason}})
+	}
+	_, startLSN, err := SnapshotAndStream(ctx, replConn, dataConn, slotName, options.OutputPlugin, options.Slot,
+		options.Snapshot, options.Start, func(wd *WalData) error {
+			if !resynced {
+				if err := resync(); err != nil {
+					return err
+				}
+			}
+			return emit(wd)
+		})
+	if err != nil {
+		return 0, err
+	}
+	if !resynced {
+		// Nothing was exported, the Resync still tells downstream that changes were lost.
+		if err := resync(); err != nil {
+			return 0, err
+		}
+	}
+	return startLSN, nil
+}
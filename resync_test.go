@@ -0,0 +1,76 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestSlotInvalidatedError(t *testing.T) {
+	pgErr := &pgconn.PgError{Code: "55000", Message: `can no longer get changes from replication slot "s"`}
+	var err error = &pglogrepl.SlotInvalidatedError{SlotName: "s", Reason: "wal_removed", Err: pgErr}
+	err = errors.Errorf("failed to start replication: %w", err)
+
+	assert.True(t, errors.Is(err, pglogrepl.ErrSlotInvalidated))
+	var invalidated *pglogrepl.SlotInvalidatedError
+	require.True(t, errors.As(err, &invalidated))
+	assert.Equal(t, "s", invalidated.SlotName)
+	var got *pgconn.PgError
+	require.True(t, errors.As(err, &got))
+	assert.Equal(t, pgErr, got)
+	assert.Equal(t, "failed to start replication: replication slot s was invalidated: wal_removed", err.Error())
+}
+
+func TestResync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	dataConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, dataConn)
+
+	_, err = dataConn.Exec(ctx, `
+drop table if exists resync_t;
+create table resync_t(id int primary key);
+insert into resync_t values (1);
+`).ReadAll()
+	require.NoError(t, err)
+	defer dataConn.Exec(context.Background(), "drop table resync_t").ReadAll()
+
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Temporary: true})
+	require.NoError(t, err)
+	require.NoError(t, pglogrepl.CheckReplicationSlot(ctx, dataConn, slotName))
+
+	var received []*pglogrepl.WalData
+	startLSN, err := pglogrepl.ResyncReplicationSlot(ctx, conn, dataConn, slotName, "test", pglogrepl.ResyncOptions{
+		OutputPlugin: outputPlugin,
+		Slot:         pglogrepl.CreateReplicationSlotOptions{Temporary: true},
+		Snapshot:     pglogrepl.SnapshotOptions{Tables: []string{"public.resync_t"}},
+	}, func(wd *pglogrepl.WalData) error {
+		received = append(received, wd)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, received, 3)
+	resync := received[0].Value.(*pglogrepl.ResyncWalData)
+	assert.Equal(t, slotName, resync.SlotName)
+	assert.Equal(t, "test", resync.Reason)
+	assert.Equal(t, pglogrepl.Insert, received[2].Type)
+	assert.NotZero(t, startLSN)
+}
@@ -0,0 +1,216 @@
+package pglogrepl
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// ReplicationSlot is a replication slot as listed by ListReplicationSlots.
+type ReplicationSlot struct {
+	SlotName string
+	// SlotType is physical or logical.
+	SlotType string
+	// Plugin is the output plugin of a logical slot.
+	Plugin string
+	// Database is the database of a logical slot.
+	Database  string
+	Temporary bool
+	Active    bool
+	// ActivePID is the process ID of the walsender using the slot, 0 if the slot is not active.
+	ActivePID         int32
+	RestartLSN        LSN
+	ConfirmedFlushLSN LSN
+	// WALStatus is reserved, extended, unreserved or lost. Empty before PostgreSQL 13.
+	WALStatus string
+	// SafeWALSize is the number of bytes that can be written to the WAL before the slot is in danger of losing
+	// required WAL. nil if max_slot_wal_keep_size is -1 or before PostgreSQL 13.
+	SafeWALSize *int64
+	// RetainedBytes is the amount of WAL kept for the slot, the distance from RestartLSN to the current position of
+	// the server.
+	RetainedBytes int64
+	// InvalidationReason is why the slot was invalidated, empty if it is valid. Before PostgreSQL 17 it is only
+	// known for slots with WALStatus lost, as wal_removed.
+	InvalidationReason string
+	// Conflicting tells that a logical slot on a standby was invalidated by a conflict with recovery. PostgreSQL 16+.
+	Conflicting bool
+}
+
+// Invalidated tells whether the slot can no longer be used to stream changes.
+func (s *ReplicationSlot) Invalidated() bool {
+	return s.InvalidationReason != "" || s.WALStatus == "lost" || s.Conflicting
+}
+
+// ReplicationStat is a walsender as listed by ListReplicationStats.
+type ReplicationStat struct {
+	PID             int32
+	UserName        string
+	ApplicationName string
+	ClientAddr      string
+	// State is the walsender state, e.g. startup, catchup or streaming.
+	State string
+	// SlotName is the slot the walsender streams from, empty if it does not use one.
+	SlotName  string
+	SentLSN   LSN
+	WriteLSN  LSN
+	FlushLSN  LSN
+	ReplayLSN LSN
+	// WriteLag, FlushLag and ReplayLag are the delays measured by the server, 0 if they are unknown.
+	WriteLag  time.Duration
+	FlushLag  time.Duration
+	ReplayLag time.Duration
+	SyncState string
+	// ReplyTime is the time of the last status update received from the client. PostgreSQL 12+.
+	ReplyTime time.Time
+}
+
+// ListReplicationSlots returns the replication slots of the server ordered by name. It needs a regular connection.
+func ListReplicationSlots(ctx context.Context, conn *pgconn.PgConn) ([]ReplicationSlot, error) {
+	// to_jsonb tolerates the columns missing from older versions.
+	sql := `SELECT slot_name, slot_type, coalesce(plugin, ''), coalesce(database::text, ''), temporary, active,
+	active_pid, restart_lsn, confirmed_flush_lsn, coalesce(to_jsonb(s)->>'wal_status', ''), to_jsonb(s)->>'safe_wal_size',
+	coalesce(to_jsonb(s)->>'invalidation_reason', ''), coalesce(to_jsonb(s)->>'conflicting', 'false'),
+	CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END
+FROM pg_replication_slots s ORDER BY slot_name`
+	result := conn.ExecParams(ctx, sql, nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to list replication slots: %w", result.Err)
+	}
+
+	slots := make([]ReplicationSlot, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		slot := ReplicationSlot{
+			SlotName:           string(row[0]),
+			SlotType:           string(row[1]),
+			Plugin:             string(row[2]),
+			Database:           string(row[3]),
+			Temporary:          textBool(row[4]),
+			Active:             textBool(row[5]),
+			WALStatus:          string(row[9]),
+			InvalidationReason: string(row[11]),
+			Conflicting:        textBool(row[12]),
+		}
+		var err error
+		if row[6] != nil {
+			pid, err := strconv.ParseInt(string(row[6]), 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("failed to parse active_pid of slot %s: %w", slot.SlotName, err)
+			}
+			slot.ActivePID = int32(pid)
+		}
+		if slot.RestartLSN, err = parseNullLSN(row[7]); err != nil {
+			return nil, err
+		}
+		if slot.ConfirmedFlushLSN, err = parseNullLSN(row[8]); err != nil {
+			return nil, err
+		}
+		if row[10] != nil {
+			size, err := strconv.ParseInt(string(row[10]), 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("failed to parse safe_wal_size of slot %s: %w", slot.SlotName, err)
+			}
+			slot.SafeWALSize = &size
+		}
+		current, err := parseNullLSN(row[13])
+		if err != nil {
+			return nil, err
+		}
+		if slot.RestartLSN != 0 && current > slot.RestartLSN {
+			slot.RetainedBytes = int64(current - slot.RestartLSN)
+		}
+		if slot.InvalidationReason == "" && slot.WALStatus == "lost" {
+			slot.InvalidationReason = "wal_removed"
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// GetReplicationSlot returns the replication slot with the given name.
+func GetReplicationSlot(ctx context.Context, conn *pgconn.PgConn, slotName string) (*ReplicationSlot, error) {
+	slots, err := ListReplicationSlots(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for i := range slots {
+		if slots[i].SlotName == slotName {
+			return &slots[i], nil
+		}
+	}
+	return nil, &ReplicationError{Kind: ErrSlotNotFound, Err: errors.Errorf("replication slot %s does not exist", slotName)}
+}
+
+// ListReplicationStats returns the walsenders of the server from pg_stat_replication ordered by PID. Walsenders of
+// other users are only fully visible to superusers and members of pg_read_all_stats. It needs a regular connection.
+func ListReplicationStats(ctx context.Context, conn *pgconn.PgConn) ([]ReplicationStat, error) {
+	sql := `SELECT r.pid, coalesce(r.usename::text, ''), coalesce(r.application_name, ''), coalesce(host(r.client_addr), ''),
+	coalesce(r.state, ''), coalesce(s.slot_name::text, ''), r.sent_lsn, r.write_lsn, r.flush_lsn, r.replay_lsn,
+	extract(epoch from r.write_lag), extract(epoch from r.flush_lag), extract(epoch from r.replay_lag),
+	coalesce(r.sync_state, ''), extract(epoch from (to_jsonb(r)->>'reply_time')::timestamptz)
+FROM pg_stat_replication r LEFT JOIN pg_replication_slots s ON s.active_pid = r.pid ORDER BY r.pid`
+	result := conn.ExecParams(ctx, sql, nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to list replication stats: %w", result.Err)
+	}
+
+	stats := make([]ReplicationStat, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		stat := ReplicationStat{
+			UserName:        string(row[1]),
+			ApplicationName: string(row[2]),
+			ClientAddr:      string(row[3]),
+			State:           string(row[4]),
+			SlotName:        string(row[5]),
+			SyncState:       string(row[13]),
+		}
+		pid, err := strconv.ParseInt(string(row[0]), 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("failed to parse pid: %w", err)
+		}
+		stat.PID = int32(pid)
+		for i, lsn := range []*LSN{&stat.SentLSN, &stat.WriteLSN, &stat.FlushLSN, &stat.ReplayLSN} {
+			if *lsn, err = parseNullLSN(row[6+i]); err != nil {
+				return nil, err
+			}
+		}
+		for i, lag := range []*time.Duration{&stat.WriteLag, &stat.FlushLag, &stat.ReplayLag} {
+			seconds, err := parseNullSeconds(row[10+i])
+			if err != nil {
+				return nil, err
+			}
+			*lag = time.Duration(seconds * float64(time.Second))
+		}
+		if row[14] != nil {
+			seconds, err := parseNullSeconds(row[14])
+			if err != nil {
+				return nil, err
+			}
+			stat.ReplyTime = time.Unix(0, int64(seconds*float64(time.Second)))
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// parseNullLSN parses an LSN in text format, 0 for NULL.
+func parseNullLSN(v []byte) (LSN, error) {
+	if v == nil {
+		return 0, nil
+	}
+	return ParseLSN(string(v))
+}
+
+// parseNullSeconds parses a number of seconds in text format, 0 for NULL.
+func parseNullSeconds(v []byte) (float64, error) {
+	if v == nil {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(string(v), 64)
+	if err != nil {
+		return 0, errors.Errorf("failed to parse seconds: %w", err)
+	}
+	return seconds, nil
+}
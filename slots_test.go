@@ -0,0 +1,50 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestListReplicationSlots(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	dataConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, dataConn)
+
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Temporary: true})
+	require.NoError(t, err)
+
+	slot, err := pglogrepl.GetReplicationSlot(ctx, dataConn, slotName)
+	require.NoError(t, err)
+	assert.Equal(t, "logical", slot.SlotType)
+	assert.Equal(t, outputPlugin, slot.Plugin)
+	assert.True(t, slot.Temporary)
+	assert.True(t, slot.Active)
+	assert.NotZero(t, slot.ActivePID)
+	assert.NotZero(t, slot.RestartLSN)
+	assert.False(t, slot.Invalidated())
+
+	_, err = pglogrepl.GetReplicationSlot(ctx, dataConn, "pglogrepl_missing")
+	assert.True(t, errors.Is(err, pglogrepl.ErrSlotNotFound))
+
+	_, err = pglogrepl.ListReplicationStats(ctx, dataConn)
+	require.NoError(t, err)
+}
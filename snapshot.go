@@ -0,0 +1,337 @@
+package pglogrepl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// SnapshotOptions selects the tables exported by ExportSnapshot.
+type SnapshotOptions struct {
+	// Publications exports every table of these publications.
+	Publications []string
+	// Tables exports these tables, given as schema.table, in addition to the published ones.
+	Tables []string
+}
+
+// SnapshotAndStream creates a logical replication slot on the replication connection replConn, exports the tables
+// selected by snapshotOptions from the slot's snapshot on the regular connection dataConn and starts replication at
+// the slot's consistent point. Every change committed before the consistent point is part of the snapshot and every
+// change committed after it is streamed, so nothing is missed or received twice.
+//
+// emit receives the snapshot as described for ExportSnapshot. The slot is created with EXPORT_SNAPSHOT, overriding
+// slotOptions.SnapshotAction. It returns the slot and the position replication started from.
+func SnapshotAndStream(
+	ctx context.Context,
+	replConn, dataConn *pgconn.PgConn,
+	slotName, outputPlugin string,
+	slotOptions CreateReplicationSlotOptions,
+	snapshotOptions SnapshotOptions,
+	startOptions StartReplicationOptions,
+	emit func(*WalData) error,
+) (CreateReplicationSlotResult, LSN, error) {
+	slotOptions.SnapshotAction = "EXPORT_SNAPSHOT"
+	slot, err := CreateReplicationSlot(ctx, replConn, slotName, outputPlugin, slotOptions)
+	if err != nil {
+		return slot, 0, errors.Errorf("failed to create replication slot: %w", err)
+	}
+	startLSN, err := ParseLSN(slot.ConsistentPoint)
+	if err != nil {
+		return slot, 0, errors.Errorf("bad consistent point %q: %w", slot.ConsistentPoint, err)
+	}
+
+	// The exported snapshot lives until the next command on replConn.
+	if err := ExportSnapshot(ctx, dataConn, slot.SnapshotName, snapshotOptions, emit); err != nil {
+		return slot, 0, err
+	}
+
+	if err := StartReplication(ctx, replConn, slotName, startLSN, startOptions); err != nil {
+		return slot, 0, errors.Errorf("failed to start replication: %w", err)
+	}
+	return slot, startLSN, nil
+}
+
+// ExportSnapshot reads the selected tables on the regular connection conn in a REPEATABLE READ transaction using
+// the exported snapshot snapshotName, e.g. the SnapshotName of a slot created with EXPORT_SNAPSHOT.
+//
+// For every table emit receives a Relation built from the catalog followed by an Insert with Snapshot set for every
+// row. Values are in text format like the changes of the stream. An error returned by emit aborts the export;
+// when it is returned during a COPY, pgconn closes conn.
+func ExportSnapshot(ctx context.Context, conn *pgconn.PgConn, snapshotName string, options SnapshotOptions, emit func(*WalData) error) error {
+	if err := BeginSnapshotTransaction(ctx, conn, snapshotName); err != nil {
+		return err
+	}
+
+	err := exportSnapshotTables(ctx, conn, options, emit)
+	if err != nil {
+		conn.Exec(ctx, "ROLLBACK").ReadAll()
+		return err
+	}
+	if _, err := conn.Exec(ctx, "COMMIT").ReadAll(); err != nil {
+		return errors.Errorf("failed to commit snapshot transaction: %w", err)
+	}
+	return nil
+}
+
+func exportSnapshotTables(ctx context.Context, conn *pgconn.PgConn, options SnapshotOptions, emit func(*WalData) error) error {
+	tables, err := SnapshotTables(ctx, conn, options)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		rel, err := CatalogRelation(ctx, conn, table)
+		if err != nil {
+			return err
+		}
+		if err := emit(&WalData{Type: Relation, Value: rel}); err != nil {
+			return err
+		}
+		sql := fmt.Sprintf("COPY %s (%s) TO STDOUT", quoteQualifiedName(table), columnList(rel))
+		if err := CopySnapshotRows(ctx, conn, sql, rel, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeginSnapshotTransaction starts a REPEATABLE READ transaction on conn that sees the exported snapshot.
+func BeginSnapshotTransaction(ctx context.Context, conn *pgconn.PgConn, snapshotName string) error {
+	sql := "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY; SET TRANSACTION SNAPSHOT " + quoteLiteral(snapshotName)
+	if _, err := conn.Exec(ctx, sql).ReadAll(); err != nil {
+		conn.Exec(ctx, "ROLLBACK").ReadAll()
+		return errors.Errorf("failed to import snapshot %s: %w", snapshotName, err)
+	}
+	return nil
+}
+
+// SnapshotTables returns the tables selected by options as schema.table without duplicates.
+func SnapshotTables(ctx context.Context, conn *pgconn.PgConn, options SnapshotOptions) ([]string, error) {
+	var tables []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+
+	for _, pub := range options.Publications {
+		sql := "SELECT schemaname, tablename FROM pg_publication_tables WHERE pubname = $1 ORDER BY 1, 2"
+		result := conn.ExecParams(ctx, sql, [][]byte{[]byte(pub)}, nil, nil, nil).Read()
+		if result.Err != nil {
+			return nil, errors.Errorf("failed to read tables of publication %s: %w", pub, result.Err)
+		}
+		for _, row := range result.Rows {
+			add(string(row[0]) + "." + string(row[1]))
+		}
+	}
+	for _, table := range options.Tables {
+		add(table)
+	}
+	return tables, nil
+}
+
+// CatalogRelation builds the relation of a table, given as schema.table, from the catalog like the Relation messages
+// of pgoutput: the ID is the table OID, the replica identity columns are flagged and generated columns are left out.
+func CatalogRelation(ctx context.Context, conn *pgconn.PgConn, table string) (*RelationWalData, error) {
+	sql := `SELECT c.oid, n.nspname, c.relname, c.relreplident
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.oid = $1::regclass`
+	result := conn.ExecParams(ctx, sql, [][]byte{[]byte(quoteQualifiedName(table))}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to read relation %s: %w", table, result.Err)
+	}
+	if len(result.Rows) != 1 {
+		return nil, errors.Errorf("relation %s not found", table)
+	}
+	row := result.Rows[0]
+	oid, err := strconv.ParseUint(string(row[0]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	rel := &RelationWalData{
+		ID:           int32(oid),
+		Namespace:    string(row[1]),
+		RelationName: string(row[2]),
+	}
+	if len(row[3]) == 1 {
+		rel.RelReplIdent = int8(row[3][0])
+	}
+
+	sql = `SELECT a.attname, a.atttypid, a.atttypmod,
+	c.relreplident = 'f' OR EXISTS (
+		SELECT 1 FROM pg_index i
+		WHERE i.indrelid = c.oid AND a.attnum = ANY(i.indkey)
+			AND CASE c.relreplident WHEN 'i' THEN i.indisreplident WHEN 'd' THEN i.indisprimary ELSE false END)
+FROM pg_attribute a JOIN pg_class c ON c.oid = a.attrelid
+WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+	AND coalesce(to_jsonb(a)->>'attgenerated', '') = ''
+ORDER BY a.attnum`
+	result = conn.ExecParams(ctx, sql, [][]byte{row[0]}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to read columns of %s: %w", table, result.Err)
+	}
+	for _, row := range result.Rows {
+		typeOID, err := strconv.Atoi(string(row[1]))
+		if err != nil {
+			return nil, err
+		}
+		modifier, err := strconv.ParseInt(string(row[2]), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ty, isArray := GetPgTypeById(typeOID)
+		rel.Columns = append(rel.Columns, RelationColumn{
+			Flag:     string(row[3]) == "t",
+			Name:     string(row[0]),
+			Type:     ty,
+			Modifier: int32(modifier),
+			IsArray:  isArray,
+		})
+	}
+	rel.ColumnsNum = int16(len(rel.Columns))
+	return rel, nil
+}
+
+// CopySnapshotRows executes a COPY ... TO STDOUT statement in text format whose columns are the columns of rel, and
+// emits every row as an Insert with Snapshot set.
+func CopySnapshotRows(ctx context.Context, conn *pgconn.PgConn, sql string, rel *RelationWalData, emit func(*WalData) error) error {
+	w := &copyRowWriter{rel: rel, emit: emit}
+	if _, err := conn.CopyTo(ctx, w, sql); err != nil {
+		if w.err != nil {
+			return w.err
+		}
+		return errors.Errorf("failed to copy %s: %w", rel.FullName(), err)
+	}
+	if len(w.buf) > 0 {
+		return errors.Errorf("incomplete COPY row for %s", rel.FullName())
+	}
+	return nil
+}
+
+// columnList returns the quoted column names of rel separated by commas.
+func columnList(rel *RelationWalData) string {
+	names := make([]string, len(rel.Columns))
+	for i, c := range rel.Columns {
+		names[i] = quoteIdentifier(c.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// copyRowWriter receives COPY text format data and emits the complete rows.
+type copyRowWriter struct {
+	rel  *RelationWalData
+	emit func(*WalData) error
+	buf  []byte
+	err  error
+}
+
+func (w *copyRowWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if w.err = w.row(line); w.err != nil {
+			return 0, w.err
+		}
+	}
+	// Compact the buffer so it does not keep the consumed rows alive.
+	w.buf = append([]byte(nil), w.buf...)
+	return len(p), nil
+}
+
+func (w *copyRowWriter) row(line []byte) error {
+	values, err := ParseCopyTextRow(line)
+	if err != nil {
+		return errors.Errorf("bad COPY row for %s: %w", w.rel.FullName(), err)
+	}
+	if len(values) != len(w.rel.Columns) {
+		return errors.Errorf("COPY row for %s has %d columns, expected %d", w.rel.FullName(), len(values), len(w.rel.Columns))
+	}
+
+	insert := &InsertWalData{RelationId: w.rel.ID, Relation: *w.rel, Snapshot: true}
+	insert.Tuples.Tuples = make([]Tuple, len(values))
+	for i, v := range values {
+		insert.Tuples.Tuples[i] = Tuple{RelCol: w.rel.Columns[i], Value: v, IsNull: v == nil}
+	}
+	return w.emit(&WalData{Type: Insert, Value: insert})
+}
+
+// ParseCopyTextRow splits a row of the COPY text format, without the line terminator, into its values. NULL (\N)
+// is returned as nil.
+func ParseCopyTextRow(line []byte) ([][]byte, error) {
+	var values [][]byte
+	for _, field := range bytes.Split(line, []byte{'\t'}) {
+		if len(field) == 2 && field[0] == '\\' && field[1] == 'N' {
+			values = append(values, nil)
+			continue
+		}
+		if bytes.IndexByte(field, '\\') < 0 {
+			values = append(values, field)
+			continue
+		}
+
+		value := make([]byte, 0, len(field))
+		for i := 0; i < len(field); i++ {
+			c := field[i]
+			if c != '\\' {
+				value = append(value, c)
+				continue
+			}
+			i++
+			if i == len(field) {
+				return nil, errors.New("trailing backslash")
+			}
+			switch c = field[i]; c {
+			case 'b':
+				value = append(value, '\b')
+			case 'f':
+				value = append(value, '\f')
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case 'v':
+				value = append(value, '\v')
+			case 'x':
+				n, v := 0, byte(0)
+				for ; n < 2 && i+1 < len(field) && isHexDigit(field[i+1]); n++ {
+					i++
+					d, _ := strconv.ParseUint(string(field[i]), 16, 8)
+					v = v<<4 | byte(d)
+				}
+				if n == 0 {
+					value = append(value, 'x')
+				} else {
+					value = append(value, v)
+				}
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := c - '0'
+				for n := 1; n < 3 && i+1 < len(field) && field[i+1] >= '0' && field[i+1] <= '7'; n++ {
+					i++
+					v = v<<3 | (field[i] - '0')
+				}
+				value = append(value, v)
+			default:
+				value = append(value, c)
+			}
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
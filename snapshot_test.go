@@ -0,0 +1,159 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestParseCopyTextRow(t *testing.T) {
+	values, err := pglogrepl.ParseCopyTextRow([]byte(`1	\N	a\tb\\c\nd	\101\x42\x4g	`))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), nil, []byte("a\tb\\c\nd"), []byte("AB\x04g"), []byte("")}, values)
+
+	_, err = pglogrepl.ParseCopyTextRow([]byte(`bad\`))
+	assert.Error(t, err)
+}
+
+func TestSnapshotAndStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	dataConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, dataConn)
+
+	_, err = dataConn.Exec(ctx, `
+drop table if exists snapshot_t;
+create table snapshot_t(id int primary key, name text);
+insert into snapshot_t values (1, 'foo'), (2, null);
+`).ReadAll()
+	require.NoError(t, err)
+	defer dataConn.Exec(context.Background(), "drop table snapshot_t").ReadAll()
+
+	var snapshot []*pglogrepl.WalData
+	_, startLSN, err := pglogrepl.SnapshotAndStream(ctx, conn, dataConn, slotName, outputPlugin,
+		pglogrepl.CreateReplicationSlotOptions{Temporary: true},
+		pglogrepl.SnapshotOptions{Tables: []string{"public.snapshot_t"}},
+		pglogrepl.StartReplicationOptions{},
+		func(wd *pglogrepl.WalData) error {
+			snapshot = append(snapshot, wd)
+			return nil
+		})
+	require.NoError(t, err)
+	assert.NotZero(t, startLSN)
+
+	require.Len(t, snapshot, 3)
+	rel := snapshot[0].Value.(*pglogrepl.RelationWalData)
+	assert.Equal(t, "public.snapshot_t", rel.FullName())
+	require.Len(t, rel.Columns, 2)
+	assert.True(t, rel.Columns[0].Flag)
+	assert.False(t, rel.Columns[1].Flag)
+
+	insert := snapshot[2].Value.(*pglogrepl.InsertWalData)
+	assert.True(t, insert.Snapshot)
+	assert.Equal(t, "2", string(insert.Tuples.Tuples[0].Value))
+	assert.True(t, insert.Tuples.Tuples[1].IsNull)
+}
+
+func TestExportSnapshotParallel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	connect := func(ctx context.Context) (*pgconn.PgConn, error) {
+		return pgconn.ConnectConfig(ctx, config)
+	}
+
+	dataConn, err := connect(ctx)
+	require.NoError(t, err)
+	defer closeConn(t, dataConn)
+	_, err = dataConn.Exec(ctx, `
+drop table if exists snapshot_keyed, snapshot_heap;
+create table snapshot_keyed(id int primary key, name text);
+insert into snapshot_keyed select i, 'row ' || i from generate_series(1, 10) i;
+create table snapshot_heap(name text);
+insert into snapshot_heap select 'row ' || i from generate_series(1, 10) i;
+`).ReadAll()
+	require.NoError(t, err)
+	defer dataConn.Exec(context.Background(), "drop table snapshot_keyed, snapshot_heap").ReadAll()
+
+	slot, err := pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin,
+		pglogrepl.CreateReplicationSlotOptions{Temporary: true, SnapshotAction: "EXPORT_SNAPSHOT"})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "pglogrepl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	options := pglogrepl.ParallelSnapshotOptions{
+		SnapshotOptions: pglogrepl.SnapshotOptions{Tables: []string{"public.snapshot_keyed", "public.snapshot_heap"}},
+		Connect:         connect,
+		Workers:         3,
+		ChunkSize:       3,
+		ProgressFile:    filepath.Join(dir, "progress.json"),
+	}
+	rows := make(map[string]int)
+	err = pglogrepl.ExportSnapshotParallel(ctx, slot.SnapshotName, options, func(wd *pglogrepl.WalData) error {
+		if insert, ok := wd.Value.(*pglogrepl.InsertWalData); ok {
+			rows[insert.Relation.FullName()]++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"public.snapshot_keyed": 10, "public.snapshot_heap": 10}, rows)
+
+	// Every chunk is finished, so resuming reads nothing.
+	rows = make(map[string]int)
+	err = pglogrepl.ExportSnapshotParallel(ctx, slot.SnapshotName, options, func(wd *pglogrepl.WalData) error {
+		if insert, ok := wd.Value.(*pglogrepl.InsertWalData); ok {
+			rows[insert.Relation.FullName()]++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	// Resuming after the first chunk of snapshot_keyed, ids 1 to 3, reads the other chunks only.
+	progress := fmt.Sprintf(`{"snapshot":%q,"done":["public.snapshot_keyed:0"]}`, slot.SnapshotName)
+	require.NoError(t, ioutil.WriteFile(options.ProgressFile, []byte(progress), 0644))
+	rows = make(map[string]int)
+	var ids []string
+	err = pglogrepl.ExportSnapshotParallel(ctx, slot.SnapshotName, options, func(wd *pglogrepl.WalData) error {
+		if insert, ok := wd.Value.(*pglogrepl.InsertWalData); ok {
+			rows[insert.Relation.FullName()]++
+			if insert.Relation.RelationName == "snapshot_keyed" {
+				ids = append(ids, string(insert.Tuples.Tuples[0].Value))
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"public.snapshot_keyed": 7, "public.snapshot_heap": 10}, rows)
+	assert.NotContains(t, ids, "1")
+	assert.NotContains(t, ids, "3")
+	assert.Contains(t, ids, "4")
+}
@@ -0,0 +1,363 @@
+package pglogrepl
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// TestDecodingParser is a streaming parser of the text output of the test_decoding plugin.
+// It returns the same WalData values as WalParser, so consumers do not depend on the plugin.
+//
+// test_decoding does not send relation OIDs, so the parser assigns relation IDs itself and
+// builds RelationWalData from the column names and types printed with every change. Key
+// flags of columns are learned from the old keys printed by UPDATE and DELETE.
+type TestDecodingParser struct {
+	relationCache
+}
+
+// NewTestDecodingParser ...
+func NewTestDecodingParser() *TestDecodingParser {
+	return &TestDecodingParser{relationCache: newRelationCache()}
+}
+
+// Parse takes one XLogData line of test_decoding and returns a WalData with the change.
+// Lines that are not transaction boundaries or table changes are returned as UndefinedWalData.
+func (p *TestDecodingParser) Parse(xlog XLogData) (*WalData, error) {
+	line := string(xlog.Data)
+
+	switch {
+	case line == "BEGIN" || strings.HasPrefix(line, "BEGIN "):
+		begin, err := p.parseBegin(line)
+		if err != nil {
+			return nil, err
+		}
+		return &WalData{Type: BeginWalType, Value: begin}, nil
+	case line == "COMMIT" || strings.HasPrefix(line, "COMMIT "):
+		commit, err := p.parseCommit(line, xlog.WALStart)
+		if err != nil {
+			return nil, err
+		}
+		return &WalData{Type: CommitWalType, Value: commit}, nil
+	case strings.HasPrefix(line, "table "):
+		return p.parseTableChange(line[len("table "):])
+	case strings.HasPrefix(line, "message: "):
+		message, err := p.parseMessage(line, xlog.WALStart)
+		if err != nil {
+			return nil, err
+		}
+		return &WalData{Type: Message, Value: message}, nil
+	}
+
+	wd, err := NewUndefinedWalData(xlog.Data)
+	return &WalData{Type: Undefined, Value: wd}, err
+}
+
+// Decode implements Decoder. Every test_decoding line decodes into exactly one WalData.
+func (p *TestDecodingParser) Decode(xlog XLogData) ([]*WalData, error) {
+	wd, err := p.Parse(xlog)
+	if err != nil {
+		return nil, err
+	}
+	return []*WalData{wd}, nil
+}
+
+// Reset implements Decoder. test_decoding has no per-connection state; relation IDs are kept
+// so that they stay stable across reconnects.
+func (p *TestDecodingParser) Reset() {}
+
+// parseMessage parses "message: transactional: 1 prefix: p, sz: 3 content:abc". The prefix may contain ", sz: ",
+// so the content is located by its size from the end of the line.
+func (p *TestDecodingParser) parseMessage(line string, lsn LSN) (*MessageWalData, error) {
+	rest := strings.TrimPrefix(line, "message: transactional: ")
+	if len(rest) == len(line) || len(rest) < 1 {
+		return nil, errors.Errorf("bad format for message: %q", line)
+	}
+	message := &MessageWalData{Transactional: rest[0] == '1', Lsn: lsn}
+	rest = strings.TrimPrefix(rest[1:], " prefix: ")
+
+	for i := strings.LastIndex(rest, ", sz: "); i >= 0; i = strings.LastIndex(rest[:i], ", sz: ") {
+		tail := rest[i+len(", sz: "):]
+		j := strings.Index(tail, " content:")
+		if j < 0 {
+			continue
+		}
+		size, err := strconv.Atoi(tail[:j])
+		if err != nil || size != len(tail)-j-len(" content:") {
+			continue
+		}
+		message.Prefix = rest[:i]
+		message.Content = []byte(tail[j+len(" content:"):])
+		return message, nil
+	}
+	return nil, errors.Errorf("bad format for message: %q", line)
+}
+
+// parseBegin parses "BEGIN" or "BEGIN 123" (include-xids). test_decoding does not send the final LSN.
+func (p *TestDecodingParser) parseBegin(line string) (*BeginWalData, error) {
+	begin := &BeginWalData{}
+	if rest := strings.TrimSpace(line[len("BEGIN"):]); rest != "" {
+		xid, err := strconv.ParseUint(rest, 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("bad format for BEGIN: %q", line)
+		}
+		begin.XID = int32(xid)
+	}
+	return begin, nil
+}
+
+// parseCommit parses "COMMIT", "COMMIT 123" (include-xids) and "COMMIT 123 (at 2020-01-01 00:00:00.000000+00)"
+// (include-timestamp).
+func (p *TestDecodingParser) parseCommit(line string, lsn LSN) (*CommitWalData, error) {
+	commit := &CommitWalData{LsnCommit: lsn, LsnTransaction: lsn}
+	rest := strings.TrimSpace(line[len("COMMIT"):])
+
+	if i := strings.Index(rest, "(at "); i >= 0 && strings.HasSuffix(rest, ")") {
+		ts, err := parseTextTimestamp(rest[i+len("(at ") : len(rest)-1])
+		if err != nil {
+			return nil, errors.Errorf("bad format for COMMIT: %q: %w", line, err)
+		}
+		commit.Timestamp = timeToPgTime(ts)
+		rest = strings.TrimSpace(rest[:i])
+	}
+
+	if rest != "" {
+		if _, err := strconv.ParseUint(rest, 10, 32); err != nil {
+			return nil, errors.Errorf("bad format for COMMIT: %q", line)
+		}
+	}
+	return commit, nil
+}
+
+var textTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07:00:00",
+}
+
+func parseTextTimestamp(s string) (time.Time, error) {
+	var err error
+	for _, layout := range textTimestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseTableChange parses the part of a change line after "table ", e.g.
+// "public.t: UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 name[text]:'x'".
+func (p *TestDecodingParser) parseTableChange(line string) (*WalData, error) {
+	var names []string
+	for {
+		name, rest, err := parseQualifiedName(line)
+		if err != nil {
+			return nil, errors.Errorf("bad format for table change: %w", err)
+		}
+		names = append(names, name)
+		line = rest
+		if !strings.HasPrefix(line, ", ") {
+			break
+		}
+		line = line[2:]
+	}
+
+	if !strings.HasPrefix(line, ": ") {
+		return nil, errors.Errorf("bad format for table change, expected ': ' after table name: %q", line)
+	}
+	line = line[2:]
+
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return nil, errors.Errorf("bad format for table change, missing action: %q", line)
+	}
+	action := line[:i]
+	line = strings.TrimPrefix(line[i+1:], " ")
+
+	if action == "TRUNCATE" {
+		truncate := &TruncateWalData{
+			IsCascade:         strings.Contains(line, "cascade"),
+			IsRestartIdentity: strings.Contains(line, "restart_seqs"),
+		}
+		for _, name := range names {
+			truncate.Relations = append(truncate.Relations, *p.relation(name, nil))
+		}
+		return &WalData{Type: Truncate, Value: truncate}, nil
+	}
+
+	if len(names) != 1 {
+		return nil, errors.Errorf("bad format for %s, expected one table, got %d", action, len(names))
+	}
+	name := names[0]
+
+	switch action {
+	case "INSERT":
+		var columns []textColumn
+		if line != "(no-tuple-data)" {
+			var err error
+			if columns, err = parseTestDecodingTuple(line); err != nil {
+				return nil, err
+			}
+		}
+		rel := p.relation(name, columns)
+		return &WalData{Type: Insert, Value: &InsertWalData{RelationId: rel.ID, Relation: *rel, Tuples: p.tupleData(name, columns)}}, nil
+
+	case "UPDATE":
+		var oldColumns []textColumn
+		if strings.HasPrefix(line, "old-key: ") {
+			i := strings.Index(line, " new-tuple: ")
+			if i < 0 {
+				return nil, errors.Errorf("bad format for UPDATE, missing new-tuple: %q", line)
+			}
+			var err error
+			if oldColumns, err = parseTestDecodingTuple(line[len("old-key: "):i]); err != nil {
+				return nil, err
+			}
+			line = line[i+1:]
+			p.learnKeys(name, oldColumns)
+		}
+		line = strings.TrimPrefix(line, "new-tuple: ")
+
+		var columns []textColumn
+		if line != "(no-tuple-data)" {
+			var err error
+			if columns, err = parseTestDecodingTuple(line); err != nil {
+				return nil, err
+			}
+		}
+		rel := p.relation(name, columns)
+		update := &UpdateWalData{RelationId: rel.ID, Relation: *rel, Tuples: p.tupleData(name, columns)}
+		if oldColumns != nil {
+			old := p.tupleData(name, oldColumns)
+			update.OldTuples = &old
+		}
+		return &WalData{Type: Update, Value: update}, nil
+
+	case "DELETE":
+		var columns []textColumn
+		if line != "(no-tuple-data)" {
+			var err error
+			if columns, err = parseTestDecodingTuple(line); err != nil {
+				return nil, err
+			}
+			p.learnKeys(name, columns)
+		}
+		rel := p.relation(name, nil)
+		return &WalData{Type: Delete, Value: &DeleteWalData{RelationId: rel.ID, Relation: *rel, Tuples: p.tupleData(name, columns)}}, nil
+	}
+
+	return nil, errors.Errorf("unknown test_decoding action %q", action)
+}
+
+// parseTestDecodingTuple parses a list of columns as printed by test_decoding's tuple_to_stringinfo:
+// name[type]:value separated by spaces.
+func parseTestDecodingTuple(s string) ([]textColumn, error) {
+	columns := []textColumn{}
+
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		var name string
+		var err error
+		if name, s, err = parseIdentifier(s, "["); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(s, "[") {
+			return nil, errors.Errorf("bad format for column %s, expected '['", name)
+		}
+		s = s[1:]
+
+		i := strings.Index(s, "]:")
+		if i < 0 {
+			return nil, errors.Errorf("bad format for column %s, expected ']:'", name)
+		}
+		typeName := s[:i]
+		s = s[i+2:]
+
+		var tuple Tuple
+		switch {
+		case strings.HasPrefix(s, "'"):
+			value, rest, err := parseQuoted(s, '\'')
+			if err != nil {
+				return nil, errors.Errorf("bad format for column %s: %w", name, err)
+			}
+			tuple.Value = []byte(value)
+			s = rest
+		default:
+			end := strings.Index(s, " ")
+			if end < 0 {
+				end = len(s)
+			}
+			raw := s[:end]
+			s = s[end:]
+
+			switch {
+			case raw == "null":
+				tuple.IsNull = true
+			case raw == "unchanged-toast-datum":
+				tuple.IsTOAST = true
+			case raw == "true":
+				tuple.Value = []byte("t")
+			case raw == "false":
+				tuple.Value = []byte("f")
+			case strings.HasPrefix(raw, "B'") && strings.HasSuffix(raw, "'"):
+				tuple.Value = []byte(raw[2 : len(raw)-1])
+			default:
+				tuple.Value = []byte(raw)
+			}
+		}
+
+		pgty, isArray := GetPgTypeByName(typeName)
+		col := RelationColumn{Name: name, Type: pgty, IsArray: isArray, Modifier: -1}
+		tuple.RelCol = col
+		columns = append(columns, textColumn{RelationColumn: col, Tuple: tuple})
+	}
+
+	return columns, nil
+}
+
+// parseQualifiedName parses a possibly quoted schema.table name at the start of s.
+func parseQualifiedName(s string) (string, string, error) {
+	schema, rest, err := parseIdentifier(s, ".:,")
+	if err != nil {
+		return "", s, err
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return schema, rest, nil
+	}
+	table, rest, err := parseIdentifier(rest[1:], ":,")
+	if err != nil {
+		return "", s, err
+	}
+	return schema + "." + table, rest, nil
+}
+
+// parseIdentifier parses an identifier that is either double quoted or ends before one of the terminators.
+func parseIdentifier(s string, terminators string) (string, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return parseQuoted(s, '"')
+	}
+	i := strings.IndexAny(s, terminators)
+	if i < 0 {
+		return "", s, errors.Errorf("unterminated identifier %q", s)
+	}
+	return s[:i], s[i:], nil
+}
+
+// parseQuoted parses a string enclosed in quote where a doubled quote stands for the quote itself.
+func parseQuoted(s string, quote byte) (string, string, error) {
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] == quote {
+			if i+1 < len(s) && s[i+1] == quote {
+				buf.WriteByte(quote)
+				i++
+				continue
+			}
+			return buf.String(), s[i+1:], nil
+		}
+		buf.WriteByte(s[i])
+	}
+	return "", s, errors.Errorf("unterminated quoted string %q", s)
+}
@@ -0,0 +1,92 @@
+package pglogrepl_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestTestDecodingParser(t *testing.T) {
+	p := pglogrepl.NewTestDecodingParser()
+	parse := func(lsn pglogrepl.LSN, line string) *pglogrepl.WalData {
+		wd, err := p.Parse(pglogrepl.XLogData{WALStart: lsn, Data: []byte(line)})
+		require.NoError(t, err, line)
+		return wd
+	}
+
+	wd := parse(0x100, "BEGIN 529")
+	require.Equal(t, pglogrepl.BeginWalType, wd.Type)
+	assert.Equal(t, int32(529), wd.Value.(*pglogrepl.BeginWalData).XID)
+	// The LSN of BEGIN is the first LSN of the transaction, not its final LSN.
+	assert.Zero(t, wd.Value.(*pglogrepl.BeginWalData).Lsn)
+
+	wd = parse(0x110, `table public."my ""t""": INSERT: id[integer]:1 name[character varying(20)]:'it''s' data[text]:null flag[boolean]:true tags[text[]]:'{a,b}'`)
+	require.Equal(t, pglogrepl.Insert, wd.Type)
+	insert := wd.Value.(*pglogrepl.InsertWalData)
+	assert.Equal(t, `my "t"`, insert.Relation.RelationName)
+	assert.Equal(t, "public", insert.Relation.Namespace)
+	require.Len(t, insert.Tuples.Tuples, 5)
+	assert.Equal(t, "integer", insert.Relation.Columns[0].Type.Alias)
+	assert.Equal(t, "it's", string(insert.Tuples.Tuples[1].Value))
+	assert.Equal(t, "varchar", insert.Tuples.Tuples[1].RelCol.Type.Typname)
+	assert.True(t, insert.Tuples.Tuples[2].IsNull)
+	assert.Equal(t, "t", string(insert.Tuples.Tuples[3].Value))
+	assert.True(t, insert.Tuples.Tuples[4].RelCol.IsArray)
+
+	wd = parse(0x120, `table public."my ""t""": UPDATE: old-key: id[integer]:1 new-tuple: id[integer]:2 name[character varying(20)]:'x' data[text]:unchanged-toast-datum flag[boolean]:false tags[text[]]:null`)
+	update := wd.Value.(*pglogrepl.UpdateWalData)
+	assert.Equal(t, insert.RelationId, update.RelationId)
+	require.NotNil(t, update.OldTuples)
+	assert.Equal(t, "1", string(update.OldTuples.Tuples[0].Value))
+	assert.True(t, update.Relation.Columns[0].Flag)
+	assert.False(t, update.Relation.Columns[1].Flag)
+	assert.True(t, update.Tuples.Tuples[2].IsTOAST)
+
+	wd = parse(0x130, "table public.other: DELETE: (no-tuple-data)")
+	del := wd.Value.(*pglogrepl.DeleteWalData)
+	assert.NotEqual(t, insert.RelationId, del.RelationId)
+	assert.Empty(t, del.Tuples.Tuples)
+
+	wd = parse(0x131, "table public.other: INSERT: (no-tuple-data)")
+	assert.Empty(t, wd.Value.(*pglogrepl.InsertWalData).Tuples.Tuples)
+	wd = parse(0x132, `table public."my ""t""": UPDATE: old-key: id[integer]:2 new-tuple: (no-tuple-data)`)
+	update = wd.Value.(*pglogrepl.UpdateWalData)
+	assert.Empty(t, update.Tuples.Tuples)
+	require.NotNil(t, update.OldTuples)
+	assert.Equal(t, "2", string(update.OldTuples.Tuples[0].Value))
+	wd = parse(0x133, "table public.other: UPDATE: (no-tuple-data)")
+	assert.Empty(t, wd.Value.(*pglogrepl.UpdateWalData).Tuples.Tuples)
+
+	wd = parse(0x140, `table public.other, public."my ""t""": TRUNCATE: restart_seqs cascade`)
+	truncate := wd.Value.(*pglogrepl.TruncateWalData)
+	require.Len(t, truncate.Relations, 2)
+	assert.True(t, truncate.IsCascade)
+	assert.True(t, truncate.IsRestartIdentity)
+
+	wd = parse(0x150, "COMMIT 529 (at 2019-08-22 20:04:51.123456-05)")
+	require.Equal(t, pglogrepl.CommitWalType, wd.Type)
+	commit := wd.Value.(*pglogrepl.CommitWalData)
+	assert.Equal(t, pglogrepl.LSN(0x150), commit.LsnCommit)
+	assert.True(t, commit.CommitTime().Equal(time.Date(2019, 8, 23, 1, 4, 51, 123456000, time.UTC)))
+
+	wd = parse(0x160, "message: transactional: 1 prefix: p, sz: 1 content:x")
+	require.Equal(t, pglogrepl.Message, wd.Type)
+	assert.Equal(t, &pglogrepl.MessageWalData{Transactional: true, Lsn: 0x160, Prefix: "p", Content: []byte("x")}, wd.Value)
+
+	wd = parse(0x170, "message: transactional: 0 prefix: a, sz: b, sz: 10 content:line\nbreak")
+	require.Equal(t, pglogrepl.Message, wd.Type)
+	message := wd.Value.(*pglogrepl.MessageWalData)
+	assert.False(t, message.Transactional)
+	assert.Equal(t, "a, sz: b", message.Prefix)
+	assert.Equal(t, "line\nbreak", string(message.Content))
+
+	wd = parse(0x180, "something else")
+	assert.Equal(t, pglogrepl.Undefined, wd.Type)
+
+	_, err := p.Parse(pglogrepl.XLogData{Data: []byte("table public.t: INSERT: id[integer:1")})
+	assert.Error(t, err)
+}
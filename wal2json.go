@@ -0,0 +1,377 @@
+package pglogrepl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	errors "golang.org/x/xerrors"
+)
+
+// Wal2JSONDecoder decodes the XLogData payloads of the wal2json output plugin into the same WalData values as
+// WalParser, so consumers do not depend on the plugin.
+//
+// FormatVersion 1 is wal2json's default: every XLogData carries a whole transaction as one JSON document, which
+// is decoded into a Begin, the changes and a Commit. Documents split by the write-in-chunks option are buffered
+// until they are complete. FormatVersion 2 sends one JSON object per transaction boundary or change.
+//
+// Like test_decoding, wal2json identifies tables by name, so relation IDs are assigned by the decoder. Column
+// types are taken from the type OIDs when include-type-oids is set and from the type names otherwise. Key flags
+// are learned from the old keys and, with include-pk, from the primary key.
+type Wal2JSONDecoder struct {
+	FormatVersion int
+	// MaxPending limits the size of a chunked document buffered until it is complete. 0 means 256 MiB.
+	MaxPending int
+
+	relationCache
+	pending []byte
+}
+
+// NewWal2JSONDecoder ...
+func NewWal2JSONDecoder(formatVersion int) *Wal2JSONDecoder {
+	return &Wal2JSONDecoder{FormatVersion: formatVersion, relationCache: newRelationCache()}
+}
+
+// Decode decodes one XLogData. It returns no WalData while a chunked transaction is incomplete.
+func (d *Wal2JSONDecoder) Decode(xlog XLogData) ([]*WalData, error) {
+	switch d.FormatVersion {
+	case 0, 1:
+		return d.decodeV1(xlog)
+	case 2:
+		wd, err := d.decodeV2(xlog)
+		if err != nil {
+			return nil, err
+		}
+		return []*WalData{wd}, nil
+	}
+	return nil, errors.Errorf("unsupported wal2json format-version %d", d.FormatVersion)
+}
+
+// Reset implements Decoder. It drops an incomplete chunked transaction; relation IDs are kept so that
+// they stay stable across reconnects.
+func (d *Wal2JSONDecoder) Reset() {
+	d.pending = nil
+}
+
+type wal2jsonV1Transaction struct {
+	XID       uint32             `json:"xid"`
+	NextLSN   string             `json:"nextlsn"`
+	Timestamp string             `json:"timestamp"`
+	Change    []wal2jsonV1Change `json:"change"`
+}
+
+type wal2jsonV1Change struct {
+	Kind           string        `json:"kind"`
+	Schema         string        `json:"schema"`
+	Table          string        `json:"table"`
+	ColumnNames    []string      `json:"columnnames"`
+	ColumnTypes    []string      `json:"columntypes"`
+	ColumnTypeOids []int         `json:"columntypeoids"`
+	ColumnValues   []interface{} `json:"columnvalues"`
+	Content        string        `json:"content"`
+	Transactional  bool          `json:"transactional"`
+	Prefix         string        `json:"prefix"`
+	OldKeys        *struct {
+		KeyNames    []string      `json:"keynames"`
+		KeyTypes    []string      `json:"keytypes"`
+		KeyTypeOids []int         `json:"keytypeoids"`
+		KeyValues   []interface{} `json:"keyvalues"`
+	} `json:"oldkeys"`
+	PK *struct {
+		PKNames []string `json:"pknames"`
+		PKTypes []string `json:"pktypes"`
+	} `json:"pk"`
+}
+
+func (d *Wal2JSONDecoder) decodeV1(xlog XLogData) ([]*WalData, error) {
+	data := xlog.Data
+	if len(d.pending) > 0 {
+		d.pending = append(d.pending, data...)
+		data = d.pending
+	}
+	if !json.Valid(data) {
+		// Only the prefix of a document is buffered; anything else would swallow the rest of the stream.
+		var doc json.RawMessage
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != io.ErrUnexpectedEOF && err != io.EOF {
+			d.pending = nil
+			return nil, errors.Errorf("bad wal2json document at %s: %q", xlog.WALStart, truncateForError(data))
+		}
+		maxPending := d.MaxPending
+		if maxPending <= 0 {
+			maxPending = 256 << 20
+		}
+		if len(data) > maxPending {
+			d.pending = nil
+			return nil, errors.Errorf("incomplete wal2json document exceeds %d bytes at %s", maxPending, xlog.WALStart)
+		}
+		if len(d.pending) == 0 {
+			d.pending = append([]byte(nil), data...)
+		}
+		return nil, nil
+	}
+	d.pending = nil
+
+	var tx wal2jsonV1Transaction
+	if err := unmarshalWal2JSON(data, &tx); err != nil {
+		return nil, err
+	}
+
+	begin := &BeginWalData{XID: int32(tx.XID)}
+	commit := &CommitWalData{LsnCommit: xlog.WALStart, LsnTransaction: xlog.WALStart}
+	if tx.Timestamp != "" {
+		ts, err := parseTextTimestamp(tx.Timestamp)
+		if err != nil {
+			return nil, errors.Errorf("bad wal2json timestamp %q: %w", tx.Timestamp, err)
+		}
+		begin.Timestamp = timeToPgTime(ts)
+		commit.Timestamp = begin.Timestamp
+	}
+	if tx.NextLSN != "" {
+		lsn, err := ParseLSN(tx.NextLSN)
+		if err != nil {
+			return nil, err
+		}
+		commit.LsnTransaction = lsn
+	}
+
+	result := []*WalData{{Type: BeginWalType, Value: begin}}
+	for _, change := range tx.Change {
+		wd, err := d.decodeV1Change(&change)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, wd)
+	}
+	return append(result, &WalData{Type: CommitWalType, Value: commit}), nil
+}
+
+func (d *Wal2JSONDecoder) decodeV1Change(change *wal2jsonV1Change) (*WalData, error) {
+	if change.Kind == "message" {
+		return &WalData{Type: Message, Value: &MessageWalData{
+			Transactional: change.Transactional,
+			Prefix:        change.Prefix,
+			Content:       []byte(change.Content),
+		}}, nil
+	}
+
+	name := change.Schema + "." + change.Table
+	if change.PK != nil {
+		pk, err := wal2jsonColumns(change.PK.PKNames, change.PK.PKTypes, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.learnKeys(name, pk)
+	}
+
+	var oldKeys []textColumn
+	if change.OldKeys != nil {
+		var err error
+		oldKeys, err = wal2jsonColumns(change.OldKeys.KeyNames, change.OldKeys.KeyTypes, change.OldKeys.KeyTypeOids, change.OldKeys.KeyValues)
+		if err != nil {
+			return nil, err
+		}
+		d.learnKeys(name, oldKeys)
+	}
+
+	var columns []textColumn
+	if change.ColumnNames != nil {
+		var err error
+		columns, err = wal2jsonColumns(change.ColumnNames, change.ColumnTypes, change.ColumnTypeOids, change.ColumnValues)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d.change(change.Kind, name, columns, oldKeys)
+}
+
+type wal2jsonV2Message struct {
+	Action    string           `json:"action"`
+	XID       uint32           `json:"xid"`
+	Timestamp string           `json:"timestamp"`
+	NextLSN   string           `json:"nextlsn"`
+	Schema    string           `json:"schema"`
+	Table     string           `json:"table"`
+	Columns   []wal2jsonColumn `json:"columns"`
+	Identity  []wal2jsonColumn `json:"identity"`
+	PK        []wal2jsonColumn `json:"pk"`
+
+	Transactional bool   `json:"transactional"`
+	Prefix        string `json:"prefix"`
+	Content       string `json:"content"`
+}
+
+type wal2jsonColumn struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	TypeOid int         `json:"typeoid"`
+	Value   interface{} `json:"value"`
+}
+
+func (d *Wal2JSONDecoder) decodeV2(xlog XLogData) (*WalData, error) {
+	var msg wal2jsonV2Message
+	if err := unmarshalWal2JSON(xlog.Data, &msg); err != nil {
+		return nil, err
+	}
+
+	var timestamp int64
+	if msg.Timestamp != "" {
+		ts, err := parseTextTimestamp(msg.Timestamp)
+		if err != nil {
+			return nil, errors.Errorf("bad wal2json timestamp %q: %w", msg.Timestamp, err)
+		}
+		timestamp = timeToPgTime(ts)
+	}
+
+	switch msg.Action {
+	case "B":
+		// wal2json does not send the final LSN, the message is written at the first LSN of the transaction.
+		return &WalData{Type: BeginWalType, Value: &BeginWalData{XID: int32(msg.XID), Timestamp: timestamp}}, nil
+	case "C":
+		commit := &CommitWalData{LsnCommit: xlog.WALStart, LsnTransaction: xlog.WALStart, Timestamp: timestamp}
+		if msg.NextLSN != "" {
+			lsn, err := ParseLSN(msg.NextLSN)
+			if err != nil {
+				return nil, err
+			}
+			commit.LsnTransaction = lsn
+		}
+		return &WalData{Type: CommitWalType, Value: commit}, nil
+	case "M":
+		message := &MessageWalData{Transactional: msg.Transactional, Lsn: xlog.WALStart, Prefix: msg.Prefix, Content: []byte(msg.Content)}
+		return &WalData{Type: Message, Value: message}, nil
+	}
+
+	name := msg.Schema + "." + msg.Table
+	if msg.PK != nil {
+		d.learnKeys(name, wal2jsonV2Columns(msg.PK, false))
+	}
+	var oldKeys []textColumn
+	if msg.Identity != nil {
+		oldKeys = wal2jsonV2Columns(msg.Identity, true)
+		d.learnKeys(name, oldKeys)
+	}
+	var columns []textColumn
+	if msg.Columns != nil {
+		columns = wal2jsonV2Columns(msg.Columns, true)
+	}
+
+	switch msg.Action {
+	case "I":
+		return d.change("insert", name, columns, oldKeys)
+	case "U":
+		return d.change("update", name, columns, oldKeys)
+	case "D":
+		return d.change("delete", name, columns, oldKeys)
+	case "T":
+		return d.change("truncate", name, nil, nil)
+	}
+
+	wd, err := NewUndefinedWalData(xlog.Data)
+	return &WalData{Type: Undefined, Value: wd}, err
+}
+
+// change builds the WalData of a change of the given wal2json kind.
+func (d *Wal2JSONDecoder) change(kind, name string, columns, oldKeys []textColumn) (*WalData, error) {
+	switch kind {
+	case "insert":
+		rel := d.relation(name, columns)
+		return &WalData{Type: Insert, Value: &InsertWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, columns)}}, nil
+	case "update":
+		rel := d.relation(name, columns)
+		update := &UpdateWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, columns)}
+		if oldKeys != nil {
+			old := d.tupleData(name, oldKeys)
+			update.OldTuples = &old
+		}
+		return &WalData{Type: Update, Value: update}, nil
+	case "delete":
+		rel := d.relation(name, nil)
+		return &WalData{Type: Delete, Value: &DeleteWalData{RelationId: rel.ID, Relation: *rel, Tuples: d.tupleData(name, oldKeys)}}, nil
+	case "truncate":
+		rel := d.relation(name, nil)
+		return &WalData{Type: Truncate, Value: &TruncateWalData{Relations: []RelationWalData{*rel}}}, nil
+	}
+	return nil, errors.Errorf("unknown wal2json change kind %q", kind)
+}
+
+func unmarshalWal2JSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return errors.Errorf("bad wal2json document: %w", err)
+	}
+	return nil
+}
+
+// wal2jsonColumns zips the parallel arrays of format-version 1. typeOids and values may be nil.
+func wal2jsonColumns(names, types []string, typeOids []int, values []interface{}) ([]textColumn, error) {
+	if len(types) != len(names) || (typeOids != nil && len(typeOids) != len(names)) || (values != nil && len(values) != len(names)) {
+		return nil, errors.Errorf("bad wal2json change: %d column names, %d types, %d type oids and %d values",
+			len(names), len(types), len(typeOids), len(values))
+	}
+
+	columns := make([]textColumn, 0, len(names))
+	for i, name := range names {
+		c := wal2jsonColumn{Name: name, Type: types[i]}
+		if typeOids != nil {
+			c.TypeOid = typeOids[i]
+		}
+		if values != nil {
+			c.Value = values[i]
+		}
+		columns = append(columns, c.textColumn(values != nil))
+	}
+	return columns, nil
+}
+
+func wal2jsonV2Columns(cols []wal2jsonColumn, hasValues bool) []textColumn {
+	columns := make([]textColumn, 0, len(cols))
+	for _, c := range cols {
+		columns = append(columns, c.textColumn(hasValues))
+	}
+	return columns
+}
+
+// textColumn converts the column to its PostgreSQL text representation: wal2json prints numbers and booleans
+// as JSON literals and everything else as strings.
+func (c *wal2jsonColumn) textColumn(hasValue bool) textColumn {
+	pgty, isArray := PgUnknownType, false
+	if c.TypeOid != 0 {
+		pgty, isArray = GetPgTypeById(c.TypeOid)
+	}
+	if pgty.Oid == PgUnknownType.Oid {
+		pgty, isArray = GetPgTypeByName(c.Type)
+	}
+
+	col := RelationColumn{Name: c.Name, Type: pgty, IsArray: isArray, Modifier: -1}
+	tuple := Tuple{RelCol: col}
+	if hasValue {
+		switch v := c.Value.(type) {
+		case nil:
+			tuple.IsNull = true
+		case bool:
+			if v {
+				tuple.Value = []byte("t")
+			} else {
+				tuple.Value = []byte("f")
+			}
+		case json.Number:
+			tuple.Value = []byte(v.String())
+		case string:
+			tuple.Value = []byte(v)
+		default:
+			b, _ := json.Marshal(v)
+			tuple.Value = b
+		}
+	}
+	return textColumn{RelationColumn: col, Tuple: tuple}
+}
+
+// truncateForError shortens data quoted in an error message.
+func truncateForError(data []byte) []byte {
+	if len(data) > 64 {
+		return data[:64]
+	}
+	return data
+}
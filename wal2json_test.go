@@ -0,0 +1,115 @@
+package pglogrepl_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestWal2JSONDecoderV1(t *testing.T) {
+	doc := `{"xid":530,"nextlsn":"0/16B2148","timestamp":"2019-12-29 04:58:34.806671+00","change":[` +
+		`{"kind":"insert","schema":"public","table":"t","columnnames":["id","name","ok","tags"],` +
+		`"columntypes":["integer","character varying(20)","boolean","text[]"],"columnvalues":[1,"it's",true,"{a,b}"],` +
+		`"pk":{"pknames":["id"],"pktypes":["integer"]}},` +
+		`{"kind":"update","schema":"public","table":"t","columnnames":["id","name","ok","tags"],` +
+		`"columntypes":["integer","character varying(20)","boolean","text[]"],"columnvalues":[2,null,false,null],` +
+		`"oldkeys":{"keynames":["id"],"keytypes":["integer"],"keyvalues":[1]}},` +
+		`{"kind":"delete","schema":"public","table":"t","oldkeys":{"keynames":["id"],"keytypes":["integer"],"keyvalues":[2]}}]}`
+
+	d := pglogrepl.NewWal2JSONDecoder(1)
+
+	// write-in-chunks splits the document over several messages.
+	wds, err := d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: []byte(doc[:40])})
+	require.NoError(t, err)
+	assert.Empty(t, wds)
+	wds, err = d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: []byte(doc[40:])})
+	require.NoError(t, err)
+	require.Len(t, wds, 5)
+
+	assert.Equal(t, int32(530), wds[0].Value.(*pglogrepl.BeginWalData).XID)
+	assert.Zero(t, wds[0].Value.(*pglogrepl.BeginWalData).Lsn)
+
+	insert := wds[1].Value.(*pglogrepl.InsertWalData)
+	assert.Equal(t, "public.t", insert.Relation.FullName())
+	assert.True(t, insert.Relation.Columns[0].Flag)
+	assert.Equal(t, "varchar", insert.Relation.Columns[1].Type.Typname)
+	assert.True(t, insert.Relation.Columns[3].IsArray)
+	assert.Equal(t, "1", string(insert.Tuples.Tuples[0].Value))
+	assert.Equal(t, "it's", string(insert.Tuples.Tuples[1].Value))
+	assert.Equal(t, "t", string(insert.Tuples.Tuples[2].Value))
+
+	update := wds[2].Value.(*pglogrepl.UpdateWalData)
+	assert.Equal(t, insert.RelationId, update.RelationId)
+	assert.True(t, update.Tuples.Tuples[1].IsNull)
+	require.NotNil(t, update.OldTuples)
+	assert.Equal(t, "1", string(update.OldTuples.Tuples[0].Value))
+
+	del := wds[3].Value.(*pglogrepl.DeleteWalData)
+	require.Len(t, del.Tuples.Tuples, 1)
+	assert.Equal(t, "2", string(del.Tuples.Tuples[0].Value))
+
+	commit := wds[4].Value.(*pglogrepl.CommitWalData)
+	assert.Equal(t, pglogrepl.LSN(0x16B2148), commit.LsnTransaction)
+	assert.Equal(t, 2019, commit.CommitTime().Year())
+}
+
+func TestWal2JSONDecoderV1Malformed(t *testing.T) {
+	d := pglogrepl.NewWal2JSONDecoder(1)
+
+	_, err := d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: []byte("ERROR: not json")})
+	assert.Error(t, err)
+	_, err = d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: []byte(`{"xid":1,"change":[]}}`)})
+	assert.Error(t, err)
+
+	// The next document is decoded normally.
+	wds, err := d.Decode(pglogrepl.XLogData{WALStart: 0x200, Data: []byte(`{"xid":2,"change":[]}`)})
+	require.NoError(t, err)
+	require.Len(t, wds, 2)
+	assert.Equal(t, int32(2), wds[0].Value.(*pglogrepl.BeginWalData).XID)
+
+	// A buffered prefix may not grow past MaxPending.
+	d.MaxPending = 16
+	wds, err = d.Decode(pglogrepl.XLogData{WALStart: 0x300, Data: []byte(`{"xid":3,`)})
+	require.NoError(t, err)
+	assert.Empty(t, wds)
+	_, err = d.Decode(pglogrepl.XLogData{WALStart: 0x300, Data: []byte(`"change":[`)})
+	assert.Error(t, err)
+}
+
+func TestWal2JSONDecoderV2(t *testing.T) {
+	d := pglogrepl.NewWal2JSONDecoder(2)
+	lines := []string{
+		`{"action":"B","xid":531,"timestamp":"2019-12-29 04:58:34.806671+00"}`,
+		`{"action":"I","schema":"public","table":"t","columns":[{"name":"id","type":"integer","typeoid":23,"value":1},` +
+			`{"name":"v","type":"numeric(10,2)","typeoid":1700,"value":1.50}],"pk":[{"name":"id","type":"integer","typeoid":23}]}`,
+		`{"action":"D","schema":"public","table":"t","identity":[{"name":"id","type":"integer","typeoid":23,"value":1}]}`,
+		`{"action":"T","schema":"public","table":"t"}`,
+		`{"action":"M","transactional":false,"prefix":"p","content":"x"}`,
+		`{"action":"C","nextlsn":"0/200"}`,
+	}
+
+	var types []pglogrepl.WalDataType
+	var wds []*pglogrepl.WalData
+	for _, line := range lines {
+		result, err := d.Decode(pglogrepl.XLogData{WALStart: 0x100, Data: []byte(line)})
+		require.NoError(t, err, line)
+		require.Len(t, result, 1)
+		types = append(types, result[0].Type)
+		wds = append(wds, result[0])
+	}
+	assert.Equal(t, []pglogrepl.WalDataType{pglogrepl.BeginWalType, pglogrepl.Insert, pglogrepl.Delete, pglogrepl.Truncate, pglogrepl.Message, pglogrepl.CommitWalType}, types)
+	assert.Equal(t, "p", wds[4].Value.(*pglogrepl.MessageWalData).Prefix)
+
+	insert := wds[1].Value.(*pglogrepl.InsertWalData)
+	assert.True(t, insert.Relation.Columns[0].Flag)
+	assert.Equal(t, "numeric", insert.Relation.Columns[1].Type.Typname)
+	assert.Equal(t, "1.50", string(insert.Tuples.Tuples[1].Value))
+	assert.Equal(t, "1", string(wds[2].Value.(*pglogrepl.DeleteWalData).Tuples.Tuples[0].Value))
+	assert.Equal(t, pglogrepl.LSN(0x200), wds[5].Value.(*pglogrepl.CommitWalData).LsnTransaction)
+
+	_, err := pglogrepl.NewWal2JSONDecoder(3).Decode(pglogrepl.XLogData{Data: []byte("{}")})
+	assert.Error(t, err)
+}
@@ -2,6 +2,13 @@ package pglogrepl
 
 import (
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	errors "golang.org/x/xerrors"
 )
 
 //
@@ -36,3 +43,156 @@ func toString(bs []byte) (s string, n int) {
 
 	return
 }
+
+// Decode converts the tuple value from PostgreSQL text format into a Go value according to the column type:
+// bool for bool, int64 for integer types, float64 for float4 and float8, []byte for bytea,
+// json.RawMessage for json and jsonb, []interface{} for arrays and string for everything else.
+// NULL is returned as nil. Unchanged TOASTed values have no value and return an error.
+func (t *Tuple) Decode() (interface{}, error) {
+	if t.IsNull {
+		return nil, nil
+	}
+	if t.IsTOAST {
+		return nil, errors.Errorf("column %s is an unchanged TOASTed value", t.RelCol.Name)
+	}
+
+	if t.RelCol.IsArray {
+		return decodeTextArray(t.RelCol.Type, string(t.Value))
+	}
+	return decodeTextValue(t.RelCol.Type, string(t.Value))
+}
+
+// Map returns the decoded values of the tuples by column name. Unchanged TOASTed values are left out.
+func (td *TupleData) Map() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(td.Tuples))
+	for i := range td.Tuples {
+		t := &td.Tuples[i]
+		if t.IsTOAST {
+			continue
+		}
+		v, err := t.Decode()
+		if err != nil {
+			return nil, err
+		}
+		m[t.RelCol.Name] = v
+	}
+	return m, nil
+}
+
+func decodeTextValue(ty PgType, s string) (interface{}, error) {
+	switch ty.Typname {
+	case "bool":
+		return s == "t" || s == "true", nil
+	case "int2", "int4", "int8", "oid", "xid", "cid":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("failed to parse %s value %q: %w", ty.Typname, s, err)
+		}
+		return n, nil
+	case "float4", "float8":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			// NaN and Infinity have no JSON representation, keep them as text.
+			return s, nil
+		}
+		return f, nil
+	case "bytea":
+		if !strings.HasPrefix(s, `\x`) {
+			return nil, errors.Errorf("unsupported bytea format %q", s)
+		}
+		bs, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, errors.Errorf("failed to parse bytea value: %w", err)
+		}
+		return bs, nil
+	case "json", "jsonb":
+		return json.RawMessage(s), nil
+	}
+
+	return s, nil
+}
+
+// decodeTextArray parses an array literal such as {1,2,NULL} or {{"a b",c},{d,e}} into nested []interface{}.
+func decodeTextArray(elemType PgType, s string) (interface{}, error) {
+	// Arrays with custom bounds are prefixed with their dimensions, e.g. [0:1]={1,2}.
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "="); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+
+	v, rest, err := parseTextArray(elemType, s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.Errorf("unexpected trailing data in array literal: %q", rest)
+	}
+	return v, nil
+}
+
+func parseTextArray(elemType PgType, s string) ([]interface{}, string, error) {
+	if s == "" || s[0] != '{' {
+		return nil, s, errors.Errorf("array literal must start with '{': %q", s)
+	}
+	s = s[1:]
+
+	elems := []interface{}{}
+	if strings.HasPrefix(s, "}") {
+		return elems, s[1:], nil
+	}
+
+	for {
+		var elem interface{}
+		var err error
+
+		switch {
+		case strings.HasPrefix(s, "{"):
+			elem, s, err = parseTextArray(elemType, s)
+			if err != nil {
+				return nil, s, err
+			}
+		case strings.HasPrefix(s, `"`):
+			var buf strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				buf.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, s, errors.New("unterminated quoted array element")
+			}
+			s = s[i+1:]
+			if elem, err = decodeTextValue(elemType, buf.String()); err != nil {
+				return nil, s, err
+			}
+		default:
+			i := strings.IndexAny(s, ",}")
+			if i < 0 {
+				return nil, s, errors.New("unterminated array literal")
+			}
+			raw := strings.TrimSpace(s[:i])
+			s = s[i:]
+			if raw != "NULL" {
+				if elem, err = decodeTextValue(elemType, raw); err != nil {
+					return nil, s, err
+				}
+			}
+		}
+		elems = append(elems, elem)
+
+		if s == "" {
+			return nil, s, errors.New("unterminated array literal")
+		}
+		// Only box uses ';' as element delimiter; ',' covers every other type.
+		if s[0] == '}' {
+			return elems, s[1:], nil
+		}
+		if s[0] != ',' && s[0] != ';' {
+			return nil, s, errors.Errorf("unexpected %q in array literal", s[0])
+		}
+		s = s[1:]
+	}
+}
@@ -0,0 +1,54 @@
+package pglogrepl_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestTupleDecode(t *testing.T) {
+	int4, _ := pglogrepl.GetPgTypeById(23)
+	text, _ := pglogrepl.GetPgTypeById(25)
+	boolean, _ := pglogrepl.GetPgTypeById(16)
+	float8, _ := pglogrepl.GetPgTypeById(701)
+	bytea, _ := pglogrepl.GetPgTypeById(17)
+	jsonb, _ := pglogrepl.GetPgTypeById(3802)
+
+	tests := []struct {
+		col      pglogrepl.RelationColumn
+		value    string
+		expected interface{}
+	}{
+		{pglogrepl.RelationColumn{Name: "i", Type: int4}, "42", int64(42)},
+		{pglogrepl.RelationColumn{Name: "t", Type: text}, "foo", "foo"},
+		{pglogrepl.RelationColumn{Name: "b", Type: boolean}, "t", true},
+		{pglogrepl.RelationColumn{Name: "f", Type: float8}, "1.5", 1.5},
+		{pglogrepl.RelationColumn{Name: "f", Type: float8}, "NaN", "NaN"},
+		{pglogrepl.RelationColumn{Name: "bs", Type: bytea}, `\x0102`, []byte{1, 2}},
+		{pglogrepl.RelationColumn{Name: "j", Type: jsonb}, `{"a": 1}`, json.RawMessage(`{"a": 1}`)},
+		{pglogrepl.RelationColumn{Name: "ia", Type: int4, IsArray: true}, "{1,NULL,3}", []interface{}{int64(1), nil, int64(3)}},
+		{pglogrepl.RelationColumn{Name: "ta", Type: text, IsArray: true}, `{{"a b","c\"d"},{e,""}}`,
+			[]interface{}{[]interface{}{"a b", `c"d`}, []interface{}{"e", ""}}},
+		{pglogrepl.RelationColumn{Name: "ea", Type: text, IsArray: true}, "{}", []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		tuple := pglogrepl.Tuple{RelCol: tt.col, Value: []byte(tt.value)}
+		v, err := tuple.Decode()
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.expected, v, tt.value)
+	}
+
+	td := pglogrepl.TupleData{Tuples: []pglogrepl.Tuple{
+		{RelCol: pglogrepl.RelationColumn{Name: "id", Type: int4}, Value: []byte("1")},
+		{RelCol: pglogrepl.RelationColumn{Name: "name", Type: text}, IsNull: true},
+		{RelCol: pglogrepl.RelationColumn{Name: "doc", Type: text}, IsTOAST: true},
+	}}
+	m, err := td.Map()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": nil}, m)
+}
@@ -3,6 +3,7 @@ package pglogrepl
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // WalDataType
@@ -17,6 +18,9 @@ const (
 	Update        WalDataType = 'U'
 	Delete        WalDataType = 'D'
 	Truncate      WalDataType = 'T'
+	Origin        WalDataType = 'O'
+	Message       WalDataType = 'M'
+	Resync        WalDataType = '!'
 	Undefined     WalDataType = '-'
 )
 
@@ -44,11 +48,17 @@ type WalData struct {
 //
 // BeginWalData corresponds the Begin command ('B')
 type BeginWalData struct {
+	// Lsn is the final LSN of the transaction, 0 if the plugin does not send it.
 	Lsn LSN
 	Timestamp int64
 	XID int32
 }
 
+// CommitTime returns the commit timestamp of the transaction.
+func (wd *BeginWalData) CommitTime() time.Time {
+	return pgTimeToTime(wd.Timestamp)
+}
+
 func (wd *BeginWalData) String() string {
 	return fmt.Sprintf("BEGIN %d [TS: %d, LSN: %s]", wd.XID, wd.Timestamp, wd.Lsn.String())
 }
@@ -104,6 +114,11 @@ type CommitWalData struct {
 	Timestamp int64
 }
 
+// CommitTime returns the commit timestamp of the transaction.
+func (wd *CommitWalData) CommitTime() time.Time {
+	return pgTimeToTime(wd.Timestamp)
+}
+
 func (wd *CommitWalData) String() string {
 	return fmt.Sprintf("COMMIT %s", wd.LsnCommit.String())
 }
@@ -114,18 +129,44 @@ type InsertWalData struct {
 	RelationId int32
 	Relation RelationWalData
 	Tuples     TupleData
+	Snapshot   bool // the row was read from a snapshot, see ExportSnapshot
 }
 
 func (wd *InsertWalData) String() string {
 	return "INSERT: " + wd.Relation.FullName() + " " + wd.Tuples.String()
 }
 
+//
+// Origin is sent after Begin for transactions that were replayed into the source with a replication origin.
+type OriginWalData struct {
+	Lsn  LSN // commit LSN of the transaction on the origin server
+	Name string
+}
+
+func (wd *OriginWalData) String() string {
+	return fmt.Sprintf("ORIGIN %s [LSN: %s]", wd.Name, wd.Lsn.String())
+}
+
+//
+// Message is a logical decoding message written with pg_logical_emit_message.
+type MessageWalData struct {
+	Transactional bool
+	Lsn           LSN // LSN of the message, when the plugin sends it
+	Prefix        string
+	Content       []byte
+}
+
+func (wd *MessageWalData) String() string {
+	return fmt.Sprintf("MESSAGE %s [transactional: %t, LSN: %s] %q", wd.Prefix, wd.Transactional, wd.Lsn.String(), wd.Content)
+}
+
 //
 // Update
 type UpdateWalData struct {
 	RelationId int32
 	Relation RelationWalData
 	Tuples TupleData
+	OldTuples *TupleData // old key or old row, if the change carries it
 }
 
 func (wd *UpdateWalData) String() string {
@@ -199,6 +240,12 @@ func (t *Tuple) String() string {
 
 // NewTupleData ...
 func NewTupleData(bs []byte, rel RelationWalData) (*TupleData, error) {
+	td, _, err := parseTupleData(bs, rel)
+	return td, err
+}
+
+// parseTupleData parses a TupleData message part and returns it with the number of bytes it took.
+func parseTupleData(bs []byte, rel RelationWalData) (*TupleData, int, error) {
 	offset := 0
 	td := &TupleData{}
 
@@ -208,7 +255,7 @@ func NewTupleData(bs []byte, rel RelationWalData) (*TupleData, error) {
 	td.Tuples = make([]Tuple, 0, n)
 
 	if n < rel.ColumnsNum {
-		return nil, fmt.Errorf("mismatch schema with data. Expected %d columns, but got %d", n, rel.ColumnsNum)
+		return nil, 0, fmt.Errorf("mismatch schema with data. Expected %d columns, but got %d", n, rel.ColumnsNum)
 	}
 
 	for i := int16(0); i < n; i++ {
@@ -244,13 +291,13 @@ func NewTupleData(bs []byte, rel RelationWalData) (*TupleData, error) {
 			}
 			break
 		default:
-			return nil, fmt.Errorf("bad TupleData format, expected 'n', 'u' or 't' flag")
+			return nil, 0, fmt.Errorf("bad TupleData format, expected 'n', 'u' or 't' flag")
 		}
 
 		td.Tuples = append(td.Tuples, *tuple)
 	}
 
-	return td, nil
+	return td, offset, nil
 }
 
 func (td *TupleData) String() string {
@@ -262,6 +309,19 @@ func (td *TupleData) String() string {
 	return builder.String()
 }
 
+//
+// ResyncWalData announces that the slot was recreated and the tables are exported again. Changes between the last
+// one received and the new snapshot are lost, so downstream should reconcile its copy with the rows that follow.
+// Pseudo-code '!'.
+type ResyncWalData struct {
+	SlotName string
+	Reason   string
+}
+
+func (wd *ResyncWalData) String() string {
+	return fmt.Sprintf("RESYNC %s [reason: %s]", wd.SlotName, wd.Reason)
+}
+
 //
 // UndefinedWalData presents an unrecognised command. Pseudo-code '-'.
 type UndefinedWalData struct {
@@ -18,8 +18,9 @@ func NewWalParser() WalParser {
 
 // Parse takes row XLogData and returns instance of WalData with value and data type
 // Value are represented in Postgres' text format.
+// Type is the pgoutput message type of xlog, Undefined for the messages the parser does not know.
 func (p *WalParser) Parse(xlog XLogData) (*WalData, error) {
-	ty := Undefined
+	ty := WalDataType(xlog.Data[0])
 	payload := xlog.Data[1:]
 	var wd Wal = nil
 	var err error
@@ -43,20 +44,53 @@ func (p *WalParser) Parse(xlog XLogData) (*WalData, error) {
 	case Truncate:
 		wd, err = p.parseTruncateWalData(payload)
 		break
+	case Origin:
+		wd, err = p.parseOriginWalData(payload)
+		break
+	case Message:
+		wd, err = p.parseMessageWalData(payload)
+		break
 	case Relation:
-		relation, err := p.parseRelationWalData(payload)
+		var relation *RelationWalData
+		relation, err = p.parseRelationWalData(payload)
 		if err == nil {
 			p.relations[relation.ID] = *relation
 			wd = relation
 		}
 		break
 	default:
+		ty = Undefined
 		wd, err = NewUndefinedWalData(xlog.Data)
 	}
 
 	return &WalData{Type: ty, Value: wd}, err
 }
 
+// Decode implements Decoder. Every pgoutput message decodes into exactly one WalData.
+func (p *WalParser) Decode(xlog XLogData) ([]*WalData, error) {
+	wd, err := p.Parse(xlog)
+	if err != nil {
+		return nil, err
+	}
+	return []*WalData{wd}, nil
+}
+
+// Reset implements Decoder. pgoutput sends the Relation messages again on every START_REPLICATION,
+// so the cached relations are dropped.
+func (p *WalParser) Reset() {
+	p.relations = make(map[int32]RelationWalData)
+	p.lastRelation = nil
+}
+
+// Relations returns the relations received so far ordered by ID.
+func (p *WalParser) Relations() []RelationWalData {
+	relations := make([]RelationWalData, 0, len(p.relations))
+	for _, rel := range p.relations {
+		relations = append(relations, rel)
+	}
+	return sortedRelations(relations)
+}
+
 func (p *WalParser) parseBeginWalData(data []byte) (*BeginWalData, error) {
 	lsn := LSN(toInt64(data[:sizeOfInt64]))
 	offset := sizeOfInt64
@@ -124,6 +158,47 @@ func (p *WalParser) parseRelationWalData(data []byte) (*RelationWalData, error)
 	}, nil
 }
 
+func (p *WalParser) parseOriginWalData(data []byte) (*OriginWalData, error) {
+	if len(data) < sizeOfInt64+1 {
+		return nil, fmt.Errorf("bad format for Origin, message is too short")
+	}
+	lsn := toInt64(data[:sizeOfInt64])
+	name, _ := toString(data[sizeOfInt64:])
+	return &OriginWalData{Lsn: LSN(lsn), Name: name}, nil
+}
+
+func (p *WalParser) parseMessageWalData(data []byte) (*MessageWalData, error) {
+	if len(data) < sizeOfInt8+sizeOfInt64 {
+		return nil, fmt.Errorf("bad format for Message, message is too short")
+	}
+	offset := 0
+
+	flags := toInt8(data[offset : offset+sizeOfInt8])
+	offset += sizeOfInt8
+
+	lsn := toInt64(data[offset : offset+sizeOfInt64])
+	offset += sizeOfInt64
+
+	prefix, n := toString(data[offset:])
+	offset += n
+
+	if len(data) < offset+sizeOfInt32 {
+		return nil, fmt.Errorf("bad format for Message, message is too short")
+	}
+	size := int(toInt32(data[offset : offset+sizeOfInt32]))
+	offset += sizeOfInt32
+	if size < 0 || len(data) < offset+size {
+		return nil, fmt.Errorf("bad format for Message, content is too short")
+	}
+
+	return &MessageWalData{
+		Transactional: flags&1 != 0,
+		Lsn:           LSN(lsn),
+		Prefix:        prefix,
+		Content:       append([]byte(nil), data[offset:offset+size]...),
+	}, nil
+}
+
 func (p *WalParser) parseCommitWalData(data []byte) (*CommitWalData, error) {
 	offset := 0
 
@@ -192,10 +267,20 @@ func (p *WalParser) parseUpdateWalData(data []byte) (*UpdateWalData, error) {
 	update.Relation = rel
 
 	switch ident {
-	case 'K':
-		return nil, fmt.Errorf("flag 'K' for Update is unsupported")
-	case 'O':
-		return nil, fmt.Errorf("flag 'O' for Update is unsupported")
+	case 'K', 'O':
+		// The old key ('K') or old row ('O') precedes the new row.
+		old, n, err := parseTupleData(data[offset:], rel)
+		if err != nil {
+			return nil, err
+		}
+		update.OldTuples = old
+		offset += n
+
+		if len(data) <= offset || data[offset] != 'N' {
+			return nil, fmt.Errorf("bad format for Update, expected 'N' flag after the old tuple")
+		}
+		offset += sizeOfByte
+		fallthrough
 	case 'N':
 		td, err := NewTupleData(data[offset:], rel)
 		if err != nil {
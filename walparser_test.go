@@ -0,0 +1,28 @@
+package pglogrepl_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestWalParserType(t *testing.T) {
+	parser := pglogrepl.NewWalParser()
+
+	begin := make([]byte, 21)
+	begin[0] = 'B'
+	binary.BigEndian.PutUint64(begin[1:], 0x100)
+	binary.BigEndian.PutUint32(begin[17:], 42)
+	wd, err := parser.Parse(pglogrepl.XLogData{Data: begin})
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.BeginWalType, wd.Type)
+	assert.Equal(t, int32(42), wd.Value.(*pglogrepl.BeginWalData).XID)
+
+	wd, err = parser.Parse(pglogrepl.XLogData{Data: []byte("Z")})
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.Undefined, wd.Type)
+}
@@ -0,0 +1,171 @@
+package pglogrepl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WalStatsEntry accumulates the size of a group of WAL records.
+type WalStatsEntry struct {
+	Count       uint64
+	RecordBytes uint64 // record bytes excluding full-page images
+	FPIBytes    uint64
+}
+
+// CombinedBytes returns the total size of the records including full-page images.
+func (e WalStatsEntry) CombinedBytes() uint64 {
+	return e.RecordBytes + e.FPIBytes
+}
+
+func (e *WalStatsEntry) add(rec *XLogRecord) {
+	fpi := uint64(rec.FPILen())
+	e.Count++
+	e.RecordBytes += uint64(rec.TotalLen) - fpi
+	e.FPIBytes += fpi
+}
+
+// WalStatsRecordKey identifies a record type of a resource manager.
+type WalStatsRecordKey struct {
+	Rmgr       RmgrID
+	RecordType uint8
+}
+
+// String returns the name of the record type as printed by pg_waldump --stats=record.
+func (k WalStatsRecordKey) String() string {
+	return k.Rmgr.String() + "/" + k.Rmgr.RecordTypeName(k.RecordType<<4)
+}
+
+// WalStats aggregates decoded WAL records per resource manager and per record type like pg_waldump --stats.
+// Records outside of [StartLSN, EndLSN) are ignored; a zero EndLSN means no upper bound.
+type WalStats struct {
+	StartLSN LSN
+	EndLSN   LSN
+
+	FirstLSN LSN // LSN of the first record counted
+	LastLSN  LSN // end LSN of the last record counted
+
+	Total   WalStatsEntry
+	Rmgrs   map[RmgrID]*WalStatsEntry
+	Records map[WalStatsRecordKey]*WalStatsEntry
+}
+
+// NewWalStats creates WalStats for the given LSN range.
+func NewWalStats(startLSN, endLSN LSN) *WalStats {
+	return &WalStats{
+		StartLSN: startLSN,
+		EndLSN:   endLSN,
+		Rmgrs:    make(map[RmgrID]*WalStatsEntry),
+		Records:  make(map[WalStatsRecordKey]*WalStatsEntry),
+	}
+}
+
+// Add accounts rec and reports whether it was within the LSN range.
+func (s *WalStats) Add(rec *XLogRecord) bool {
+	if rec.LSN < s.StartLSN || (s.EndLSN != 0 && rec.LSN >= s.EndLSN) {
+		return false
+	}
+
+	if s.Total.Count == 0 {
+		s.FirstLSN = rec.LSN
+	}
+	s.LastLSN = rec.EndLSN
+	s.Total.add(rec)
+
+	rmgr, ok := s.Rmgrs[rec.Rmgr]
+	if !ok {
+		rmgr = &WalStatsEntry{}
+		s.Rmgrs[rec.Rmgr] = rmgr
+	}
+	rmgr.add(rec)
+
+	key := WalStatsRecordKey{Rmgr: rec.Rmgr, RecordType: rec.RecordType()}
+	record, ok := s.Records[key]
+	if !ok {
+		record = &WalStatsEntry{}
+		s.Records[key] = record
+	}
+	record.add(rec)
+
+	return true
+}
+
+// WriteReport writes a pg_waldump style statistics table to w. If perRecord is true the table
+// has a row per record type, otherwise a row per resource manager.
+func (s *WalStats) WriteReport(w io.Writer, perRecord bool) error {
+	type row struct {
+		name  string
+		entry WalStatsEntry
+	}
+
+	var rows []row
+	if perRecord {
+		keys := make([]WalStatsRecordKey, 0, len(s.Records))
+		for k := range s.Records {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Rmgr != keys[j].Rmgr {
+				return keys[i].Rmgr < keys[j].Rmgr
+			}
+			return keys[i].RecordType < keys[j].RecordType
+		})
+		for _, k := range keys {
+			rows = append(rows, row{name: k.String(), entry: *s.Records[k]})
+		}
+	} else {
+		ids := make([]int, 0, len(s.Rmgrs))
+		for id := range s.Rmgrs {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			rows = append(rows, row{name: RmgrID(id).String(), entry: *s.Rmgrs[RmgrID(id)]})
+		}
+	}
+
+	pct := func(n, total uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(total)
+	}
+
+	if _, err := fmt.Fprintf(w, "WAL statistics between %s and %s:\n", s.FirstLSN, s.LastLSN); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-32s %10s %8s %20s %8s %20s %8s %20s %8s\n",
+		"Type", "N", "(%)", "Record size", "(%)", "FPI size", "(%)", "Combined size", "(%)"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-32s %10s %8s %20s %8s %20s %8s %20s %8s\n",
+		"----", "-", "---", "-----------", "---", "--------", "---", "-------------", "---"); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		e := r.entry
+		_, err := fmt.Fprintf(w, "%-32s %10d (%6.2f) %20d (%6.2f) %20d (%6.2f) %20d (%6.2f)\n",
+			r.name,
+			e.Count, pct(e.Count, s.Total.Count),
+			e.RecordBytes, pct(e.RecordBytes, s.Total.RecordBytes),
+			e.FPIBytes, pct(e.FPIBytes, s.Total.FPIBytes),
+			e.CombinedBytes(), pct(e.CombinedBytes(), s.Total.CombinedBytes()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%-32s %10s %8s %20s %8s %20s %8s %20s\n",
+		"", "--------", "", "--------", "", "--------", "", "--------"); err != nil {
+		return err
+	}
+
+	fpiPct := pct(s.Total.FPIBytes, s.Total.CombinedBytes())
+	_, err := fmt.Fprintf(w, "%-32s %10d %29s %-8s %20s %-8s %20d\n",
+		"Total", s.Total.Count,
+		fmt.Sprintf("%d", s.Total.RecordBytes), fmt.Sprintf("[%.2f%%]", 100-fpiPct),
+		fmt.Sprintf("%d", s.Total.FPIBytes), fmt.Sprintf("[%.2f%%]", fpiPct),
+		s.Total.CombinedBytes())
+	return err
+}
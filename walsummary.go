@@ -0,0 +1,586 @@
+package pglogrepl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// InvalidBlockNumber means that no limit block is set for a relation fork.
+const InvalidBlockNumber = 0xFFFFFFFF
+
+const (
+	walSummaryMagic   = "PGLRWSUM"
+	walSummaryVersion = 1
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RelFork identifies a fork of a relation.
+type RelFork struct {
+	Locator RelFileLocator
+	Fork    ForkNumber
+}
+
+func (rf RelFork) String() string {
+	return fmt.Sprintf("%s/%s", rf.Locator, rf.Fork)
+}
+
+type walSummaryEntry struct {
+	limitBlock uint32
+	blocks     map[uint32]struct{}
+}
+
+// WalSummary is the set of relation blocks changed by the WAL in [StartLSN, EndLSN), similar to
+// the WAL summaries of PostgreSQL 17.
+//
+// Besides modified blocks, a relation fork may have a limit block: the fork was created, truncated
+// or dropped within the range, so every block at or beyond the limit block must be considered changed.
+// A limit block 0 of the main fork of relation number 0 covers every relation of the database in the
+// tablespace, which was created or dropped within the range.
+type WalSummary struct {
+	Timeline uint32
+	StartLSN LSN
+	EndLSN   LSN
+
+	entries map[RelFork]*walSummaryEntry
+}
+
+// NewWalSummary creates an empty summary.
+func NewWalSummary(timeline uint32, startLSN, endLSN LSN) *WalSummary {
+	return &WalSummary{
+		Timeline: timeline,
+		StartLSN: startLSN,
+		EndLSN:   endLSN,
+		entries:  make(map[RelFork]*walSummaryEntry),
+	}
+}
+
+func (s *WalSummary) entry(rf RelFork) *walSummaryEntry {
+	e, ok := s.entries[rf]
+	if !ok {
+		e = &walSummaryEntry{limitBlock: InvalidBlockNumber, blocks: make(map[uint32]struct{})}
+		s.entries[rf] = e
+	}
+	return e
+}
+
+// MarkBlockModified records that block of rf changed.
+func (s *WalSummary) MarkBlockModified(rf RelFork, block uint32) {
+	e := s.entry(rf)
+	if block < e.limitBlock {
+		e.blocks[block] = struct{}{}
+	}
+}
+
+// SetLimitBlock lowers the limit block of rf to block. Modified blocks at or beyond the limit
+// are implied by it and dropped.
+func (s *WalSummary) SetLimitBlock(rf RelFork, block uint32) {
+	e := s.entry(rf)
+	if block >= e.limitBlock {
+		return
+	}
+	e.limitBlock = block
+	for b := range e.blocks {
+		if b >= block {
+			delete(e.blocks, b)
+		}
+	}
+}
+
+// RelForks returns the relation forks in the summary in storage order.
+func (s *WalSummary) RelForks() []RelFork {
+	rfs := make([]RelFork, 0, len(s.entries))
+	for rf := range s.entries {
+		rfs = append(rfs, rf)
+	}
+	sort.Slice(rfs, func(i, j int) bool {
+		return relForkLess(rfs[i], rfs[j])
+	})
+	return rfs
+}
+
+// Blocks returns the modified blocks of rf in ascending order and the limit block of rf,
+// which is InvalidBlockNumber if none was set. It is 0 if the database of rf was created or dropped.
+func (s *WalSummary) Blocks(rf RelFork) ([]uint32, uint32) {
+	database := RelFork{Locator: RelFileLocator{SpcOid: rf.Locator.SpcOid, DbOid: rf.Locator.DbOid}, Fork: MainForkNum}
+	if e, ok := s.entries[database]; ok && e.limitBlock == 0 {
+		return nil, 0
+	}
+	e, ok := s.entries[rf]
+	if !ok {
+		return nil, InvalidBlockNumber
+	}
+	blocks := make([]uint32, 0, len(e.blocks))
+	for b := range e.blocks {
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks, e.limitBlock
+}
+
+// ChangedBlockCount returns the number of modified blocks, not counting blocks implied by limit blocks.
+func (s *WalSummary) ChangedBlockCount() int {
+	n := 0
+	for _, e := range s.entries {
+		n += len(e.blocks)
+	}
+	return n
+}
+
+// ChangedBytes estimates the amount of relation data an incremental copy of the range has to transfer.
+func (s *WalSummary) ChangedBytes() int64 {
+	return int64(s.ChangedBlockCount()) * xlogBlockSize
+}
+
+// Merge adds the changes of other to s and extends the LSN range of s to cover it.
+func (s *WalSummary) Merge(other *WalSummary) {
+	if other.StartLSN < s.StartLSN {
+		s.StartLSN = other.StartLSN
+	}
+	if other.EndLSN > s.EndLSN {
+		s.EndLSN = other.EndLSN
+	}
+	for rf, oe := range other.entries {
+		for b := range oe.blocks {
+			s.MarkBlockModified(rf, b)
+		}
+		if oe.limitBlock != InvalidBlockNumber {
+			s.SetLimitBlock(rf, oe.limitBlock)
+		}
+	}
+}
+
+// FileName returns the file name of the summary in the PostgreSQL 17 naming scheme:
+// timeline, start LSN and end LSN in hexadecimal.
+func (s *WalSummary) FileName() string {
+	return fmt.Sprintf("%08X%08X%08X%08X%08X.summary",
+		s.Timeline, uint32(s.StartLSN>>32), uint32(s.StartLSN), uint32(s.EndLSN>>32), uint32(s.EndLSN))
+}
+
+// WriteTo writes the summary in its compact binary format: a header, then the relation forks in
+// storage order with their limit block and delta encoded block numbers, then a CRC-32C of the whole.
+func (s *WalSummary) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(walSummaryMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	putUint32 := func(n uint32) {
+		binary.BigEndian.PutUint32(scratch[:], n)
+		buf.Write(scratch[:4])
+	}
+	putUint64 := func(n uint64) {
+		binary.BigEndian.PutUint64(scratch[:], n)
+		buf.Write(scratch[:8])
+	}
+	putUvarint := func(n uint64) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], n)])
+	}
+
+	putUint32(walSummaryVersion)
+	putUint32(s.Timeline)
+	putUint64(uint64(s.StartLSN))
+	putUint64(uint64(s.EndLSN))
+
+	rfs := s.RelForks()
+	putUint32(uint32(len(rfs)))
+	for _, rf := range rfs {
+		blocks, limit := s.Blocks(rf)
+		putUint32(rf.Locator.SpcOid)
+		putUint32(rf.Locator.DbOid)
+		putUint32(rf.Locator.RelNumber)
+		buf.WriteByte(byte(rf.Fork))
+		putUint32(limit)
+		putUvarint(uint64(len(blocks)))
+		prev := uint32(0)
+		for _, b := range blocks {
+			putUvarint(uint64(b - prev))
+			prev = b
+		}
+	}
+
+	putUint32(crc32.Checksum(buf.Bytes(), crc32cTable))
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// ReadWalSummary reads a summary written by WalSummary.WriteTo.
+func ReadWalSummary(r io.Reader) (*WalSummary, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := len(walSummaryMagic) + 4 + 4 + 8 + 8 + 4
+	if len(data) < headerLen+4 || string(data[:len(walSummaryMagic)]) != walSummaryMagic {
+		return nil, errors.New("not a WAL summary")
+	}
+
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, crc32cTable) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return nil, errors.New("WAL summary checksum mismatch")
+	}
+
+	rd := bufio.NewReader(bytes.NewReader(body[len(walSummaryMagic):]))
+	var header struct {
+		Version  uint32
+		Timeline uint32
+		StartLSN uint64
+		EndLSN   uint64
+		Count    uint32
+	}
+	if err := binary.Read(rd, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != walSummaryVersion {
+		return nil, errors.Errorf("unsupported WAL summary version %d", header.Version)
+	}
+
+	s := NewWalSummary(header.Timeline, LSN(header.StartLSN), LSN(header.EndLSN))
+	for i := uint32(0); i < header.Count; i++ {
+		var rec struct {
+			SpcOid, DbOid, RelNumber uint32
+			Fork                     uint8
+			Limit                    uint32
+		}
+		if err := binary.Read(rd, binary.BigEndian, &rec); err != nil {
+			return nil, errors.Errorf("failed to read WAL summary entry: %w", err)
+		}
+		rf := RelFork{Locator: RelFileLocator{SpcOid: rec.SpcOid, DbOid: rec.DbOid, RelNumber: rec.RelNumber}, Fork: ForkNumber(rec.Fork)}
+		e := s.entry(rf)
+		e.limitBlock = rec.Limit
+
+		n, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, errors.Errorf("failed to read WAL summary entry: %w", err)
+		}
+		block := uint64(0)
+		for j := uint64(0); j < n; j++ {
+			delta, err := binary.ReadUvarint(rd)
+			if err != nil {
+				return nil, errors.Errorf("failed to read WAL summary entry: %w", err)
+			}
+			block += delta
+			e.blocks[uint32(block)] = struct{}{}
+		}
+	}
+
+	if _, err := rd.ReadByte(); err != io.EOF {
+		return nil, errors.New("trailing data in WAL summary")
+	}
+
+	return s, nil
+}
+
+// WriteWalSummaryFile durably writes s into dir under its FileName and returns the file path.
+func WriteWalSummaryFile(dir string, s *WalSummary) (string, error) {
+	path := filepath.Join(dir, s.FileName())
+	tmp, err := ioutil.TempFile(dir, s.FileName()+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := s.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ReadWalSummaryFile reads a summary file written by WriteWalSummaryFile.
+func ReadWalSummaryFile(path string) (*WalSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadWalSummary(f)
+}
+
+// WalSummaryFile describes a summary file by its name.
+type WalSummaryFile struct {
+	Path     string
+	Timeline uint32
+	StartLSN LSN
+	EndLSN   LSN
+}
+
+// ListWalSummaryFiles returns the summary files in dir ordered by timeline and start LSN.
+func ListWalSummaryFiles(dir string) ([]WalSummaryFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []WalSummaryFile
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || len(name) != 48 || !strings.HasSuffix(name, ".summary") {
+			continue
+		}
+		var tli, startHi, startLo, endHi, endLo uint32
+		if _, err := fmt.Sscanf(name, "%08X%08X%08X%08X%08X.summary", &tli, &startHi, &startLo, &endHi, &endLo); err != nil {
+			continue
+		}
+		files = append(files, WalSummaryFile{
+			Path:     filepath.Join(dir, name),
+			Timeline: tli,
+			StartLSN: LSN(uint64(startHi)<<32 | uint64(startLo)),
+			EndLSN:   LSN(uint64(endHi)<<32 | uint64(endLo)),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Timeline != files[j].Timeline {
+			return files[i].Timeline < files[j].Timeline
+		}
+		return files[i].StartLSN < files[j].StartLSN
+	})
+	return files, nil
+}
+
+// SummarizeWalSummaryFiles merges the summary files in dir that overlap [startLSN, endLSN) into one summary.
+// It returns an error if the files do not cover the whole range.
+func SummarizeWalSummaryFiles(dir string, startLSN, endLSN LSN) (*WalSummary, error) {
+	files, err := ListWalSummaryFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *WalSummary
+	covered := startLSN
+	for _, f := range files {
+		if f.EndLSN <= startLSN || f.StartLSN >= endLSN {
+			continue
+		}
+		if f.StartLSN > covered {
+			return nil, errors.Errorf("no WAL summary covers %s to %s", covered, f.StartLSN)
+		}
+		s, err := ReadWalSummaryFile(f.Path)
+		if err != nil {
+			return nil, errors.Errorf("failed to read %s: %w", f.Path, err)
+		}
+		if result == nil {
+			result = s
+		} else {
+			result.Merge(s)
+		}
+		if f.EndLSN > covered {
+			covered = f.EndLSN
+		}
+	}
+
+	if result == nil || covered < endLSN {
+		return nil, errors.Errorf("no WAL summary covers %s to %s", covered, endLSN)
+	}
+	return result, nil
+}
+
+// WalSummarizer builds WalSummary values from decoded WAL records.
+type WalSummarizer struct {
+	current *WalSummary
+}
+
+// NewWalSummarizer creates a summarizer whose first summary starts at startLSN.
+func NewWalSummarizer(timeline uint32, startLSN LSN) *WalSummarizer {
+	return &WalSummarizer{current: NewWalSummary(timeline, startLSN, startLSN)}
+}
+
+// Add records the blocks changed by rec. Records before the start of the current summary are ignored.
+func (s *WalSummarizer) Add(rec *XLogRecord) error {
+	sum := s.current
+	if rec.LSN < sum.StartLSN {
+		return nil
+	}
+	if rec.EndLSN > sum.EndLSN {
+		sum.EndLSN = rec.EndLSN
+	}
+
+	for _, b := range rec.Blocks {
+		sum.MarkBlockModified(RelFork{Locator: b.Locator, Fork: b.Fork}, b.Block)
+	}
+
+	switch rec.Rmgr {
+	case RmgrStorage:
+		return s.addStorageRecord(rec)
+	case RmgrTransaction:
+		return s.addTransactionRecord(rec)
+	case RmgrDatabase:
+		return s.addDatabaseRecord(rec)
+	}
+	return nil
+}
+
+// Cut ends the current summary at endLSN, returns it and starts the next one there.
+func (s *WalSummarizer) Cut(endLSN LSN) *WalSummary {
+	sum := s.current
+	sum.EndLSN = endLSN
+	s.current = NewWalSummary(sum.Timeline, endLSN, endLSN)
+	return sum
+}
+
+func (s *WalSummarizer) addStorageRecord(rec *XLogRecord) error {
+	r := xlogRecordReader{buf: rec.MainData, lsn: rec.LSN}
+
+	switch rec.Info & 0xF0 {
+	case 0x10: // XLOG_SMGR_CREATE
+		locator, err := r.locator()
+		if err != nil {
+			return err
+		}
+		fork, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		s.current.SetLimitBlock(RelFork{Locator: locator, Fork: ForkNumber(fork)}, 0)
+	case 0x20: // XLOG_SMGR_TRUNCATE
+		block, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		locator, err := r.locator()
+		if err != nil {
+			return err
+		}
+		flags, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		if flags&0x1 != 0 {
+			s.current.SetLimitBlock(RelFork{Locator: locator, Fork: MainForkNum}, block)
+		}
+		if flags&0x2 != 0 {
+			s.current.SetLimitBlock(RelFork{Locator: locator, Fork: VisibilityMapForkNum}, 0)
+		}
+		if flags&0x4 != 0 {
+			s.current.SetLimitBlock(RelFork{Locator: locator, Fork: FSMForkNum}, 0)
+		}
+	}
+	return nil
+}
+
+// addDatabaseRecord handles databases created or dropped as a whole, whose relation files are copied
+// or removed without block references. Like SummarizeDbaseRecord of PostgreSQL 17, it sets limit
+// block 0 for relation number 0 of the database in every tablespace involved.
+func (s *WalSummarizer) addDatabaseRecord(rec *XLogRecord) error {
+	r := xlogRecordReader{buf: rec.MainData, lsn: rec.LSN}
+	database := func(dbOid, spcOid uint32) {
+		s.current.SetLimitBlock(RelFork{Locator: RelFileLocator{SpcOid: spcOid, DbOid: dbOid}, Fork: MainForkNum}, 0)
+	}
+
+	switch rec.Info & 0xF0 {
+	case 0x00, 0x10: // XLOG_DBASE_CREATE_FILE_COPY, XLOG_DBASE_CREATE_WAL_LOG
+		dbOid, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		spcOid, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		database(dbOid, spcOid)
+	case 0x20: // XLOG_DBASE_DROP
+		dbOid, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		n, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			spcOid, err := r.uint32()
+			if err != nil {
+				return err
+			}
+			database(dbOid, spcOid)
+		}
+	}
+	return nil
+}
+
+// addTransactionRecord handles the relations dropped by a commit or abort record.
+// See ParseCommitRecord in src/backend/access/rmgrdesc/xactdesc.c.
+func (s *WalSummarizer) addTransactionRecord(rec *XLogRecord) error {
+	switch rec.Info & 0x70 {
+	case 0x00, 0x20, 0x30, 0x40: // COMMIT, ABORT, COMMIT_PREPARED, ABORT_PREPARED
+	default:
+		return nil
+	}
+	if rec.Info&0x80 == 0 { // XLOG_XACT_HAS_INFO
+		return nil
+	}
+
+	r := xlogRecordReader{buf: rec.MainData, lsn: rec.LSN}
+	if _, err := r.bytes(8); err != nil { // xact_time
+		return err
+	}
+	xinfo, err := r.uint32()
+	if err != nil {
+		return err
+	}
+	if xinfo&(1<<0) != 0 { // XACT_XINFO_HAS_DBINFO
+		if _, err := r.bytes(8); err != nil {
+			return err
+		}
+	}
+	if xinfo&(1<<1) != 0 { // XACT_XINFO_HAS_SUBXACTS
+		n, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		if _, err := r.bytes(int(n) * 4); err != nil {
+			return err
+		}
+	}
+	if xinfo&(1<<2) != 0 { // XACT_XINFO_HAS_RELFILELOCATORS
+		n, err := r.uint32()
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			locator, err := r.locator()
+			if err != nil {
+				return err
+			}
+			for fork := MainForkNum; fork <= InitForkNum; fork++ {
+				s.current.SetLimitBlock(RelFork{Locator: locator, Fork: fork}, 0)
+			}
+		}
+	}
+	return nil
+}
+
+func relForkLess(a, b RelFork) bool {
+	switch {
+	case a.Locator.SpcOid != b.Locator.SpcOid:
+		return a.Locator.SpcOid < b.Locator.SpcOid
+	case a.Locator.DbOid != b.Locator.DbOid:
+		return a.Locator.DbOid < b.Locator.DbOid
+	case a.Locator.RelNumber != b.Locator.RelNumber:
+		return a.Locator.RelNumber < b.Locator.RelNumber
+	}
+	return a.Fork < b.Fork
+}
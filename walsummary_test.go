@@ -0,0 +1,208 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestWalSummarizer(t *testing.T) {
+	rel := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16385}
+	other := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16390}
+
+	truncate := make([]byte, 20)
+	binary.LittleEndian.PutUint32(truncate[0:], 5)
+	binary.LittleEndian.PutUint32(truncate[4:], rel.SpcOid)
+	binary.LittleEndian.PutUint32(truncate[8:], rel.DbOid)
+	binary.LittleEndian.PutUint32(truncate[12:], rel.RelNumber)
+	binary.LittleEndian.PutUint32(truncate[16:], 0x1)
+
+	records := append(testWALRecords(),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 732, []testBlock{{id: 0, locator: other, block: 3, data: []byte("x")}}, []byte{1}),
+		buildXLogRecord(pglogrepl.RmgrStorage, 0x20, 0, nil, truncate),
+	)
+	wal, lsns := buildWAL(records)
+	decoded, err := pglogrepl.NewXLogRecordDecoder().Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+
+	summarizer := pglogrepl.NewWalSummarizer(1, testSegmentStart)
+	for _, rec := range decoded {
+		require.NoError(t, summarizer.Add(rec))
+	}
+	summary := summarizer.Cut(decoded[len(decoded)-1].EndLSN)
+
+	assert.Equal(t, testSegmentStart, summary.StartLSN)
+	assert.True(t, summary.EndLSN > lsns[len(lsns)-1])
+
+	blocks, limit := summary.Blocks(pglogrepl.RelFork{Locator: rel, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, []uint32{}, blocks) // blocks 7 and 8 are implied by the truncation to 5 blocks
+	assert.Equal(t, uint32(5), limit)
+
+	blocks, limit = summary.Blocks(pglogrepl.RelFork{Locator: other, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, []uint32{3}, blocks)
+	assert.Equal(t, uint32(pglogrepl.InvalidBlockNumber), limit)
+	assert.Equal(t, 1, summary.ChangedBlockCount())
+
+	next := summarizer.Cut(summary.EndLSN + 100)
+	assert.Equal(t, summary.EndLSN, next.StartLSN)
+	assert.Empty(t, next.RelForks())
+}
+
+func TestWalSummarizerRecordsInBatch(t *testing.T) {
+	rel := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16385}
+	dropped := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16400}
+	other := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16390}
+
+	truncate := make([]byte, 20)
+	binary.LittleEndian.PutUint32(truncate[0:], 5)
+	binary.LittleEndian.PutUint32(truncate[4:], rel.SpcOid)
+	binary.LittleEndian.PutUint32(truncate[8:], rel.DbOid)
+	binary.LittleEndian.PutUint32(truncate[12:], rel.RelNumber)
+	binary.LittleEndian.PutUint32(truncate[16:], 0x1)
+
+	// xact_time, xinfo with XACT_XINFO_HAS_RELFILELOCATORS, one dropped relation.
+	commit := make([]byte, 28)
+	binary.LittleEndian.PutUint32(commit[8:], 1<<2)
+	binary.LittleEndian.PutUint32(commit[12:], 1)
+	binary.LittleEndian.PutUint32(commit[16:], dropped.SpcOid)
+	binary.LittleEndian.PutUint32(commit[20:], dropped.DbOid)
+	binary.LittleEndian.PutUint32(commit[24:], dropped.RelNumber)
+
+	// The truncate and the commit are in the middle of the XLogData, followed by other records.
+	wal, _ := buildWAL([][]byte{
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 731, []testBlock{{id: 0, locator: rel, block: 7, data: []byte("x")}}, []byte{1}),
+		buildXLogRecord(pglogrepl.RmgrStorage, 0x20, 0, nil, truncate),
+		buildXLogRecord(pglogrepl.RmgrTransaction, 0x80, 731, nil, commit),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 732, []testBlock{{id: 0, locator: other, block: 3, data: []byte("y")}}, bytes.Repeat([]byte{0xEE}, 40)),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 732, []testBlock{{id: 0, locator: other, block: 4, data: []byte("z")}}, bytes.Repeat([]byte{0xFF}, 40)),
+	})
+	decoded, err := pglogrepl.NewXLogRecordDecoder().Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+	require.Len(t, decoded, 5)
+
+	summarizer := pglogrepl.NewWalSummarizer(1, testSegmentStart)
+	for _, rec := range decoded {
+		require.NoError(t, summarizer.Add(rec))
+	}
+	summary := summarizer.Cut(decoded[len(decoded)-1].EndLSN)
+
+	_, limit := summary.Blocks(pglogrepl.RelFork{Locator: rel, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, uint32(5), limit)
+	_, limit = summary.Blocks(pglogrepl.RelFork{Locator: dropped, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, uint32(0), limit)
+	blocks, _ := summary.Blocks(pglogrepl.RelFork{Locator: other, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, []uint32{3, 4}, blocks)
+}
+
+func TestWalSummarizerDatabases(t *testing.T) {
+	rel := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16385}
+	copied := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16500, RelNumber: 1259}
+	dropped := pglogrepl.RelFileLocator{SpcOid: 1700, DbOid: 16600, RelNumber: 16601}
+
+	// XLOG_DBASE_CREATE_FILE_COPY: db_id, tablespace_id, src_db_id, src_tablespace_id.
+	create := make([]byte, 16)
+	binary.LittleEndian.PutUint32(create[0:], copied.DbOid)
+	binary.LittleEndian.PutUint32(create[4:], copied.SpcOid)
+	binary.LittleEndian.PutUint32(create[8:], 1)
+	binary.LittleEndian.PutUint32(create[12:], 1663)
+
+	// XLOG_DBASE_DROP: db_id, ntablespaces, tablespace_ids.
+	drop := make([]byte, 16)
+	binary.LittleEndian.PutUint32(drop[0:], dropped.DbOid)
+	binary.LittleEndian.PutUint32(drop[4:], 2)
+	binary.LittleEndian.PutUint32(drop[8:], 1663)
+	binary.LittleEndian.PutUint32(drop[12:], dropped.SpcOid)
+
+	wal, _ := buildWAL([][]byte{
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 731, []testBlock{{id: 0, locator: rel, block: 7, data: []byte("x")}}, []byte{1}),
+		buildXLogRecord(pglogrepl.RmgrDatabase, 0x00, 732, nil, create),
+		buildXLogRecord(pglogrepl.RmgrDatabase, 0x20, 733, nil, drop),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 734, []testBlock{{id: 0, locator: copied, block: 2, data: []byte("y")}}, []byte{1}),
+	})
+	decoded, err := pglogrepl.NewXLogRecordDecoder().Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+	require.Len(t, decoded, 4)
+
+	summarizer := pglogrepl.NewWalSummarizer(1, testSegmentStart)
+	for _, rec := range decoded {
+		require.NoError(t, summarizer.Add(rec))
+	}
+	summary := summarizer.Cut(decoded[len(decoded)-1].EndLSN)
+
+	// Every relation of a created or dropped database is changed as a whole.
+	blocks, limit := summary.Blocks(pglogrepl.RelFork{Locator: copied, Fork: pglogrepl.MainForkNum})
+	assert.Empty(t, blocks)
+	assert.Equal(t, uint32(0), limit)
+	_, limit = summary.Blocks(pglogrepl.RelFork{Locator: dropped, Fork: pglogrepl.FSMForkNum})
+	assert.Equal(t, uint32(0), limit)
+	_, limit = summary.Blocks(pglogrepl.RelFork{Locator: pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: dropped.DbOid, RelNumber: 1}, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, uint32(0), limit)
+
+	blocks, limit = summary.Blocks(pglogrepl.RelFork{Locator: rel, Fork: pglogrepl.MainForkNum})
+	assert.Equal(t, []uint32{7}, blocks)
+	assert.Equal(t, uint32(pglogrepl.InvalidBlockNumber), limit)
+}
+
+func TestWalSummaryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pglogrepl_walsummary")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rel := pglogrepl.RelFork{Locator: pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 5, RelNumber: 16385}}
+	vm := pglogrepl.RelFork{Locator: rel.Locator, Fork: pglogrepl.VisibilityMapForkNum}
+
+	first := pglogrepl.NewWalSummary(1, 0x1000000, 0x2000000)
+	first.MarkBlockModified(rel, 1)
+	first.MarkBlockModified(rel, 1000)
+	first.MarkBlockModified(rel, 70000)
+	first.SetLimitBlock(vm, 0)
+
+	second := pglogrepl.NewWalSummary(1, 0x2000000, 0x3000000)
+	second.MarkBlockModified(rel, 2)
+	second.SetLimitBlock(rel, 500)
+
+	path, err := pglogrepl.WriteWalSummaryFile(dir, first)
+	require.NoError(t, err)
+	assert.Equal(t, "0000000100000000010000000000000002000000.summary", filepath.Base(path))
+	_, err = pglogrepl.WriteWalSummaryFile(dir, second)
+	require.NoError(t, err)
+
+	read, err := pglogrepl.ReadWalSummaryFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, first.FileName(), read.FileName())
+	blocks, limit := read.Blocks(rel)
+	assert.Equal(t, []uint32{1, 1000, 70000}, blocks)
+	assert.Equal(t, uint32(pglogrepl.InvalidBlockNumber), limit)
+	_, limit = read.Blocks(vm)
+	assert.Equal(t, uint32(0), limit)
+
+	files, err := pglogrepl.ListWalSummaryFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	assert.Equal(t, pglogrepl.LSN(0x2000000), files[1].StartLSN)
+
+	merged, err := pglogrepl.SummarizeWalSummaryFiles(dir, 0x1800000, 0x2800000)
+	require.NoError(t, err)
+	blocks, limit = merged.Blocks(rel)
+	assert.Equal(t, []uint32{1, 2}, blocks)
+	assert.Equal(t, uint32(500), limit)
+
+	_, err = pglogrepl.SummarizeWalSummaryFiles(dir, 0x1800000, 0x4000000)
+	assert.Error(t, err)
+
+	buf := &bytes.Buffer{}
+	_, err = first.WriteTo(buf)
+	require.NoError(t, err)
+	corrupt := buf.Bytes()
+	corrupt[20] ^= 0xFF
+	_, err = pglogrepl.ReadWalSummary(bytes.NewReader(corrupt))
+	assert.Error(t, err)
+}
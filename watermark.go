@@ -0,0 +1,288 @@
+package pglogrepl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	errors "golang.org/x/xerrors"
+)
+
+// IncrementalSnapshotOptions configures an IncrementalSnapshot.
+type IncrementalSnapshotOptions struct {
+	// ChunkSize is the number of rows read per chunk. Defaults to 1024.
+	ChunkSize int
+	// Prefix is the prefix of the watermark messages. Defaults to "pglogrepl.watermark".
+	Prefix string
+}
+
+// IncrementalSnapshot backfills tables while the stream keeps running, following the watermark algorithm of DBLog.
+//
+// Snapshot reads a table in chunks ordered by its key. Every chunk is read between a low and a high watermark
+// written to the WAL with pg_logical_emit_message. The stream consumer passes every decoded change through Process,
+// which replaces the high watermark of a chunk by the chunk's rows, except for the rows whose key was changed between
+// the watermarks: the streamed change is newer than the row read. The slot must send logical decoding messages, e.g.
+// with the pgoutput option messages 'true'.
+type IncrementalSnapshot struct {
+	conn    *pgconn.PgConn
+	options IncrementalSnapshotOptions
+	run     string
+
+	mu     sync.Mutex
+	chunks map[string]*watermarkChunk
+	window *watermarkChunk
+	next   int
+}
+
+// watermarkChunk is a chunk between its watermarks.
+type watermarkChunk struct {
+	rel       *RelationWalData
+	keys      []int // indexes of the key columns
+	rows      []*InsertWalData
+	changed   map[string]bool // keys changed by the stream between the watermarks
+	truncated bool
+	done      chan struct{}
+}
+
+// NewIncrementalSnapshot creates an IncrementalSnapshot reading tables and writing watermarks on the regular
+// connection conn.
+func NewIncrementalSnapshot(conn *pgconn.PgConn, options IncrementalSnapshotOptions) *IncrementalSnapshot {
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = 1024
+	}
+	if options.Prefix == "" {
+		options.Prefix = "pglogrepl.watermark"
+	}
+	return &IncrementalSnapshot{
+		conn:    conn,
+		options: options,
+		run:     strconv.FormatInt(time.Now().UnixNano(), 36),
+		chunks:  make(map[string]*watermarkChunk),
+	}
+}
+
+// Snapshot backfills a table, given as schema.table, that has a primary key or replica identity index. It returns
+// when the stream consumer has passed the high watermark of the last chunk to Process, so Process must be called
+// concurrently. Snapshot must not be called concurrently with itself.
+func (s *IncrementalSnapshot) Snapshot(ctx context.Context, table string) error {
+	rel, err := CatalogRelation(ctx, s.conn, table)
+	if err != nil {
+		return err
+	}
+	var keys []int
+	if rel.RelReplIdent != 'f' {
+		for i := range rel.Columns {
+			if rel.Columns[i].Flag {
+				keys = append(keys, i)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("%s has no primary key or replica identity index", table)
+	}
+
+	var last [][]byte
+	for {
+		chunk := &watermarkChunk{rel: rel, keys: keys, changed: make(map[string]bool), done: make(chan struct{})}
+		s.mu.Lock()
+		s.next++
+		id := fmt.Sprintf("%s-%d", s.run, s.next)
+		s.chunks[id] = chunk
+		s.mu.Unlock()
+
+		if err := s.emitWatermark(ctx, "low", id); err != nil {
+			return s.abandon(id, err)
+		}
+		rows, err := s.readChunk(ctx, chunk, last)
+		if err != nil {
+			return s.abandon(id, err)
+		}
+		s.mu.Lock()
+		chunk.rows = rows
+		s.mu.Unlock()
+		if err := s.emitWatermark(ctx, "high", id); err != nil {
+			return s.abandon(id, err)
+		}
+
+		select {
+		case <-chunk.done:
+		case <-ctx.Done():
+			return s.abandon(id, ctx.Err())
+		}
+
+		if len(rows) < s.options.ChunkSize {
+			return nil
+		}
+		lastRow := rows[len(rows)-1]
+		last = make([][]byte, len(keys))
+		for i, k := range keys {
+			last[i] = lastRow.Tuples.Tuples[k].Value
+		}
+	}
+}
+
+// Process handles a change decoded from the stream and returns the changes to pass on in its place. Watermarks are
+// consumed; the high watermark of a chunk is replaced by the chunk's rows as inserts with Snapshot set.
+func (s *IncrementalSnapshot) Process(wd *WalData) []*WalData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message, ok := wd.Value.(*MessageWalData); ok && message.Prefix == s.options.Prefix {
+		content := string(message.Content)
+		switch {
+		case strings.HasPrefix(content, "low "):
+			s.window = s.chunks[content[len("low "):]]
+		case strings.HasPrefix(content, "high "):
+			id := content[len("high "):]
+			chunk, ok := s.chunks[id]
+			if !ok {
+				// A watermark of an abandoned chunk or of an earlier run.
+				return nil
+			}
+			delete(s.chunks, id)
+			if s.window == chunk {
+				s.window = nil
+			}
+			close(chunk.done)
+			return chunk.result()
+		}
+		return nil
+	}
+
+	if s.window != nil {
+		s.window.record(wd)
+	}
+	return []*WalData{wd}
+}
+
+// abandon forgets a chunk after an error.
+func (s *IncrementalSnapshot) abandon(id string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.window == s.chunks[id] {
+		s.window = nil
+	}
+	delete(s.chunks, id)
+	return err
+}
+
+func (s *IncrementalSnapshot) emitWatermark(ctx context.Context, kind, id string) error {
+	params := [][]byte{[]byte(s.options.Prefix), []byte(kind + " " + id)}
+	result := s.conn.ExecParams(ctx, "SELECT pg_logical_emit_message(false, $1, $2)", params, nil, nil, nil).Read()
+	if result.Err != nil {
+		return errors.Errorf("failed to emit %s watermark: %w", kind, result.Err)
+	}
+	return nil
+}
+
+// readChunk reads the rows following the key last, or the first rows if last is nil.
+func (s *IncrementalSnapshot) readChunk(ctx context.Context, chunk *watermarkChunk, last [][]byte) ([]*InsertWalData, error) {
+	keyColumns := make([]string, len(chunk.keys))
+	placeholders := make([]string, len(chunk.keys))
+	for i, k := range chunk.keys {
+		keyColumns[i] = quoteIdentifier(chunk.rel.Columns[k].Name)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	keyList := strings.Join(keyColumns, ", ")
+
+	sql := fmt.Sprintf("SELECT %s FROM %s", columnList(chunk.rel), quoteIdentifier(chunk.rel.Namespace)+"."+quoteIdentifier(chunk.rel.RelationName))
+	if last != nil {
+		sql += fmt.Sprintf(" WHERE (%s) > (%s)", keyList, strings.Join(placeholders, ", "))
+	}
+	sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", keyList, s.options.ChunkSize)
+
+	result := s.conn.ExecParams(ctx, sql, last, nil, nil, nil).Read()
+	if result.Err != nil {
+		return nil, errors.Errorf("failed to read chunk of %s: %w", chunk.rel.FullName(), result.Err)
+	}
+
+	rows := make([]*InsertWalData, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		insert := &InsertWalData{RelationId: chunk.rel.ID, Relation: *chunk.rel, Snapshot: true}
+		insert.Tuples.Tuples = make([]Tuple, len(row))
+		for i, v := range row {
+			insert.Tuples.Tuples[i] = Tuple{RelCol: chunk.rel.Columns[i], Value: v, IsNull: v == nil}
+		}
+		rows = append(rows, insert)
+	}
+	return rows, nil
+}
+
+// record notes the keys a streamed change touches on the chunk's table.
+func (c *watermarkChunk) record(wd *WalData) {
+	switch v := wd.Value.(type) {
+	case *InsertWalData:
+		if v.Relation.FullName() == c.rel.FullName() {
+			c.recordTuple(&v.Tuples)
+		}
+	case *UpdateWalData:
+		if v.Relation.FullName() == c.rel.FullName() {
+			c.recordTuple(&v.Tuples)
+			if v.OldTuples != nil {
+				c.recordTuple(v.OldTuples)
+			}
+		}
+	case *DeleteWalData:
+		if v.Relation.FullName() == c.rel.FullName() {
+			c.recordTuple(&v.Tuples)
+		}
+	case *TruncateWalData:
+		for i := range v.Relations {
+			if v.Relations[i].FullName() == c.rel.FullName() {
+				c.truncated = true
+			}
+		}
+	}
+}
+
+func (c *watermarkChunk) recordTuple(td *TupleData) {
+	values := make([][]byte, len(c.keys))
+	for i, k := range c.keys {
+		name := c.rel.Columns[k].Name
+		found := false
+		for j := range td.Tuples {
+			t := &td.Tuples[j]
+			if t.RelCol.Name == name && !t.IsTOAST && !t.IsNull {
+				values[i] = t.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+	c.changed[watermarkKey(values)] = true
+}
+
+// result returns the rows of the chunk that were not changed between the watermarks.
+func (c *watermarkChunk) result() []*WalData {
+	if c.truncated {
+		return nil
+	}
+	result := make([]*WalData, 0, len(c.rows))
+	for _, row := range c.rows {
+		values := make([][]byte, len(c.keys))
+		for i, k := range c.keys {
+			values[i] = row.Tuples.Tuples[k].Value
+		}
+		if !c.changed[watermarkKey(values)] {
+			result = append(result, &WalData{Type: Insert, Value: row})
+		}
+	}
+	return result
+}
+
+func watermarkKey(values [][]byte) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	return b.String()
+}
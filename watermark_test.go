@@ -0,0 +1,95 @@
+package pglogrepl_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+func TestIncrementalSnapshotPassesChanges(t *testing.T) {
+	s := pglogrepl.NewIncrementalSnapshot(nil, pglogrepl.IncrementalSnapshotOptions{})
+
+	watermark := &pglogrepl.WalData{Type: pglogrepl.Message, Value: &pglogrepl.MessageWalData{Prefix: "pglogrepl.watermark", Content: []byte("high x-1")}}
+	assert.Empty(t, s.Process(watermark))
+
+	other := &pglogrepl.WalData{Type: pglogrepl.Message, Value: &pglogrepl.MessageWalData{Prefix: "app", Content: []byte("high x-1")}}
+	assert.Equal(t, []*pglogrepl.WalData{other}, s.Process(other))
+}
+
+func TestIncrementalSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGLOGREPL_TEST_CONN_STRING"))
+	require.NoError(t, err)
+	delete(config.RuntimeParams, "replication")
+	dataConn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	defer closeConn(t, dataConn)
+
+	_, err = dataConn.Exec(ctx, `
+drop table if exists backfill_t;
+create table backfill_t(id int primary key, name text);
+insert into backfill_t select i, 'row ' || i from generate_series(1, 5) i;
+`).ReadAll()
+	require.NoError(t, err)
+	defer dataConn.Exec(context.Background(), "drop table backfill_t").ReadAll()
+
+	sysident, err := pglogrepl.IdentifySystem(ctx, conn)
+	require.NoError(t, err)
+	_, err = pglogrepl.CreateReplicationSlot(ctx, conn, slotName, outputPlugin, pglogrepl.CreateReplicationSlotOptions{Temporary: true})
+	require.NoError(t, err)
+	err = pglogrepl.StartReplication(ctx, conn, slotName, sysident.XLogPos, pglogrepl.StartReplicationOptions{})
+	require.NoError(t, err)
+
+	s := pglogrepl.NewIncrementalSnapshot(dataConn, pglogrepl.IncrementalSnapshotOptions{ChunkSize: 2})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Snapshot(ctx, "public.backfill_t")
+	}()
+
+	p := pglogrepl.NewTestDecodingParser()
+	backfilled := 0
+	for {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			assert.Equal(t, 5, backfilled)
+			return
+		default:
+		}
+
+		msgCtx, msgCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		msg, err := conn.ReceiveMessage(msgCtx)
+		msgCancel()
+		if pgconn.Timeout(err) {
+			continue
+		}
+		require.NoError(t, err)
+		cd, ok := msg.(*pgproto3.CopyData)
+		if !ok || cd.Data[0] != pglogrepl.XLogDataByteID {
+			continue
+		}
+		xld, err := pglogrepl.ParseXLogData(cd.Data[1:])
+		require.NoError(t, err)
+		wd, err := p.Parse(xld)
+		require.NoError(t, err)
+		for _, out := range s.Process(wd) {
+			if insert, ok := out.Value.(*pglogrepl.InsertWalData); ok && insert.Snapshot {
+				backfilled++
+			}
+		}
+	}
+}
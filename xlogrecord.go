@@ -0,0 +1,711 @@
+package pglogrepl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// Physical WAL layout constants. See src/include/access/xlog_internal.h and
+// src/include/access/xlogrecord.h in the PostgreSQL sources.
+const (
+	DefaultXLogPageSize    = 8192
+	DefaultXLogSegmentSize = 16 * 1024 * 1024
+
+	xlogShortPageHeaderSize = 24
+	xlogLongPageHeaderSize  = 40
+	xlogRecordHeaderSize    = 24
+	xlogMaxAlign            = 8
+	xlogBlockSize           = 8192
+
+	xlpFirstIsContRecord = 0x0001
+	xlpLongHeader        = 0x0002
+
+	xlrMaxBlockID         = 32
+	xlrBlockIDDataShort   = 255
+	xlrBlockIDDataLong    = 254
+	xlrBlockIDOrigin      = 253
+	xlrBlockIDToplevelXID = 252
+
+	bkpBlockForkMask = 0x0F
+	bkpBlockHasImage = 0x10
+	bkpBlockHasData  = 0x20
+	bkpBlockWillInit = 0x40
+	bkpBlockSameRel  = 0x80
+
+	bkpImageHasHole = 0x01
+
+	// xlogPageMagicPG15 is the first XLOG_PAGE_MAGIC that uses the PostgreSQL 15 bimg_info flags.
+	xlogPageMagicPG15 = 0xD110
+)
+
+// ErrEndOfWAL is returned by XLogRecordDecoder when it reaches zeroed space after the last valid record,
+// as found at the end of a partially filled or recycled segment file.
+var ErrEndOfWAL = errors.New("end of WAL")
+
+// RmgrID identifies the PostgreSQL resource manager that wrote a WAL record.
+type RmgrID uint8
+
+const (
+	RmgrXLOG RmgrID = iota
+	RmgrTransaction
+	RmgrStorage
+	RmgrCLOG
+	RmgrDatabase
+	RmgrTablespace
+	RmgrMultiXact
+	RmgrRelMap
+	RmgrStandby
+	RmgrHeap2
+	RmgrHeap
+	RmgrBtree
+	RmgrHash
+	RmgrGin
+	RmgrGist
+	RmgrSequence
+	RmgrSPGist
+	RmgrBRIN
+	RmgrCommitTs
+	RmgrReplicationOrigin
+	RmgrGeneric
+	RmgrLogicalMessage
+)
+
+var rmgrNames = []string{
+	"XLOG", "Transaction", "Storage", "CLOG", "Database", "Tablespace", "MultiXact", "RelMap",
+	"Standby", "Heap2", "Heap", "Btree", "Hash", "Gin", "Gist", "Sequence", "SPGist", "BRIN",
+	"CommitTs", "ReplicationOrigin", "Generic", "LogicalMessage",
+}
+
+// String returns the resource manager name as printed by pg_waldump.
+func (id RmgrID) String() string {
+	if int(id) < len(rmgrNames) {
+		return rmgrNames[id]
+	}
+	return fmt.Sprintf("custom%03d", uint8(id))
+}
+
+// RecordTypeName returns the pg_waldump name of the record type encoded in info for this resource manager.
+// Unknown record types are reported as UNKNOWN with their info bits.
+func (id RmgrID) RecordTypeName(info uint8) string {
+	info &^= 0x0F
+	var names map[uint8]string
+	mask := uint8(0xF0)
+	suffix := ""
+
+	switch id {
+	case RmgrXLOG:
+		names = xlogRecordNames
+	case RmgrTransaction:
+		names, mask = xactRecordNames, 0x70
+	case RmgrStorage:
+		names = smgrRecordNames
+	case RmgrStandby:
+		names = standbyRecordNames
+	case RmgrHeap:
+		names, mask = heapRecordNames, 0x70
+		if info&0x80 != 0 {
+			suffix = "+INIT"
+		}
+	case RmgrHeap2:
+		names, mask = heap2RecordNames, 0x70
+		if info&0x80 != 0 {
+			suffix = "+INIT"
+		}
+	case RmgrBtree:
+		names = btreeRecordNames
+	case RmgrSequence:
+		names = map[uint8]string{0x00: "LOG"}
+	case RmgrLogicalMessage:
+		names = map[uint8]string{0x00: "MESSAGE"}
+	}
+
+	if name, ok := names[info&mask]; ok {
+		return name + suffix
+	}
+	return fmt.Sprintf("UNKNOWN (%X)", info)
+}
+
+var xlogRecordNames = map[uint8]string{
+	0x00: "CHECKPOINT_SHUTDOWN", 0x10: "CHECKPOINT_ONLINE", 0x20: "NOOP", 0x30: "NEXTOID",
+	0x40: "SWITCH", 0x50: "BACKUP_END", 0x60: "PARAMETER_CHANGE", 0x70: "RESTORE_POINT",
+	0x80: "FPW_CHANGE", 0x90: "END_OF_RECOVERY", 0xA0: "FPI_FOR_HINT", 0xB0: "FPI",
+	0xD0: "OVERWRITE_CONTRECORD", 0xE0: "CHECKPOINT_REDO",
+}
+
+var xactRecordNames = map[uint8]string{
+	0x00: "COMMIT", 0x10: "PREPARE", 0x20: "ABORT", 0x30: "COMMIT_PREPARED",
+	0x40: "ABORT_PREPARED", 0x50: "ASSIGNMENT", 0x60: "INVALIDATION",
+}
+
+var smgrRecordNames = map[uint8]string{0x10: "CREATE", 0x20: "TRUNCATE"}
+
+var standbyRecordNames = map[uint8]string{0x00: "LOCK", 0x10: "RUNNING_XACTS", 0x20: "INVALIDATIONS"}
+
+var heapRecordNames = map[uint8]string{
+	0x00: "INSERT", 0x10: "DELETE", 0x20: "UPDATE", 0x30: "TRUNCATE",
+	0x40: "HOT_UPDATE", 0x50: "CONFIRM", 0x60: "LOCK", 0x70: "INPLACE",
+}
+
+// heap2RecordNames follows PostgreSQL 15 and 16, other versions renumbered the pruning records.
+var heap2RecordNames = map[uint8]string{
+	0x00: "REWRITE", 0x10: "PRUNE", 0x20: "VACUUM", 0x30: "FREEZE_PAGE",
+	0x40: "VISIBLE", 0x50: "MULTI_INSERT", 0x60: "LOCK_UPDATED", 0x70: "NEW_CID",
+}
+
+var btreeRecordNames = map[uint8]string{
+	0x00: "INSERT_LEAF", 0x10: "INSERT_UPPER", 0x20: "INSERT_META", 0x30: "SPLIT_L",
+	0x40: "SPLIT_R", 0x50: "INSERT_POST", 0x60: "DEDUP", 0x70: "DELETE",
+	0x80: "UNLINK_PAGE", 0x90: "UNLINK_PAGE_META", 0xA0: "NEWROOT", 0xB0: "MARK_PAGE_HALFDEAD",
+	0xC0: "VACUUM", 0xD0: "REUSE_PAGE", 0xE0: "META_CLEANUP",
+}
+
+// ForkNumber identifies a relation fork.
+type ForkNumber uint8
+
+const (
+	MainForkNum ForkNumber = iota
+	FSMForkNum
+	VisibilityMapForkNum
+	InitForkNum
+)
+
+var forkNames = []string{"main", "fsm", "vm", "init"}
+
+func (f ForkNumber) String() string {
+	if int(f) < len(forkNames) {
+		return forkNames[f]
+	}
+	return fmt.Sprintf("fork%d", uint8(f))
+}
+
+// RelFileLocator identifies the physical storage of a relation (RelFileNode before PostgreSQL 16).
+type RelFileLocator struct {
+	SpcOid    uint32
+	DbOid     uint32
+	RelNumber uint32
+}
+
+func (l RelFileLocator) String() string {
+	return fmt.Sprintf("%d/%d/%d", l.SpcOid, l.DbOid, l.RelNumber)
+}
+
+// XLogBlockRef is a reference from a WAL record to a relation block it modifies.
+type XLogBlockRef struct {
+	ID         uint8
+	Locator    RelFileLocator
+	Fork       ForkNumber
+	Block      uint32
+	WillInit   bool
+	HasImage   bool
+	ApplyImage bool
+	Compressed bool
+	ImageInfo  uint8
+	HoleOffset uint16
+	HoleLength uint16
+	Image      []byte // full-page image as stored in the record, possibly compressed
+	Data       []byte
+}
+
+// XLogRecord is a decoded physical WAL record.
+type XLogRecord struct {
+	LSN         LSN
+	EndLSN      LSN
+	TotalLen    uint32
+	XID         uint32
+	Prev        LSN
+	Info        uint8
+	Rmgr        RmgrID
+	CRC         uint32
+	Origin      uint16
+	ToplevelXID uint32
+	Blocks      []XLogBlockRef
+	MainData    []byte
+}
+
+// FPILen returns the number of bytes taken by full-page images in the record.
+func (r *XLogRecord) FPILen() int {
+	n := 0
+	for _, b := range r.Blocks {
+		n += len(b.Image)
+	}
+	return n
+}
+
+// RecordType returns the record type id as used by pg_waldump --stats=record.
+func (r *XLogRecord) RecordType() uint8 {
+	recordType := r.Info >> 4
+	if r.Rmgr == RmgrTransaction {
+		// The high bit of XACT records is XLOG_XACT_HAS_INFO, only XLOG_XACT_OPMASK identifies the record type.
+		recordType &= 0x07
+	}
+	return recordType
+}
+
+func (r *XLogRecord) String() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("rmgr: %s len (rec/tot): %d/%d, tx: %d, lsn: %s, prev %s, desc: %s",
+		r.Rmgr, int(r.TotalLen)-r.FPILen(), r.TotalLen, r.XID, r.LSN, r.Prev, r.Rmgr.RecordTypeName(r.Info)))
+
+	for _, b := range r.Blocks {
+		builder.WriteString(fmt.Sprintf(", blkref #%d: rel %s fork %s blk %d", b.ID, b.Locator, b.Fork, b.Block))
+		if b.HasImage {
+			builder.WriteString(" FPW")
+		}
+	}
+
+	return builder.String()
+}
+
+// XLogRecordDecoder reassembles WAL records from the raw WAL received over physical replication
+// or read from segment files. It has internal state, so XLogData must be passed in stream order.
+//
+// Decoding may start at any LSN: the decoder skips ahead to the first record that begins on
+// or after the first page boundary it sees.
+type XLogRecordDecoder struct {
+	pageSize uint32
+	segSize  uint32
+	magic    uint16
+
+	started bool
+	pos     LSN // LSN of the next byte expected
+	skipTo  LSN // raw bytes are skipped up to this LSN
+	synced  bool
+
+	hdr    []byte
+	hdrLen int
+
+	contSkip uint32 // bytes of a continuation record still to skip while syncing
+
+	inRecord bool
+	recLSN   LSN
+	recLen   uint32
+	rec      []byte
+
+	end bool
+}
+
+// NewXLogRecordDecoder creates a decoder for WAL with the default page and segment sizes.
+// The sizes are updated from the long page header at the start of each segment.
+func NewXLogRecordDecoder() *XLogRecordDecoder {
+	return &XLogRecordDecoder{pageSize: DefaultXLogPageSize, segSize: DefaultXLogSegmentSize}
+}
+
+// Decode consumes a chunk of raw WAL and returns the records completed by it.
+// It returns ErrEndOfWAL together with the records found before zeroed space.
+func (d *XLogRecordDecoder) Decode(xld XLogData) ([]*XLogRecord, error) {
+	if d.end {
+		return nil, ErrEndOfWAL
+	}
+
+	if !d.started {
+		d.started = true
+		d.pos = xld.WALStart
+		if off := uint32(d.pos) % d.pageSize; off != 0 {
+			d.skipTo = d.pos + LSN(d.pageSize-off)
+		}
+	} else if xld.WALStart != d.pos {
+		return nil, errors.Errorf("unexpected WAL start %s, expected %s", xld.WALStart, d.pos)
+	}
+
+	var records []*XLogRecord
+	data := xld.Data
+	for len(data) > 0 {
+		if d.pos < d.skipTo {
+			n := minInt(int(d.skipTo-d.pos), len(data))
+			d.advance(n)
+			data = data[n:]
+			continue
+		}
+
+		if uint32(d.pos)%d.pageSize == 0 && d.hdrLen == 0 {
+			d.hdrLen = xlogShortPageHeaderSize
+			d.hdr = d.hdr[:0]
+		}
+
+		if d.hdrLen > 0 {
+			n := minInt(d.hdrLen-len(d.hdr), len(data))
+			d.hdr = append(d.hdr, data[:n]...)
+			d.advance(n)
+			data = data[n:]
+
+			if len(d.hdr) == xlogShortPageHeaderSize && binary.LittleEndian.Uint16(d.hdr[2:])&xlpLongHeader != 0 {
+				d.hdrLen = xlogLongPageHeaderSize
+			}
+			if len(d.hdr) == d.hdrLen {
+				d.hdrLen = 0
+				if err := d.readPageHeader(); err != nil {
+					return records, err
+				}
+			}
+			continue
+		}
+
+		pageLeft := int(d.pageSize - uint32(d.pos)%d.pageSize)
+
+		if d.contSkip > 0 {
+			n := minInt(minInt(int(d.contSkip), pageLeft), len(data))
+			d.contSkip -= uint32(n)
+			d.advance(n)
+			data = data[n:]
+			continue
+		}
+
+		if !d.inRecord {
+			if pad := int(xlogMaxAlign - uint64(d.pos)%xlogMaxAlign); pad != xlogMaxAlign {
+				n := minInt(pad, len(data))
+				d.advance(n)
+				data = data[n:]
+				continue
+			}
+			d.inRecord = true
+			d.recLSN = d.pos
+			d.recLen = 0
+			d.rec = d.rec[:0]
+		}
+
+		need := 4 - len(d.rec)
+		if len(d.rec) >= 4 {
+			need = int(d.recLen) - len(d.rec)
+		}
+		n := minInt(minInt(need, pageLeft), len(data))
+		d.rec = append(d.rec, data[:n]...)
+		d.advance(n)
+		data = data[n:]
+
+		if len(d.rec) == 4 && d.recLen == 0 {
+			d.recLen = binary.LittleEndian.Uint32(d.rec)
+			if d.recLen == 0 {
+				d.end = true
+				return records, ErrEndOfWAL
+			}
+			if d.recLen < xlogRecordHeaderSize {
+				return records, errors.Errorf("invalid record length at %s: wanted %d, got %d", d.recLSN, xlogRecordHeaderSize, d.recLen)
+			}
+		}
+
+		if d.recLen > 0 && len(d.rec) == int(d.recLen) {
+			d.inRecord = false
+			// The record keeps slices of its bytes, so it gets its own copy: d.rec is reused for the next record.
+			rec, err := decodeXLogRecord(d.recLSN, append([]byte(nil), d.rec...), d.magic >= xlogPageMagicPG15)
+			if err != nil {
+				return records, err
+			}
+			rec.EndLSN = d.pos
+			records = append(records, rec)
+
+			// The rest of the segment after an XLOG_SWITCH record is unused.
+			if rec.Rmgr == RmgrXLOG && rec.Info&0xF0 == 0x40 {
+				if off := uint32(d.pos) % d.segSize; off != 0 {
+					d.skipTo = d.pos + LSN(d.segSize-off)
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func (d *XLogRecordDecoder) advance(n int) {
+	d.pos += LSN(n)
+}
+
+func (d *XLogRecordDecoder) readPageHeader() error {
+	pageLSN := d.pos - LSN(len(d.hdr))
+	magic := binary.LittleEndian.Uint16(d.hdr[0:])
+	info := binary.LittleEndian.Uint16(d.hdr[2:])
+	pageAddr := LSN(binary.LittleEndian.Uint64(d.hdr[8:]))
+	remLen := binary.LittleEndian.Uint32(d.hdr[16:])
+
+	if pageAddr != pageLSN {
+		return errors.Errorf("unexpected pageaddr %s in WAL page at %s", pageAddr, pageLSN)
+	}
+	d.magic = magic
+
+	if info&xlpLongHeader != 0 {
+		segSize := binary.LittleEndian.Uint32(d.hdr[32:])
+		pageSize := binary.LittleEndian.Uint32(d.hdr[36:])
+		if pageSize == 0 || segSize == 0 || segSize%pageSize != 0 {
+			return errors.Errorf("invalid WAL page size %d or segment size %d at %s", pageSize, segSize, pageLSN)
+		}
+		d.pageSize = pageSize
+		d.segSize = segSize
+	}
+
+	isCont := info&xlpFirstIsContRecord != 0
+	switch {
+	case !d.synced:
+		d.synced = true
+		if isCont {
+			d.contSkip = remLen
+		}
+	case d.contSkip > 0:
+		if !isCont {
+			return errors.Errorf("there is no contrecord flag at %s", pageLSN)
+		}
+		d.contSkip = remLen
+	case d.inRecord:
+		if !isCont {
+			return errors.Errorf("there is no contrecord flag at %s", pageLSN)
+		}
+		if d.recLen > 0 && remLen != d.recLen-uint32(len(d.rec)) {
+			return errors.Errorf("invalid contrecord length %d at %s, expected %d", remLen, pageLSN, d.recLen-uint32(len(d.rec)))
+		}
+	}
+
+	return nil
+}
+
+// decodeXLogRecord decodes a complete WAL record. See DecodeXLogRecord in src/backend/access/transam/xlogreader.c.
+func decodeXLogRecord(lsn LSN, buf []byte, pg15Flags bool) (*XLogRecord, error) {
+	rec := &XLogRecord{
+		LSN:      lsn,
+		TotalLen: binary.LittleEndian.Uint32(buf[0:]),
+		XID:      binary.LittleEndian.Uint32(buf[4:]),
+		Prev:     LSN(binary.LittleEndian.Uint64(buf[8:])),
+		Info:     buf[16],
+		Rmgr:     RmgrID(buf[17]),
+		CRC:      binary.LittleEndian.Uint32(buf[20:]),
+	}
+
+	r := xlogRecordReader{buf: buf, off: xlogRecordHeaderSize, lsn: lsn}
+	var blocks []xlogBlockHeader
+	var lastLocator *RelFileLocator
+	mainDataLen := 0
+	dataTotal := 0
+
+headers:
+	for len(buf)-r.off > dataTotal {
+		blockID, err := r.uint8()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case blockID == xlrBlockIDDataShort:
+			n, err := r.uint8()
+			if err != nil {
+				return nil, err
+			}
+			mainDataLen = int(n)
+			break headers
+		case blockID == xlrBlockIDDataLong:
+			n, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			mainDataLen = int(n)
+			break headers
+		case blockID == xlrBlockIDOrigin:
+			if rec.Origin, err = r.uint16(); err != nil {
+				return nil, err
+			}
+		case blockID == xlrBlockIDToplevelXID:
+			if rec.ToplevelXID, err = r.uint32(); err != nil {
+				return nil, err
+			}
+		case blockID <= xlrMaxBlockID:
+			blk, err := r.blockHeader(blockID, pg15Flags)
+			if err != nil {
+				return nil, err
+			}
+			if blk.forkFlags&bkpBlockSameRel == 0 {
+				if blk.Locator, err = r.locator(); err != nil {
+					return nil, err
+				}
+				lastLocator = &blk.Locator
+			} else {
+				if lastLocator == nil {
+					return nil, errors.Errorf("BKPBLOCK_SAME_REL set but no previous rel at %s", lsn)
+				}
+				blk.Locator = *lastLocator
+			}
+			if blk.Block, err = r.uint32(); err != nil {
+				return nil, err
+			}
+			dataTotal += int(blk.imageLen) + int(blk.dataLen)
+			blocks = append(blocks, blk)
+		default:
+			return nil, errors.Errorf("invalid block_id %d at %s", blockID, lsn)
+		}
+	}
+
+	for _, blk := range blocks {
+		var err error
+		if blk.HasImage {
+			if blk.Image, err = r.bytes(int(blk.imageLen)); err != nil {
+				return nil, err
+			}
+		}
+		if blk.dataLen > 0 {
+			if blk.Data, err = r.bytes(int(blk.dataLen)); err != nil {
+				return nil, err
+			}
+		}
+		rec.Blocks = append(rec.Blocks, blk.XLogBlockRef)
+	}
+
+	if mainDataLen > 0 {
+		var err error
+		if rec.MainData, err = r.bytes(mainDataLen); err != nil {
+			return nil, err
+		}
+	}
+	if r.off != len(buf) {
+		return nil, errors.Errorf("record with invalid length at %s", lsn)
+	}
+
+	return rec, nil
+}
+
+type xlogBlockHeader struct {
+	XLogBlockRef
+	forkFlags uint8
+	dataLen   uint16
+	imageLen  uint16
+}
+
+// xlogRecordReader reads little-endian fields from a WAL record with bounds checking.
+type xlogRecordReader struct {
+	buf []byte
+	off int
+	lsn LSN
+}
+
+func (r *xlogRecordReader) bytes(n int) ([]byte, error) {
+	if n > len(r.buf)-r.off {
+		return nil, errors.Errorf("record at %s too short: need %d bytes at offset %d, have %d", r.lsn, n, r.off, len(r.buf)-r.off)
+	}
+	bs := r.buf[r.off : r.off+n]
+	r.off += n
+	return bs, nil
+}
+
+func (r *xlogRecordReader) uint8() (uint8, error) {
+	bs, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return bs[0], nil
+}
+
+func (r *xlogRecordReader) uint16() (uint16, error) {
+	bs, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(bs), nil
+}
+
+func (r *xlogRecordReader) uint32() (uint32, error) {
+	bs, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(bs), nil
+}
+
+func (r *xlogRecordReader) locator() (RelFileLocator, error) {
+	bs, err := r.bytes(12)
+	if err != nil {
+		return RelFileLocator{}, err
+	}
+	return RelFileLocator{
+		SpcOid:    binary.LittleEndian.Uint32(bs[0:]),
+		DbOid:     binary.LittleEndian.Uint32(bs[4:]),
+		RelNumber: binary.LittleEndian.Uint32(bs[8:]),
+	}, nil
+}
+
+func (r *xlogRecordReader) blockHeader(blockID uint8, pg15Flags bool) (xlogBlockHeader, error) {
+	var blk xlogBlockHeader
+	var err error
+	blk.ID = blockID
+
+	if blk.forkFlags, err = r.uint8(); err != nil {
+		return blk, err
+	}
+	blk.Fork = ForkNumber(blk.forkFlags & bkpBlockForkMask)
+	blk.WillInit = blk.forkFlags&bkpBlockWillInit != 0
+	blk.HasImage = blk.forkFlags&bkpBlockHasImage != 0
+
+	if blk.dataLen, err = r.uint16(); err != nil {
+		return blk, err
+	}
+	hasData := blk.forkFlags&bkpBlockHasData != 0
+	if hasData != (blk.dataLen > 0) {
+		return blk, errors.Errorf("BKPBLOCK_HAS_DATA mismatch with data length %d at %s", blk.dataLen, r.lsn)
+	}
+
+	if blk.HasImage {
+		if blk.imageLen, err = r.uint16(); err != nil {
+			return blk, err
+		}
+		if blk.HoleOffset, err = r.uint16(); err != nil {
+			return blk, err
+		}
+		if blk.ImageInfo, err = r.uint8(); err != nil {
+			return blk, err
+		}
+
+		if pg15Flags {
+			blk.ApplyImage = blk.ImageInfo&0x02 != 0
+			blk.Compressed = blk.ImageInfo&(0x04|0x08|0x10) != 0
+		} else {
+			blk.ApplyImage = blk.ImageInfo&0x04 != 0
+			blk.Compressed = blk.ImageInfo&0x02 != 0
+		}
+
+		switch {
+		case blk.Compressed && blk.ImageInfo&bkpImageHasHole != 0:
+			if blk.HoleLength, err = r.uint16(); err != nil {
+				return blk, err
+			}
+		case !blk.Compressed:
+			blk.HoleLength = uint16(xlogBlockSize - int(blk.imageLen))
+		}
+	}
+
+	return blk, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ReadWALSegmentFiles decodes the WAL segment files at paths, which must be consecutive and in order,
+// and calls fn for every record. Reading stops without error at the end of valid WAL.
+func ReadWALSegmentFiles(paths []string, fn func(*XLogRecord) error) error {
+	decoder := NewXLogRecordDecoder()
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if len(data) < xlogLongPageHeaderSize {
+			return errors.Errorf("WAL segment file %s is too short", path)
+		}
+
+		xld := XLogData{WALStart: LSN(binary.LittleEndian.Uint64(data[8:])), Data: data}
+		records, err := decoder.Decode(xld)
+		for _, rec := range records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if err == ErrEndOfWAL {
+			return nil
+		}
+		if err != nil {
+			return errors.Errorf("failed to decode %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
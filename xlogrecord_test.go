@@ -0,0 +1,291 @@
+package pglogrepl_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jackc/pglogrepl"
+)
+
+const testSegmentStart = pglogrepl.LSN(0x1000000)
+
+type testBlock struct {
+	id        uint8
+	forkFlags uint8
+	locator   pglogrepl.RelFileLocator
+	block     uint32
+	image     []byte
+	data      []byte
+}
+
+// buildXLogRecord lays out a WAL record the way XLogRecordAssemble does.
+func buildXLogRecord(rmgr pglogrepl.RmgrID, info uint8, xid uint32, blocks []testBlock, mainData []byte) []byte {
+	var hdrs, payload []byte
+	for _, b := range blocks {
+		flags := b.forkFlags
+		if len(b.data) > 0 {
+			flags |= 0x20
+		}
+		if len(b.image) > 0 {
+			flags |= 0x10
+		}
+		hdrs = append(hdrs, b.id, flags)
+		hdrs = appendUint16LE(hdrs, uint16(len(b.data)))
+		if len(b.image) > 0 {
+			hdrs = appendUint16LE(hdrs, uint16(len(b.image)))
+			hdrs = appendUint16LE(hdrs, 0)
+			hdrs = append(hdrs, 0x04) // BKPIMAGE_APPLY before PostgreSQL 15
+		}
+		if flags&0x80 == 0 {
+			hdrs = appendUint32LE(hdrs, b.locator.SpcOid)
+			hdrs = appendUint32LE(hdrs, b.locator.DbOid)
+			hdrs = appendUint32LE(hdrs, b.locator.RelNumber)
+		}
+		hdrs = appendUint32LE(hdrs, b.block)
+		payload = append(payload, b.image...)
+		payload = append(payload, b.data...)
+	}
+	if len(mainData) > 255 {
+		hdrs = append(hdrs, 254)
+		hdrs = appendUint32LE(hdrs, uint32(len(mainData)))
+	} else if len(mainData) > 0 {
+		hdrs = append(hdrs, 255, uint8(len(mainData)))
+	}
+	payload = append(payload, mainData...)
+
+	rec := make([]byte, 24)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(24+len(hdrs)+len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:], xid)
+	rec[16] = info
+	rec[17] = uint8(rmgr)
+	rec = append(rec, hdrs...)
+	return append(rec, payload...)
+}
+
+// buildWAL writes records into WAL pages starting at a segment boundary and
+// returns the WAL bytes and the LSN of every record.
+func buildWAL(records [][]byte) ([]byte, []pglogrepl.LSN) {
+	const pageSize = 8192
+	var wal []byte
+	var lsns []pglogrepl.LSN
+
+	pageHeader := func(remLen uint32) {
+		pos := len(wal)
+		info := uint16(0)
+		if remLen > 0 {
+			info |= 0x0001
+		}
+		size := 24
+		if pos == 0 {
+			info |= 0x0002
+			size = 40
+		}
+		hdr := make([]byte, size)
+		binary.LittleEndian.PutUint16(hdr[0:], 0xD10D)
+		binary.LittleEndian.PutUint16(hdr[2:], info)
+		binary.LittleEndian.PutUint32(hdr[4:], 1)
+		binary.LittleEndian.PutUint64(hdr[8:], uint64(testSegmentStart)+uint64(pos))
+		binary.LittleEndian.PutUint32(hdr[16:], remLen)
+		if pos == 0 {
+			binary.LittleEndian.PutUint32(hdr[32:], 16*1024*1024)
+			binary.LittleEndian.PutUint32(hdr[36:], pageSize)
+		}
+		wal = append(wal, hdr...)
+	}
+
+	pageHeader(0)
+	for _, rec := range records {
+		for len(wal)%8 != 0 {
+			wal = append(wal, 0)
+		}
+		if len(wal)%pageSize == 0 {
+			pageHeader(0)
+		}
+		lsns = append(lsns, testSegmentStart+pglogrepl.LSN(len(wal)))
+		for len(rec) > 0 {
+			n := pageSize - len(wal)%pageSize
+			if n > len(rec) {
+				n = len(rec)
+			}
+			wal = append(wal, rec[:n]...)
+			rec = rec[n:]
+			if len(rec) > 0 {
+				pageHeader(uint32(len(rec)))
+			}
+		}
+	}
+
+	return wal, lsns
+}
+
+func appendUint16LE(buf []byte, n uint16) []byte {
+	return append(buf, byte(n), byte(n>>8))
+}
+
+func appendUint32LE(buf []byte, n uint32) []byte {
+	return append(buf, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+}
+
+func testWALRecords() [][]byte {
+	rel := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16385}
+	return [][]byte{
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 731, []testBlock{{id: 0, locator: rel, block: 7, data: []byte("tuple")}}, []byte{1, 2, 3}),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x20, 731, []testBlock{
+			{id: 0, locator: rel, block: 8, image: bytes.Repeat([]byte{0xAB}, 6000), data: []byte("new")},
+			{id: 1, forkFlags: 0x80, block: 7},
+		}, bytes.Repeat([]byte{0xCD}, 3000)),
+		buildXLogRecord(pglogrepl.RmgrTransaction, 0x00, 731, nil, []byte{9, 9, 9, 9}),
+	}
+}
+
+func TestXLogRecordDecoder(t *testing.T) {
+	wal, lsns := buildWAL(testWALRecords())
+
+	decoder := pglogrepl.NewXLogRecordDecoder()
+	records, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, lsns[0], records[0].LSN)
+	assert.Equal(t, pglogrepl.RmgrHeap, records[0].Rmgr)
+	assert.Equal(t, uint32(731), records[0].XID)
+	require.Len(t, records[0].Blocks, 1)
+	assert.Equal(t, uint32(16385), records[0].Blocks[0].Locator.RelNumber)
+	assert.Equal(t, uint32(7), records[0].Blocks[0].Block)
+	assert.Equal(t, []byte("tuple"), records[0].Blocks[0].Data)
+	assert.Equal(t, []byte{1, 2, 3}, records[0].MainData)
+
+	assert.Equal(t, lsns[1], records[1].LSN)
+	assert.Equal(t, "UPDATE", records[1].Rmgr.RecordTypeName(records[1].Info))
+	require.Len(t, records[1].Blocks, 2)
+	assert.True(t, records[1].Blocks[0].HasImage)
+	assert.True(t, records[1].Blocks[0].ApplyImage)
+	assert.Equal(t, 6000, records[1].FPILen())
+	assert.Equal(t, records[1].Blocks[0].Locator, records[1].Blocks[1].Locator)
+	assert.Len(t, records[1].MainData, 3000)
+
+	assert.Equal(t, lsns[2], records[2].LSN)
+	assert.Equal(t, pglogrepl.RmgrTransaction, records[2].Rmgr)
+}
+
+func TestXLogRecordDecoderKeepsRecords(t *testing.T) {
+	rel := pglogrepl.RelFileLocator{SpcOid: 1663, DbOid: 16384, RelNumber: 16385}
+	wal, _ := buildWAL([][]byte{
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 731, []testBlock{{id: 0, locator: rel, block: 1, data: []byte{0x11}}}, []byte{0x22}),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 732, []testBlock{{id: 0, locator: rel, block: 2, data: []byte{0x33}}}, []byte{0x44}),
+		buildXLogRecord(pglogrepl.RmgrHeap, 0x00, 733, []testBlock{{id: 0, locator: rel, block: 3, data: []byte{0x55}}}, []byte{0x66}),
+	})
+
+	// The first two records are decoded in one batch, the third by a later call.
+	decoder := pglogrepl.NewXLogRecordDecoder()
+	split := len(wal) - 8
+	records, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal[:split]})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	more, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart + pglogrepl.LSN(split), Data: wal[split:]})
+	require.NoError(t, err)
+	records = append(records, more...)
+	require.Len(t, records, 3)
+
+	for i, want := range [][2]byte{{0x11, 0x22}, {0x33, 0x44}, {0x55, 0x66}} {
+		assert.Equal(t, []byte{want[0]}, records[i].Blocks[0].Data, "record %d", i)
+		assert.Equal(t, []byte{want[1]}, records[i].MainData, "record %d", i)
+	}
+}
+
+func TestXLogRecordDecoderChunked(t *testing.T) {
+	wal, lsns := buildWAL(testWALRecords())
+
+	decoder := pglogrepl.NewXLogRecordDecoder()
+	var records []*pglogrepl.XLogRecord
+	for off := 0; off < len(wal); off += 100 {
+		end := off + 100
+		if end > len(wal) {
+			end = len(wal)
+		}
+		recs, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart + pglogrepl.LSN(off), Data: wal[off:end]})
+		require.NoError(t, err)
+		records = append(records, recs...)
+	}
+
+	require.Len(t, records, 3)
+	for i, rec := range records {
+		assert.Equal(t, lsns[i], rec.LSN)
+	}
+
+	_, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	assert.Error(t, err)
+}
+
+func TestXLogRecordDecoderMidPageStart(t *testing.T) {
+	wal, lsns := buildWAL(testWALRecords())
+
+	// Starting inside the first page skips the record continued on the second page.
+	decoder := pglogrepl.NewXLogRecordDecoder()
+	records, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart + 100, Data: wal[100:]})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, lsns[2], records[0].LSN)
+}
+
+func TestXLogRecordDecoderEndOfWAL(t *testing.T) {
+	wal, _ := buildWAL(testWALRecords())
+	wal = append(wal, make([]byte, 64)...)
+
+	decoder := pglogrepl.NewXLogRecordDecoder()
+	records, err := decoder.Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	assert.Equal(t, pglogrepl.ErrEndOfWAL, err)
+	assert.Len(t, records, 3)
+}
+
+func TestWalStats(t *testing.T) {
+	wal, lsns := buildWAL(testWALRecords())
+
+	records, err := pglogrepl.NewXLogRecordDecoder().Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+
+	stats := pglogrepl.NewWalStats(0, lsns[2])
+	for _, rec := range records {
+		stats.Add(rec)
+	}
+
+	assert.Equal(t, uint64(2), stats.Total.Count)
+	assert.Equal(t, uint64(6000), stats.Total.FPIBytes)
+	assert.Equal(t, uint64(records[0].TotalLen+records[1].TotalLen), stats.Total.CombinedBytes())
+	assert.Equal(t, uint64(2), stats.Rmgrs[pglogrepl.RmgrHeap].Count)
+	assert.Nil(t, stats.Rmgrs[pglogrepl.RmgrTransaction])
+	assert.Equal(t, uint64(1), stats.Records[pglogrepl.WalStatsRecordKey{Rmgr: pglogrepl.RmgrHeap, RecordType: 0x2}].Count)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, stats.WriteReport(buf, true))
+	assert.Contains(t, buf.String(), "Heap/INSERT")
+	assert.Contains(t, buf.String(), "Heap/UPDATE")
+}
+
+func TestWalStatsXactInfo(t *testing.T) {
+	// A commit without and a commit with XLOG_XACT_HAS_INFO are the same record type.
+	wal, _ := buildWAL([][]byte{
+		buildXLogRecord(pglogrepl.RmgrTransaction, 0x00, 731, nil, make([]byte, 8)),
+		buildXLogRecord(pglogrepl.RmgrTransaction, 0x80, 732, nil, make([]byte, 12)),
+		buildXLogRecord(pglogrepl.RmgrTransaction, 0x20, 733, nil, make([]byte, 8)),
+	})
+
+	records, err := pglogrepl.NewXLogRecordDecoder().Decode(pglogrepl.XLogData{WALStart: testSegmentStart, Data: wal})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	stats := pglogrepl.NewWalStats(0, 0)
+	for _, rec := range records {
+		stats.Add(rec)
+	}
+
+	require.Len(t, stats.Records, 2)
+	commit := pglogrepl.WalStatsRecordKey{Rmgr: pglogrepl.RmgrTransaction, RecordType: 0x0}
+	assert.Equal(t, uint64(2), stats.Records[commit].Count)
+	assert.Equal(t, "Transaction/COMMIT", commit.String())
+	assert.Equal(t, uint64(1), stats.Records[pglogrepl.WalStatsRecordKey{Rmgr: pglogrepl.RmgrTransaction, RecordType: 0x2}].Count)
+}